@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
@@ -23,7 +24,10 @@ func main() {
 	}
 
 	e := echo.New()
+	e.JSONSerializer = tax.JSONSerializer{}
+	e.HTTPErrorHandler = tax.ErrorHandler
 
+	e.Use(middleware.RequestID())
 	e.Use(middleware.Recover())
 
 	e.GET("/", func(c echo.Context) error {
@@ -31,20 +35,88 @@ func main() {
 	})
 
 	handler := tax.New(p)
-	admin := e.Group("/admin")
-
-	admin.Use(middleware.BasicAuth(func(username, password string, c echo.Context) (bool, error) {
+	if workers, err := strconv.Atoi(os.Getenv("CSV_WORKERS")); err == nil {
+		handler.WithWorkers(workers)
+	}
+	if limit, err := strconv.Atoi(os.Getenv("BATCH_LIMIT")); err == nil {
+		handler.WithBatchLimit(limit)
+	}
+	entryLimit, _ := strconv.ParseInt(os.Getenv("ARCHIVE_ENTRY_LIMIT"), 10, 64)
+	archiveLimit, _ := strconv.ParseInt(os.Getenv("ARCHIVE_TOTAL_LIMIT"), 10, 64)
+	handler.WithArchiveLimits(entryLimit, archiveLimit)
+	basicAuth := middleware.BasicAuth(func(username, password string, c echo.Context) (bool, error) {
 		if username == os.Getenv("ADMIN_USERNAME") && password == os.Getenv("ADMIN_PASSWORD") {
 			return true, nil
 		}
 		return false, nil
-	}))
+	})
+	admin := e.Group("/admin", basicAuth)
 
-	e.POST("/tax/calculations", handler.CalculateTaxHandler)
-	e.POST("/tax/calculations/upload-csv", handler.CalculateTaxCSVHandler)
+	e.POST("/tax/calculations", handler.CalculateTaxHandler, handler.Idempotent)
+	e.POST("/tax/calculations/upload-csv", handler.CalculateTaxCSVHandler, handler.Idempotent)
+	e.POST("/tax/calculations/batch", handler.CalculateTaxBatchHandler)
+	e.POST("/tax/calculations/ndjson", handler.CalculateTaxNDJSONHandler)
+	e.POST("/tax/calculations/upload-csv/validate", handler.ValidateTaxCSVHandler)
 	admin.POST("/deductions/personal", handler.SettingPersonalDeductionHandler)
 	admin.POST("/deductions/k-receipt", handler.SettingMaxKReceiptHandler)
 
+	// Async uploads only need the database, so they are always available.
+	if err := p.EnableJobs(); err != nil {
+		panic(err)
+	}
+	handler.WithJobs(p)
+	e.GET("/tax/jobs/:jobId", handler.GetJobHandler)
+	e.GET("/tax/jobs/:jobId/result", handler.GetJobResultHandler)
+	e.GET("/tax/jobs/:jobId/errors", handler.ListJobErrorsHandler)
+	e.GET("/tax/jobs/:jobId/events", handler.JobEventsHandler)
+
+	if err := p.EnableIdempotency(); err != nil {
+		panic(err)
+	}
+	handler.WithIdempotency(p)
+
+	if os.Getenv("ENABLE_TAX_HISTORY") == "true" {
+		if err := p.EnableHistory(); err != nil {
+			panic(err)
+		}
+		if err := p.EnableRefunds(); err != nil {
+			panic(err)
+		}
+		if err := p.EnableLedger(); err != nil {
+			panic(err)
+		}
+		if err := p.EnableCertificates(); err != nil {
+			panic(err)
+		}
+		handler.WithHistory(p).WithTaxpayers(p).WithRefunds(p).WithLedger(p).WithCertificates(p)
+
+		// Profiles, history, refund claims with bank accounts, ledgers and
+		// certificates are personal data, so they sit behind the same
+		// credentials as the admin API.
+		taxpayers := e.Group("/taxpayers", basicAuth)
+		taxpayers.POST("", handler.CreateTaxpayerHandler)
+		taxpayers.GET("/:taxpayerId", handler.GetTaxpayerHandler)
+		taxpayers.PUT("/:taxpayerId", handler.UpdateTaxpayerHandler)
+
+		taxpayers.POST("/:taxpayerId/calculations", handler.CreateCalculationHandler)
+		taxpayers.GET("/:taxpayerId/calculations", handler.ListCalculationsHandler)
+		taxpayers.GET("/:taxpayerId/calculations/:calculationId", handler.GetCalculationHandler)
+		taxpayers.POST("/:taxpayerId/calculations/:calculationId/refund-claims", handler.CreateRefundClaimHandler)
+		taxpayers.GET("/:taxpayerId/refund-claims/:claimId", handler.GetRefundClaimHandler)
+		taxpayers.GET("/:taxpayerId/calculations/:calculationId/ledger", handler.GetLedgerHandler)
+		taxpayers.PUT("/:taxpayerId/calculations/:calculationId/ledger", handler.SetInstalmentPlanHandler)
+		taxpayers.POST("/:taxpayerId/calculations/:calculationId/payments", handler.RecordPaymentHandler)
+		taxpayers.POST("/:taxpayerId/certificates", handler.ImportCertificatesHandler)
+		taxpayers.GET("/:taxpayerId/certificates", handler.ListCertificatesHandler)
+		admin.GET("/refund-claims/:claimId", handler.GetRefundClaimHandler)
+		admin.POST("/refund-claims/:claimId/transitions", handler.TransitionRefundClaimHandler)
+	}
+
+	if err := handler.ResumeJobs(); err != nil {
+		panic(err)
+	}
+	go handler.WatchJobs(context.Background())
+
 	port := os.Getenv("PORT")
 
 	go func() {
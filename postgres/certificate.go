@@ -0,0 +1,84 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/hanqqv/assessment-tax/tax"
+)
+
+const certificateSchema = `
+CREATE TABLE IF NOT EXISTS wht_certificates (
+    id BIGSERIAL PRIMARY KEY,
+    taxpayer_id VARCHAR(64) NOT NULL,
+    tax_year INT NOT NULL,
+    payer_tax_id VARCHAR(13) NOT NULL,
+    income_type VARCHAR(8) NOT NULL,
+    amount_paid DECIMAL(12, 2) NOT NULL,
+    tax_withheld DECIMAL(12, 2) NOT NULL,
+    paid_on DATE NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS wht_certificates_unique_idx
+    ON wht_certificates (taxpayer_id, tax_year, payer_tax_id, income_type, paid_on, amount_paid, tax_withheld);
+`
+
+func (p *Postgres) EnableCertificates() error {
+	_, err := p.DB.Exec(certificateSchema)
+	return err
+}
+
+func (p *Postgres) ImportCertificates(certificates []tax.WHTCertificate) ([]tax.WHTCertificate, []tax.WHTCertificate, error) {
+	tx, err := p.DB.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	var imported, duplicates []tax.WHTCertificate
+	for _, certificate := range certificates {
+		row := tx.QueryRow(`INSERT INTO wht_certificates (taxpayer_id, tax_year, payer_tax_id, income_type, amount_paid, tax_withheld, paid_on)
+			VALUES ($1, $2, $3, $4, $5, $6, $7) ON CONFLICT DO NOTHING RETURNING id`,
+			certificate.TaxpayerID, certificate.TaxYear, certificate.PayerTaxID, certificate.IncomeType,
+			certificate.AmountPaid, certificate.TaxWithheld, certificate.Date)
+		err := row.Scan(&certificate.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			duplicates = append(duplicates, certificate)
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		imported = append(imported, certificate)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return imported, duplicates, nil
+}
+
+func (p *Postgres) ListCertificates(taxpayerID string, taxYear int) ([]tax.WHTCertificate, error) {
+	rows, err := p.DB.Query(`SELECT id, taxpayer_id, tax_year, payer_tax_id, income_type, amount_paid, tax_withheld, TO_CHAR(paid_on, 'YYYY-MM-DD')
+		FROM wht_certificates WHERE taxpayer_id = $1 AND tax_year = $2 ORDER BY paid_on, id`, taxpayerID, taxYear)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var certificates []tax.WHTCertificate
+	for rows.Next() {
+		var certificate tax.WHTCertificate
+		err := rows.Scan(&certificate.ID, &certificate.TaxpayerID, &certificate.TaxYear, &certificate.PayerTaxID,
+			&certificate.IncomeType, &certificate.AmountPaid, &certificate.TaxWithheld, &certificate.Date)
+		if err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, certificate)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return certificates, nil
+}
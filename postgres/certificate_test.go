@@ -0,0 +1,39 @@
+// go:build unit
+
+package postgres
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/hanqqv/assessment-tax/tax"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportCertificates(t *testing.T) {
+	t.Run("ImportCertificates Detects Duplicates", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err, "an error was not expected when opening a stub database connection")
+		defer db.Close()
+
+		p := &Postgres{DB: db}
+		certificate := tax.WHTCertificate{TaxpayerID: "1101700230708", TaxYear: 2567, PayerTaxID: "0105556012341", IncomeType: "40(1)", AmountPaid: 50000.0, TaxWithheld: 2500.0, Date: "2024-01-31"}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO wht_certificates").
+			WithArgs("1101700230708", 2567, "0105556012341", "40(1)", 50000.0, 2500.0, "2024-01-31").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery("INSERT INTO wht_certificates").
+			WithArgs("1101700230708", 2567, "0105556012341", "40(1)", 50000.0, 2500.0, "2024-01-31").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectCommit()
+
+		imported, duplicates, err := p.ImportCertificates([]tax.WHTCertificate{certificate, certificate})
+
+		assert.NoError(t, err, "ImportCertificates returned an error: %v", err)
+		assert.Len(t, imported, 1)
+		assert.Equal(t, int64(1), imported[0].ID)
+		assert.Len(t, duplicates, 1)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
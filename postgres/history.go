@@ -0,0 +1,125 @@
+package postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/hanqqv/assessment-tax/tax"
+)
+
+const historySchema = `
+CREATE TABLE IF NOT EXISTS taxpayers (
+    id VARCHAR(64) PRIMARY KEY,
+    name TEXT NOT NULL,
+    address TEXT NOT NULL,
+    filing_status VARCHAR(32) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- A calculation belongs to the taxpayer id it was made for and links to the
+-- profile of that id, if there is one.
+CREATE TABLE IF NOT EXISTS calculations (
+    id BIGSERIAL PRIMARY KEY,
+    taxpayer_id VARCHAR(64) NOT NULL,
+    profile_id VARCHAR(64) REFERENCES taxpayers (id),
+    tax_year INT NOT NULL,
+    user_info JSONB NOT NULL,
+    result JSONB NOT NULL,
+    personal_deduction DECIMAL(10, 2) NOT NULL,
+    max_k_receipt DECIMAL(10, 2) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS calculations_taxpayer_year_idx ON calculations (taxpayer_id, tax_year, created_at DESC);
+`
+
+func (p *Postgres) EnableHistory() error {
+	_, err := p.DB.Exec(historySchema)
+	return err
+}
+
+func (p *Postgres) SaveCalculation(calculation tax.Calculation) (tax.Calculation, error) {
+	userInfo, err := json.Marshal(calculation.UserInfo)
+	if err != nil {
+		return tax.Calculation{}, err
+	}
+	result, err := json.Marshal(calculation.Tax)
+	if err != nil {
+		return tax.Calculation{}, err
+	}
+
+	row := p.DB.QueryRow(`INSERT INTO calculations (taxpayer_id, profile_id, tax_year, user_info, result, personal_deduction, max_k_receipt)
+		VALUES ($1, (SELECT id FROM taxpayers WHERE id = $1), $2, $3, $4, $5, $6)
+		RETURNING id, created_at`,
+		calculation.TaxpayerID, calculation.TaxYear, userInfo, result, calculation.Settings.PersonalDeduction, calculation.Settings.KReceipt)
+	if err := row.Scan(&calculation.ID, &calculation.CreatedAt); err != nil {
+		return tax.Calculation{}, err
+	}
+	return calculation, nil
+}
+
+func (p *Postgres) ListCalculations(filter tax.CalculationFilter) ([]tax.Calculation, int, error) {
+	row := p.DB.QueryRow("SELECT COUNT(*) FROM calculations WHERE taxpayer_id = $1 AND ($2 = 0 OR tax_year = $2)", filter.TaxpayerID, filter.TaxYear)
+	var total int
+	if err := row.Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := p.DB.Query(`SELECT id, taxpayer_id, tax_year, user_info, result, personal_deduction, max_k_receipt, created_at
+		FROM calculations WHERE taxpayer_id = $1 AND ($2 = 0 OR tax_year = $2)
+		ORDER BY created_at DESC, id DESC LIMIT $3 OFFSET $4`,
+		filter.TaxpayerID, filter.TaxYear, filter.PageSize, (filter.Page-1)*filter.PageSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var calculations []tax.Calculation
+	for rows.Next() {
+		calculation, err := scanCalculation(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		calculations = append(calculations, calculation)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return calculations, total, nil
+}
+
+func (p *Postgres) GetCalculation(taxpayerID string, id int64) (tax.Calculation, error) {
+	row := p.DB.QueryRow(`SELECT id, taxpayer_id, tax_year, user_info, result, personal_deduction, max_k_receipt, created_at
+		FROM calculations WHERE taxpayer_id = $1 AND id = $2`, taxpayerID, id)
+	calculation, err := scanCalculation(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return tax.Calculation{}, tax.ErrNotFound
+	}
+	if err != nil {
+		return tax.Calculation{}, err
+	}
+	return calculation, nil
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanCalculation(s scanner) (tax.Calculation, error) {
+	var calculation tax.Calculation
+	var userInfo, result []byte
+	err := s.Scan(&calculation.ID, &calculation.TaxpayerID, &calculation.TaxYear, &userInfo, &result,
+		&calculation.Settings.PersonalDeduction, &calculation.Settings.KReceipt, &calculation.CreatedAt)
+	if err != nil {
+		return tax.Calculation{}, err
+	}
+	if err := json.Unmarshal(userInfo, &calculation.UserInfo); err != nil {
+		return tax.Calculation{}, err
+	}
+	if err := json.Unmarshal(result, &calculation.Tax); err != nil {
+		return tax.Calculation{}, err
+	}
+	return calculation, nil
+}
@@ -0,0 +1,92 @@
+// go:build unit
+
+package postgres
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/hanqqv/assessment-tax/tax"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSaveCalculation(t *testing.T) {
+	t.Run("SaveCalculation Success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err, "an error was not expected when opening a stub database connection")
+		defer db.Close()
+
+		p := &Postgres{DB: db}
+		createdAt := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+
+		mock.ExpectQuery("INSERT INTO calculations").
+			WithArgs("1101700230708", 2567, sqlmock.AnyArg(), sqlmock.AnyArg(), 60000.0, 50000.0).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, createdAt))
+
+		got, err := p.SaveCalculation(tax.Calculation{
+			TaxpayerID: "1101700230708",
+			TaxYear:    2567,
+			UserInfo:   tax.UserInfo{TotalIncome: 500000.0},
+			Tax:        tax.Tax{Tax: 29000.0},
+			Settings:   tax.Settings{PersonalDeduction: 60000.0, KReceipt: 50000.0},
+		})
+
+		assert.NoError(t, err, "SaveCalculation returned an error: %v", err)
+		assert.Equal(t, int64(1), got.ID)
+		assert.Equal(t, tax.Settings{PersonalDeduction: 60000.0, KReceipt: 50000.0}, got.Settings)
+		assert.Equal(t, createdAt, got.CreatedAt)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestListCalculations(t *testing.T) {
+	t.Run("ListCalculations Success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err, "an error was not expected when opening a stub database connection")
+		defer db.Close()
+
+		p := &Postgres{DB: db}
+		createdAt := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+
+		mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM calculations").
+			WithArgs("1101700230708", 2567).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+		mock.ExpectQuery("SELECT id, taxpayer_id, tax_year, user_info, result, personal_deduction, max_k_receipt, created_at").
+			WithArgs("1101700230708", 2567, 2, 2).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "taxpayer_id", "tax_year", "user_info", "result", "personal_deduction", "max_k_receipt", "created_at"}).
+				AddRow(3, "1101700230708", 2567, []byte(`{"totalIncome":500000,"wht":0,"allowances":null}`), []byte(`{"tax":29000,"taxLevel":null}`), 60000.0, 50000.0, createdAt))
+
+		got, total, err := p.ListCalculations(tax.CalculationFilter{TaxpayerID: "1101700230708", TaxYear: 2567, Page: 2, PageSize: 2})
+
+		assert.NoError(t, err, "ListCalculations returned an error: %v", err)
+		assert.Equal(t, 3, total)
+		assert.Equal(t, []tax.Calculation{{
+			ID:         3,
+			TaxpayerID: "1101700230708",
+			TaxYear:    2567,
+			UserInfo:   tax.UserInfo{TotalIncome: 500000.0},
+			Tax:        tax.Tax{Tax: 29000.0},
+			Settings:   tax.Settings{PersonalDeduction: 60000.0, KReceipt: 50000.0},
+			CreatedAt:  createdAt,
+		}}, got)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestGetCalculation(t *testing.T) {
+	t.Run("GetCalculation Not Found", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err, "an error was not expected when opening a stub database connection")
+		defer db.Close()
+
+		p := &Postgres{DB: db}
+
+		mock.ExpectQuery("SELECT id, taxpayer_id, tax_year, user_info, result, personal_deduction, max_k_receipt, created_at").
+			WithArgs("1101700230708", int64(9)).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		_, err = p.GetCalculation("1101700230708", 9)
+		assert.ErrorIs(t, err, tax.ErrNotFound)
+	})
+}
@@ -0,0 +1,73 @@
+package postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/hanqqv/assessment-tax/tax"
+)
+
+const idempotencySchema = `
+-- Keys are scoped to a route, so the same key may be used on each.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    scope VARCHAR(255) NOT NULL,
+    key VARCHAR(255) NOT NULL,
+    request_hash CHAR(64) NOT NULL,
+    status INT NOT NULL DEFAULT 0,
+    headers JSONB NOT NULL DEFAULT '{}',
+    body BYTEA,
+    oversized BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (scope, key)
+);
+`
+
+func (p *Postgres) EnableIdempotency() error {
+	_, err := p.DB.Exec(idempotencySchema)
+	return err
+}
+
+// ReserveIdempotencyKey takes over a key whose request stopped without
+// finishing or whose response has expired, as if it were new.
+func (p *Postgres) ReserveIdempotencyKey(scope, key, hash string, lease, ttl time.Duration) (tax.IdempotentResponse, error) {
+	row := p.DB.QueryRow(`INSERT INTO idempotency_keys (scope, key, request_hash) VALUES ($1, $2, $3)
+		ON CONFLICT (scope, key) DO UPDATE SET request_hash = EXCLUDED.request_hash, status = 0, headers = '{}', body = NULL, oversized = FALSE, created_at = CURRENT_TIMESTAMP
+		WHERE (idempotency_keys.status = 0 AND idempotency_keys.created_at < CURRENT_TIMESTAMP - $4 * INTERVAL '1 second')
+			OR idempotency_keys.created_at < CURRENT_TIMESTAMP - $5 * INTERVAL '1 second'
+		RETURNING created_at`, scope, key, hash, lease.Seconds(), ttl.Seconds())
+	response := tax.IdempotentResponse{Scope: scope, Key: key, Hash: hash}
+	err := row.Scan(&response.CreatedAt)
+	if err == nil {
+		return response, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return tax.IdempotentResponse{}, err
+	}
+
+	row = p.DB.QueryRow("SELECT request_hash, status, headers, body, oversized, created_at FROM idempotency_keys WHERE scope = $1 AND key = $2", scope, key)
+	var headers []byte
+	if err := row.Scan(&response.Hash, &response.Status, &headers, &response.Body, &response.Oversized, &response.CreatedAt); err != nil {
+		return tax.IdempotentResponse{}, err
+	}
+	if err := json.Unmarshal(headers, &response.Header); err != nil {
+		return tax.IdempotentResponse{}, err
+	}
+	return response, tax.ErrAlreadyExists
+}
+
+func (p *Postgres) CompleteIdempotencyKey(response tax.IdempotentResponse) error {
+	headers, err := json.Marshal(response.Header)
+	if err != nil {
+		return err
+	}
+	_, err = p.DB.Exec("UPDATE idempotency_keys SET status = $3, headers = $4, body = $5, oversized = $6 WHERE scope = $1 AND key = $2",
+		response.Scope, response.Key, response.Status, headers, response.Body, response.Oversized)
+	return err
+}
+
+func (p *Postgres) ReleaseIdempotencyKey(scope, key string) error {
+	_, err := p.DB.Exec("DELETE FROM idempotency_keys WHERE scope = $1 AND key = $2 AND status = 0", scope, key)
+	return err
+}
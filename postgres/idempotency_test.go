@@ -0,0 +1,93 @@
+// go:build unit
+
+package postgres
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/hanqqv/assessment-tax/tax"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReserveIdempotencyKey(t *testing.T) {
+	t.Run("ReserveIdempotencyKey New Key", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err, "an error was not expected when opening a stub database connection")
+		defer db.Close()
+
+		p := &Postgres{DB: db}
+		now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+		mock.ExpectQuery("INSERT INTO idempotency_keys \\(scope, key, request_hash\\) VALUES \\(\\$1, \\$2, \\$3\\)").
+			WithArgs("POST /tax/calculations/upload-csv", "payroll-2024-03", "abc", 600.0, 86400.0).
+			WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(now))
+
+		got, err := p.ReserveIdempotencyKey("POST /tax/calculations/upload-csv", "payroll-2024-03", "abc", 10*time.Minute, 24*time.Hour)
+
+		assert.NoError(t, err, "ReserveIdempotencyKey returned an error: %v", err)
+		assert.Equal(t, tax.IdempotentResponse{Scope: "POST /tax/calculations/upload-csv", Key: "payroll-2024-03", Hash: "abc", CreatedAt: now}, got)
+	})
+	t.Run("ReserveIdempotencyKey Existing Key", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err, "an error was not expected when opening a stub database connection")
+		defer db.Close()
+
+		p := &Postgres{DB: db}
+		now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+		mock.ExpectQuery("INSERT INTO idempotency_keys").
+			WithArgs("POST /tax/calculations/upload-csv", "payroll-2024-03", "abc", 600.0, 86400.0).
+			WillReturnRows(sqlmock.NewRows([]string{"created_at"}))
+		mock.ExpectQuery("SELECT request_hash, status, headers, body, oversized, created_at FROM idempotency_keys WHERE scope = \\$1 AND key = \\$2").
+			WithArgs("POST /tax/calculations/upload-csv", "payroll-2024-03").
+			WillReturnRows(sqlmock.NewRows([]string{"request_hash", "status", "headers", "body", "oversized", "created_at"}).
+				AddRow("abc", 200, []byte(`{"Content-Type":"application/json"}`), []byte(`{"tax":0}`), false, now))
+
+		got, err := p.ReserveIdempotencyKey("POST /tax/calculations/upload-csv", "payroll-2024-03", "abc", 10*time.Minute, 24*time.Hour)
+
+		assert.ErrorIs(t, err, tax.ErrAlreadyExists)
+		assert.Equal(t, tax.IdempotentResponse{Scope: "POST /tax/calculations/upload-csv", Key: "payroll-2024-03", Hash: "abc", Status: 200,
+			Header: map[string]string{"Content-Type": "application/json"}, Body: []byte(`{"tax":0}`), CreatedAt: now}, got)
+	})
+}
+
+func TestCompleteIdempotencyKey(t *testing.T) {
+	t.Run("CompleteIdempotencyKey Success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err, "an error was not expected when opening a stub database connection")
+		defer db.Close()
+
+		p := &Postgres{DB: db}
+
+		mock.ExpectExec("UPDATE idempotency_keys SET status = \\$3, headers = \\$4, body = \\$5, oversized = \\$6 WHERE scope = \\$1 AND key = \\$2").
+			WithArgs("POST /tax/calculations/upload-csv", "payroll-2024-03", 200, []byte(`{"Content-Type":"application/json"}`), []byte(`{"tax":0}`), false).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		err = p.CompleteIdempotencyKey(tax.IdempotentResponse{Scope: "POST /tax/calculations/upload-csv", Key: "payroll-2024-03", Status: 200,
+			Header: map[string]string{"Content-Type": "application/json"}, Body: []byte(`{"tax":0}`)})
+
+		assert.NoError(t, err, "CompleteIdempotencyKey returned an error: %v", err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestReleaseIdempotencyKey(t *testing.T) {
+	t.Run("ReleaseIdempotencyKey Success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err, "an error was not expected when opening a stub database connection")
+		defer db.Close()
+
+		p := &Postgres{DB: db}
+
+		mock.ExpectExec("DELETE FROM idempotency_keys WHERE scope = \\$1 AND key = \\$2 AND status = 0").
+			WithArgs("POST /tax/calculations", "calc-1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		err = p.ReleaseIdempotencyKey("POST /tax/calculations", "calc-1")
+
+		assert.NoError(t, err, "ReleaseIdempotencyKey returned an error: %v", err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,344 @@
+package postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/hanqqv/assessment-tax/tax"
+	"github.com/lib/pq"
+)
+
+const jobSchema = `
+CREATE TABLE IF NOT EXISTS csv_jobs (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    status VARCHAR(16) NOT NULL,
+    mode VARCHAR(16) NOT NULL,
+    sheet TEXT NOT NULL DEFAULT '',
+    encoding VARCHAR(16) NOT NULL DEFAULT '',
+    delimiter VARCHAR(16) NOT NULL DEFAULT '',
+    passthrough TEXT[] NOT NULL DEFAULT '{}',
+    processed_rows INT NOT NULL DEFAULT 0,
+    succeeded_rows INT NOT NULL DEFAULT 0,
+    failed_rows INT NOT NULL DEFAULT 0,
+    message TEXT NOT NULL DEFAULT '',
+    message_key TEXT NOT NULL DEFAULT '',
+    message_args TEXT[] NOT NULL DEFAULT '{}',
+    claim UUID,
+    result JSONB,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS csv_job_inputs (
+    job_id UUID NOT NULL REFERENCES csv_jobs (id) ON DELETE CASCADE,
+    seq INT NOT NULL,
+    data BYTEA NOT NULL,
+    PRIMARY KEY (job_id, seq)
+);
+
+CREATE TABLE IF NOT EXISTS csv_job_rows (
+    job_id UUID NOT NULL REFERENCES csv_jobs (id) ON DELETE CASCADE,
+    row_number INT NOT NULL,
+    data JSONB NOT NULL,
+    PRIMARY KEY (job_id, row_number)
+);
+
+CREATE INDEX IF NOT EXISTS csv_jobs_status_idx ON csv_jobs (status);
+`
+
+// jobInputChunk is the size of the pieces a job input is stored in.
+const jobInputChunk = 1 << 20
+
+func (p *Postgres) EnableJobs() error {
+	_, err := p.DB.Exec(jobSchema)
+	return err
+}
+
+func (p *Postgres) CreateJob(job tax.Job, input io.Reader) (tax.Job, error) {
+	passthrough := job.Passthrough
+	if passthrough == nil {
+		passthrough = []string{}
+	}
+
+	tx, err := p.DB.Begin()
+	if err != nil {
+		return tax.Job{}, err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRow(`INSERT INTO csv_jobs (status, mode, sheet, encoding, delimiter, passthrough) VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at, updated_at`,
+		job.Status, job.Mode, job.Sheet, job.Encoding, job.Delimiter, pq.Array(passthrough))
+	if err := row.Scan(&job.ID, &job.CreatedAt, &job.UpdatedAt); err != nil {
+		return tax.Job{}, err
+	}
+
+	chunk := make([]byte, jobInputChunk)
+	for seq := 1; ; seq++ {
+		n, err := io.ReadFull(input, chunk)
+		if n > 0 {
+			if _, err := tx.Exec("INSERT INTO csv_job_inputs (job_id, seq, data) VALUES ($1, $2, $3)", job.ID, seq, chunk[:n]); err != nil {
+				return tax.Job{}, err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return tax.Job{}, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return tax.Job{}, err
+	}
+	return job, nil
+}
+
+func (p *Postgres) GetJob(id string) (tax.Job, error) {
+	row := p.DB.QueryRow(`SELECT id, status, mode, sheet, encoding, delimiter, passthrough, processed_rows, succeeded_rows, failed_rows, message, message_key, message_args, created_at, updated_at
+		FROM csv_jobs WHERE id = $1`, id)
+	var job tax.Job
+	var text tax.Text
+	err := row.Scan(&job.ID, &job.Status, &job.Mode, &job.Sheet, &job.Encoding, &job.Delimiter, pq.Array(&job.Passthrough), &job.Processed, &job.Succeeded, &job.Failed, &job.Message, &text.Key, pq.Array(&text.Args), &job.CreatedAt, &job.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return tax.Job{}, tax.ErrNotFound
+	}
+	if err != nil {
+		return tax.Job{}, err
+	}
+	if text.Key != "" {
+		job.MessageText = text
+	}
+	return job, nil
+}
+
+func (p *Postgres) ClaimJob(id string, lease time.Duration) (tax.Job, error) {
+	tx, err := p.DB.Begin()
+	if err != nil {
+		return tax.Job{}, err
+	}
+	defer tx.Rollback()
+
+	// The WHERE clause is checked again once a concurrent claim commits, so
+	// only one of them gets the row back. A new claim fences off the writes
+	// of the run it takes over.
+	row := tx.QueryRow(`UPDATE csv_jobs SET status = $2, processed_rows = 0, succeeded_rows = 0, failed_rows = 0, message = '', message_key = '', message_args = '{}',
+		claim = gen_random_uuid(), updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND (status = $3 OR (status = $2 AND updated_at < CURRENT_TIMESTAMP - $4 * INTERVAL '1 second'))
+		RETURNING id, status, mode, sheet, encoding, delimiter, passthrough, claim, created_at, updated_at`,
+		id, tax.JobStatusRunning, tax.JobStatusQueued, lease.Seconds())
+	var job tax.Job
+	err = row.Scan(&job.ID, &job.Status, &job.Mode, &job.Sheet, &job.Encoding, &job.Delimiter, pq.Array(&job.Passthrough), &job.Claim, &job.CreatedAt, &job.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return tax.Job{}, tax.ErrConflict
+	}
+	if err != nil {
+		return tax.Job{}, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM csv_job_rows WHERE job_id = $1", id); err != nil {
+		return tax.Job{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return tax.Job{}, err
+	}
+	return job, nil
+}
+
+func (p *Postgres) TouchJob(id, claim string) error {
+	result, err := p.DB.Exec("UPDATE csv_jobs SET updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND claim = $2 AND status = $3", id, claim, tax.JobStatusRunning)
+	return claimed(result, err)
+}
+
+// OpenJobInput reads the input of a job back one chunk at a time.
+func (p *Postgres) OpenJobInput(id string) (io.ReadCloser, error) {
+	return &jobInputReader{db: p.DB, id: id}, nil
+}
+
+type jobInputReader struct {
+	db    *sql.DB
+	id    string
+	seq   int
+	chunk []byte
+	done  bool
+}
+
+func (r *jobInputReader) Read(b []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		r.seq++
+		err := r.db.QueryRow("SELECT data FROM csv_job_inputs WHERE job_id = $1 AND seq = $2", r.id, r.seq).Scan(&r.chunk)
+		if errors.Is(err, sql.ErrNoRows) {
+			r.done = true
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(b, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+func (r *jobInputReader) Close() error {
+	r.done, r.chunk = true, nil
+	return nil
+}
+
+func (p *Postgres) UpdateJob(job tax.Job) error {
+	result, err := p.DB.Exec(`UPDATE csv_jobs SET status = $2, processed_rows = $3, succeeded_rows = $4, failed_rows = $5, message = $6,
+		message_key = $7, message_args = $8, updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND claim = $9`,
+		job.ID, job.Status, job.Processed, job.Succeeded, job.Failed, job.Message, job.MessageText.Key, pq.Array(messageArgs(job.MessageText)), job.Claim)
+	return claimed(result, err)
+}
+
+func (p *Postgres) AppendJobRows(id, claim string, first int, rows [][]byte) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	tx, err := p.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Touching the job locks it, so a claim by another run waits for these
+	// rows and then deletes them with the rest.
+	result, err := tx.Exec("UPDATE csv_jobs SET updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND claim = $2", id, claim)
+	if err := claimed(result, err); err != nil {
+		return err
+	}
+
+	for i, data := range rows {
+		if _, err := tx.Exec("INSERT INTO csv_job_rows (job_id, row_number, data) VALUES ($1, $2, $3)", id, first+i, data); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (p *Postgres) ListJobRows(id string, after int, limit int) ([][]byte, error) {
+	rows, err := p.DB.Query("SELECT data FROM csv_job_rows WHERE job_id = $1 AND row_number > $2 ORDER BY row_number LIMIT $3", id, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list [][]byte
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		list = append(list, data)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (p *Postgres) ListJobErrors(id string, offset int, limit int) ([]tax.RowError, error) {
+	rows, err := p.DB.Query(`SELECT data->'error', data->'errorText' FROM csv_job_rows WHERE job_id = $1 AND data->'error' IS NOT NULL
+		ORDER BY row_number OFFSET $2 LIMIT $3`, id, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []tax.RowError
+	for rows.Next() {
+		var data, text []byte
+		if err := rows.Scan(&data, &text); err != nil {
+			return nil, err
+		}
+		var rowErr tax.RowError
+		if err := json.Unmarshal(data, &rowErr); err != nil {
+			return nil, err
+		}
+		if text != nil {
+			if err := json.Unmarshal(text, &rowErr.Text); err != nil {
+				return nil, err
+			}
+		}
+		list = append(list, rowErr)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (p *Postgres) CompleteJob(job tax.Job, result []byte) error {
+	res, err := p.DB.Exec(`UPDATE csv_jobs SET status = $2, processed_rows = $3, succeeded_rows = $4, failed_rows = $5, message = $6,
+		message_key = $7, message_args = $8, result = $9, updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND claim = $10`,
+		job.ID, job.Status, job.Processed, job.Succeeded, job.Failed, job.Message, job.MessageText.Key, pq.Array(messageArgs(job.MessageText)), result, job.Claim)
+	return claimed(res, err)
+}
+
+// claimed turns a write that matched no row, because another run has
+// claimed the job since, into tax.ErrConflict.
+func claimed(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return tax.ErrConflict
+	}
+	return nil
+}
+
+// messageArgs keeps message_args NOT NULL for a message without arguments.
+func messageArgs(text tax.Text) []string {
+	if text.Args == nil {
+		return []string{}
+	}
+	return text.Args
+}
+
+func (p *Postgres) GetJobResult(id string) ([]byte, error) {
+	row := p.DB.QueryRow("SELECT result FROM csv_jobs WHERE id = $1 AND result IS NOT NULL", id)
+	var result []byte
+	err := row.Scan(&result)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, tax.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (p *Postgres) ListUnfinishedJobs() ([]tax.Job, error) {
+	rows, err := p.DB.Query("SELECT id, status, mode, sheet, encoding, delimiter, passthrough FROM csv_jobs WHERE status IN ($1, $2) ORDER BY id",
+		tax.JobStatusQueued, tax.JobStatusRunning)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []tax.Job
+	for rows.Next() {
+		var job tax.Job
+		if err := rows.Scan(&job.ID, &job.Status, &job.Mode, &job.Sheet, &job.Encoding, &job.Delimiter, pq.Array(&job.Passthrough)); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
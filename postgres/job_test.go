@@ -0,0 +1,320 @@
+// go:build unit
+
+package postgres
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/hanqqv/assessment-tax/tax"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	jobID = "6f1c2b9e-3d4a-4c8e-9b7a-2e5f1d0c8a34"
+	claim = "0b8d7e1a-9c2f-4e6b-a5d3-7f4c1e2b9a60"
+)
+
+func TestCreateJob(t *testing.T) {
+	t.Run("CreateJob Success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err, "an error was not expected when opening a stub database connection")
+		defer db.Close()
+
+		p := &Postgres{DB: db}
+		now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO csv_jobs \\(status, mode, sheet, encoding, delimiter, passthrough\\) VALUES \\(\\$1, \\$2, \\$3, \\$4, \\$5, \\$6\\)").
+			WithArgs(tax.JobStatusQueued, tax.ModeStrict, "", tax.EncodingTIS620, "semicolon", "{}").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(jobID, now, now))
+		mock.ExpectExec("INSERT INTO csv_job_inputs \\(job_id, seq, data\\)").
+			WithArgs(jobID, 1, []byte("totalIncome,wht\n")).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		got, err := p.CreateJob(tax.Job{Status: tax.JobStatusQueued, Mode: tax.ModeStrict, Encoding: tax.EncodingTIS620, Delimiter: "semicolon"}, strings.NewReader("totalIncome,wht\n"))
+
+		assert.NoError(t, err, "CreateJob returned an error: %v", err)
+		assert.Equal(t, jobID, got.ID)
+		assert.Equal(t, now, got.CreatedAt)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestOpenJobInput(t *testing.T) {
+	t.Run("OpenJobInput Reads Every Chunk", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err, "an error was not expected when opening a stub database connection")
+		defer db.Close()
+
+		p := &Postgres{DB: db}
+
+		mock.ExpectQuery("SELECT data FROM csv_job_inputs WHERE job_id = \\$1 AND seq = \\$2").
+			WithArgs(jobID, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"data"}).AddRow([]byte("totalIncome,")))
+		mock.ExpectQuery("SELECT data FROM csv_job_inputs WHERE job_id = \\$1 AND seq = \\$2").
+			WithArgs(jobID, 2).
+			WillReturnRows(sqlmock.NewRows([]string{"data"}).AddRow([]byte("wht\n")))
+		mock.ExpectQuery("SELECT data FROM csv_job_inputs WHERE job_id = \\$1 AND seq = \\$2").
+			WithArgs(jobID, 3).
+			WillReturnRows(sqlmock.NewRows([]string{"data"}))
+
+		input, err := p.OpenJobInput(jobID)
+		assert.NoError(t, err)
+		got, err := io.ReadAll(input)
+
+		assert.NoError(t, err, "reading the job input returned an error: %v", err)
+		assert.Equal(t, "totalIncome,wht\n", string(got))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestClaimJob(t *testing.T) {
+	t.Run("ClaimJob Success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err, "an error was not expected when opening a stub database connection")
+		defer db.Close()
+
+		p := &Postgres{DB: db}
+		now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("UPDATE csv_jobs SET status = \\$2").
+			WithArgs(jobID, tax.JobStatusRunning, tax.JobStatusQueued, 60.0).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "status", "mode", "sheet", "encoding", "delimiter", "passthrough", "claim", "created_at", "updated_at"}).
+				AddRow(jobID, tax.JobStatusRunning, tax.ModeLenient, "", "", "", "{}", claim, now, now))
+		mock.ExpectExec("DELETE FROM csv_job_rows WHERE job_id = \\$1").
+			WithArgs(jobID).
+			WillReturnResult(sqlmock.NewResult(0, 3))
+		mock.ExpectCommit()
+
+		got, err := p.ClaimJob(jobID, time.Minute)
+
+		assert.NoError(t, err, "ClaimJob returned an error: %v", err)
+		assert.Equal(t, tax.Job{ID: jobID, Status: tax.JobStatusRunning, Mode: tax.ModeLenient, Passthrough: []string{}, Claim: claim, CreatedAt: now, UpdatedAt: now}, got)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+	t.Run("ClaimJob Held By Another Run", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err, "an error was not expected when opening a stub database connection")
+		defer db.Close()
+
+		p := &Postgres{DB: db}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("UPDATE csv_jobs SET status = \\$2").
+			WithArgs(jobID, tax.JobStatusRunning, tax.JobStatusQueued, 60.0).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectRollback()
+
+		_, err = p.ClaimJob(jobID, time.Minute)
+
+		assert.ErrorIs(t, err, tax.ErrConflict)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestAppendJobRows(t *testing.T) {
+	t.Run("AppendJobRows Success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err, "an error was not expected when opening a stub database connection")
+		defer db.Close()
+
+		p := &Postgres{DB: db}
+
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE csv_jobs SET updated_at = CURRENT_TIMESTAMP WHERE id = \\$1 AND claim = \\$2").
+			WithArgs(jobID, claim).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO csv_job_rows \\(job_id, row_number, data\\)").
+			WithArgs(jobID, 101, []byte(`{"record":["1000","0"]}`)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO csv_job_rows \\(job_id, row_number, data\\)").
+			WithArgs(jobID, 102, []byte(`{"record":["2000","0"]}`)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		err = p.AppendJobRows(jobID, claim, 101, [][]byte{[]byte(`{"record":["1000","0"]}`), []byte(`{"record":["2000","0"]}`)})
+
+		assert.NoError(t, err, "AppendJobRows returned an error: %v", err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+	t.Run("AppendJobRows Claim Lost", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err, "an error was not expected when opening a stub database connection")
+		defer db.Close()
+
+		p := &Postgres{DB: db}
+
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE csv_jobs SET updated_at = CURRENT_TIMESTAMP WHERE id = \\$1 AND claim = \\$2").
+			WithArgs(jobID, claim).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		err = p.AppendJobRows(jobID, claim, 101, [][]byte{[]byte(`{"record":["1000","0"]}`)})
+
+		assert.ErrorIs(t, err, tax.ErrConflict)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestTouchJob(t *testing.T) {
+	t.Run("TouchJob Claim Lost", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err, "an error was not expected when opening a stub database connection")
+		defer db.Close()
+
+		p := &Postgres{DB: db}
+
+		mock.ExpectExec("UPDATE csv_jobs SET updated_at = CURRENT_TIMESTAMP WHERE id = \\$1 AND claim = \\$2 AND status = \\$3").
+			WithArgs(jobID, claim, tax.JobStatusRunning).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		err = p.TouchJob(jobID, claim)
+
+		assert.ErrorIs(t, err, tax.ErrConflict)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestGetJob(t *testing.T) {
+	t.Run("GetJob Success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err, "an error was not expected when opening a stub database connection")
+		defer db.Close()
+
+		p := &Postgres{DB: db}
+		now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+		mock.ExpectQuery("SELECT id, status, mode, sheet, encoding, delimiter, passthrough, processed_rows, succeeded_rows, failed_rows, message, message_key, message_args, created_at, updated_at").
+			WithArgs(jobID).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "status", "mode", "sheet", "encoding", "delimiter", "passthrough", "processed_rows", "succeeded_rows", "failed_rows", "message", "message_key", "message_args", "created_at", "updated_at"}).
+				AddRow(jobID, tax.JobStatusRunning, tax.ModeLenient, "", "", "", "{}", 10, 9, 1, "", "", "{}", now, now))
+
+		got, err := p.GetJob(jobID)
+
+		assert.NoError(t, err, "GetJob returned an error: %v", err)
+		assert.Equal(t, tax.Job{ID: jobID, Status: tax.JobStatusRunning, Mode: tax.ModeLenient, Passthrough: []string{}, Processed: 10, Succeeded: 9, Failed: 1,
+			CreatedAt: now, UpdatedAt: now}, got)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+	t.Run("GetJob Failed With Message Text", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err, "an error was not expected when opening a stub database connection")
+		defer db.Close()
+
+		p := &Postgres{DB: db}
+		now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+		mock.ExpectQuery("SELECT id, status, mode").
+			WithArgs(jobID).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "status", "mode", "sheet", "encoding", "delimiter", "passthrough", "processed_rows", "succeeded_rows", "failed_rows", "message", "message_key", "message_args", "created_at", "updated_at"}).
+				AddRow(jobID, tax.JobStatusFailed, tax.ModeStrict, "Taxes", "", "", "{}", 0, 0, 0, `sheet "Taxes" not found`, "sheet %q not found", "{Taxes}", now, now))
+
+		got, err := p.GetJob(jobID)
+
+		assert.NoError(t, err, "GetJob returned an error: %v", err)
+		assert.Equal(t, `sheet "Taxes" not found`, got.Message)
+		assert.Equal(t, tax.Text{Key: "sheet %q not found", Args: []string{"Taxes"}}, got.MessageText)
+	})
+	t.Run("GetJob Not Found", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err, "an error was not expected when opening a stub database connection")
+		defer db.Close()
+
+		p := &Postgres{DB: db}
+
+		mock.ExpectQuery("SELECT id, status, mode").
+			WithArgs(jobID).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		_, err = p.GetJob(jobID)
+
+		assert.ErrorIs(t, err, tax.ErrNotFound)
+	})
+}
+
+func TestListJobErrors(t *testing.T) {
+	t.Run("ListJobErrors Success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err, "an error was not expected when opening a stub database connection")
+		defer db.Close()
+
+		p := &Postgres{DB: db}
+
+		mock.ExpectQuery("SELECT data->'error', data->'errorText' FROM csv_job_rows WHERE job_id = \\$1 AND data->'error' IS NOT NULL\\s+ORDER BY row_number OFFSET \\$2 LIMIT \\$3").
+			WithArgs(jobID, 20, 20).
+			WillReturnRows(sqlmock.NewRows([]string{"error", "errorText"}).
+				AddRow([]byte(`{"line":3,"message":"invalid file format"}`), nil).
+				AddRow([]byte(`{"line":4,"column":"wht","message":"wht must be a numeric value"}`), []byte(`{"key":"%s must be a numeric value","args":["wht"]}`)))
+
+		got, err := p.ListJobErrors(jobID, 20, 20)
+
+		assert.NoError(t, err, "ListJobErrors returned an error: %v", err)
+		assert.Equal(t, []tax.RowError{
+			{Line: 3, Message: "invalid file format"},
+			{Line: 4, Column: "wht", Message: "wht must be a numeric value", Text: tax.Text{Key: "%s must be a numeric value", Args: []string{"wht"}}},
+		}, got)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestCompleteJob(t *testing.T) {
+	t.Run("CompleteJob Success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err, "an error was not expected when opening a stub database connection")
+		defer db.Close()
+
+		p := &Postgres{DB: db}
+
+		mock.ExpectExec("UPDATE csv_jobs SET status = \\$2").
+			WithArgs(jobID, tax.JobStatusCompleted, 2, 2, 0, "", "", "{}", []byte(`{"columns":[]}`), claim).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		err = p.CompleteJob(tax.Job{ID: jobID, Status: tax.JobStatusCompleted, Processed: 2, Succeeded: 2, Claim: claim}, []byte(`{"columns":[]}`))
+
+		assert.NoError(t, err, "CompleteJob returned an error: %v", err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+	t.Run("CompleteJob Claim Lost", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err, "an error was not expected when opening a stub database connection")
+		defer db.Close()
+
+		p := &Postgres{DB: db}
+
+		mock.ExpectExec("UPDATE csv_jobs SET status = \\$2").
+			WithArgs(jobID, tax.JobStatusCompleted, 2, 2, 0, "", "", "{}", []byte(`{"columns":[]}`), claim).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		err = p.CompleteJob(tax.Job{ID: jobID, Status: tax.JobStatusCompleted, Processed: 2, Succeeded: 2, Claim: claim}, []byte(`{"columns":[]}`))
+
+		assert.ErrorIs(t, err, tax.ErrConflict)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestListUnfinishedJobs(t *testing.T) {
+	t.Run("ListUnfinishedJobs Success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err, "an error was not expected when opening a stub database connection")
+		defer db.Close()
+
+		p := &Postgres{DB: db}
+
+		mock.ExpectQuery("SELECT id, status, mode, sheet, encoding, delimiter, passthrough FROM csv_jobs WHERE status IN \\(\\$1, \\$2\\) ORDER BY id").
+			WithArgs(tax.JobStatusQueued, tax.JobStatusRunning).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "status", "mode", "sheet", "encoding", "delimiter", "passthrough"}).
+				AddRow(jobID, tax.JobStatusRunning, tax.ModeStrict, "Payroll", "", "tab", "{department}"))
+
+		got, err := p.ListUnfinishedJobs()
+
+		assert.NoError(t, err, "ListUnfinishedJobs returned an error: %v", err)
+		assert.Equal(t, []tax.Job{{ID: jobID, Status: tax.JobStatusRunning, Mode: tax.ModeStrict, Sheet: "Payroll", Delimiter: "tab", Passthrough: []string{"department"}}}, got)
+	})
+}
@@ -0,0 +1,106 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/hanqqv/assessment-tax/tax"
+)
+
+const ledgerSchema = `
+CREATE TABLE IF NOT EXISTS instalment_plans (
+    calculation_id BIGINT PRIMARY KEY REFERENCES calculations (id),
+    instalments INT NOT NULL CHECK (instalments BETWEEN 1 AND 3),
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS payments (
+    id BIGSERIAL PRIMARY KEY,
+    calculation_id BIGINT NOT NULL REFERENCES calculations (id),
+    amount DECIMAL(12, 2) NOT NULL CHECK (amount > 0),
+    reference TEXT NOT NULL DEFAULT '',
+    paid_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS payments_calculation_idx ON payments (calculation_id, paid_at);
+`
+
+func (p *Postgres) EnableLedger() error {
+	_, err := p.DB.Exec(ledgerSchema)
+	return err
+}
+
+func (p *Postgres) GetInstalmentPlan(calculationID int64) (int, error) {
+	row := p.DB.QueryRow("SELECT instalments FROM instalment_plans WHERE calculation_id = $1", calculationID)
+	var instalments int
+	err := row.Scan(&instalments)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return instalments, nil
+}
+
+func (p *Postgres) SaveInstalmentPlan(calculationID int64, instalments int) error {
+	_, err := p.DB.Exec(`INSERT INTO instalment_plans (calculation_id, instalments) VALUES ($1, $2)
+		ON CONFLICT (calculation_id) DO UPDATE SET instalments = EXCLUDED.instalments, updated_at = CURRENT_TIMESTAMP`,
+		calculationID, instalments)
+	return err
+}
+
+func (p *Postgres) RecordPayment(payment tax.Payment, taxDue float64) (tax.Payment, error) {
+	tx, err := p.DB.Begin()
+	if err != nil {
+		return tax.Payment{}, err
+	}
+	defer tx.Rollback()
+
+	// Locking the calculation serialises payments against it, so the sum
+	// read below can not change before the insert commits.
+	_, err = tx.Exec("SELECT id FROM calculations WHERE id = $1 FOR UPDATE", payment.CalculationID)
+	if err != nil {
+		return tax.Payment{}, err
+	}
+
+	row := tx.QueryRow(`INSERT INTO payments (calculation_id, amount, reference, paid_at)
+		SELECT $1, $2, $3, $4
+		WHERE (SELECT COALESCE(SUM(amount), 0) FROM payments WHERE calculation_id = $1) + $2 <= $5
+		RETURNING id`,
+		payment.CalculationID, payment.Amount, payment.Reference, payment.PaidAt, taxDue)
+	err = row.Scan(&payment.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return tax.Payment{}, tax.ErrConflict
+	}
+	if err != nil {
+		return tax.Payment{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return tax.Payment{}, err
+	}
+	return payment, nil
+}
+
+func (p *Postgres) ListPayments(calculationID int64) ([]tax.Payment, error) {
+	rows, err := p.DB.Query("SELECT id, calculation_id, amount, reference, paid_at FROM payments WHERE calculation_id = $1 ORDER BY paid_at, id", calculationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var payments []tax.Payment
+	for rows.Next() {
+		var payment tax.Payment
+		if err := rows.Scan(&payment.ID, &payment.CalculationID, &payment.Amount, &payment.Reference, &payment.PaidAt); err != nil {
+			return nil, err
+		}
+		payments = append(payments, payment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return payments, nil
+}
@@ -0,0 +1,95 @@
+// go:build unit
+
+package postgres
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/hanqqv/assessment-tax/tax"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetInstalmentPlan(t *testing.T) {
+	t.Run("GetInstalmentPlan Default", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err, "an error was not expected when opening a stub database connection")
+		defer db.Close()
+
+		p := &Postgres{DB: db}
+
+		mock.ExpectQuery("SELECT instalments FROM instalment_plans WHERE calculation_id = \\$1").
+			WithArgs(int64(7)).
+			WillReturnRows(sqlmock.NewRows([]string{"instalments"}))
+
+		got, err := p.GetInstalmentPlan(7)
+
+		assert.NoError(t, err, "GetInstalmentPlan returned an error: %v", err)
+		assert.Equal(t, 1, got)
+	})
+	t.Run("GetInstalmentPlan Success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err, "an error was not expected when opening a stub database connection")
+		defer db.Close()
+
+		p := &Postgres{DB: db}
+
+		mock.ExpectQuery("SELECT instalments FROM instalment_plans WHERE calculation_id = \\$1").
+			WithArgs(int64(7)).
+			WillReturnRows(sqlmock.NewRows([]string{"instalments"}).AddRow(3))
+
+		got, err := p.GetInstalmentPlan(7)
+
+		assert.NoError(t, err, "GetInstalmentPlan returned an error: %v", err)
+		assert.Equal(t, 3, got)
+	})
+}
+
+func TestRecordPayment(t *testing.T) {
+	paidAt := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("RecordPayment Success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err, "an error was not expected when opening a stub database connection")
+		defer db.Close()
+
+		p := &Postgres{DB: db}
+
+		mock.ExpectBegin()
+		mock.ExpectExec("SELECT id FROM calculations WHERE id = \\$1 FOR UPDATE").
+			WithArgs(int64(7)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("INSERT INTO payments").
+			WithArgs(int64(7), 3000.0, "KTB-001", paidAt, 9000.0).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+
+		got, err := p.RecordPayment(tax.Payment{CalculationID: 7, Amount: 3000.0, Reference: "KTB-001", PaidAt: paidAt}, 9000.0)
+
+		assert.NoError(t, err, "RecordPayment returned an error: %v", err)
+		assert.Equal(t, int64(1), got.ID)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+	t.Run("RecordPayment Exceeds Tax Due", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err, "an error was not expected when opening a stub database connection")
+		defer db.Close()
+
+		p := &Postgres{DB: db}
+
+		mock.ExpectBegin()
+		mock.ExpectExec("SELECT id FROM calculations WHERE id = \\$1 FOR UPDATE").
+			WithArgs(int64(7)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("INSERT INTO payments").
+			WithArgs(int64(7), 3000.0, "KTB-001", paidAt, 9000.0).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectRollback()
+
+		_, err = p.RecordPayment(tax.Payment{CalculationID: 7, Amount: 3000.0, Reference: "KTB-001", PaidAt: paidAt}, 9000.0)
+
+		assert.ErrorIs(t, err, tax.ErrConflict)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,146 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/hanqqv/assessment-tax/tax"
+	"github.com/lib/pq"
+)
+
+const refundSchema = `
+CREATE TABLE IF NOT EXISTS refund_claims (
+    id BIGSERIAL PRIMARY KEY,
+    calculation_id BIGINT NOT NULL UNIQUE REFERENCES calculations (id),
+    taxpayer_id VARCHAR(64) NOT NULL,
+    amount DECIMAL(12, 2) NOT NULL,
+    bank_code VARCHAR(16) NOT NULL,
+    account_number VARCHAR(32) NOT NULL,
+    account_name TEXT NOT NULL,
+    status VARCHAR(32) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS refund_claim_transitions (
+    id BIGSERIAL PRIMARY KEY,
+    claim_id BIGINT NOT NULL REFERENCES refund_claims (id),
+    from_status VARCHAR(32) NOT NULL DEFAULT '',
+    to_status VARCHAR(32) NOT NULL,
+    actor TEXT NOT NULL,
+    note TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+`
+
+const uniqueViolation = "23505"
+
+func (p *Postgres) EnableRefunds() error {
+	_, err := p.DB.Exec(refundSchema)
+	return err
+}
+
+func (p *Postgres) CreateRefundClaim(claim tax.RefundClaim) (tax.RefundClaim, error) {
+	tx, err := p.DB.Begin()
+	if err != nil {
+		return tax.RefundClaim{}, err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRow(`INSERT INTO refund_claims (calculation_id, taxpayer_id, amount, bank_code, account_number, account_name, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at`,
+		claim.CalculationID, claim.TaxpayerID, claim.Amount,
+		claim.BankAccount.BankCode, claim.BankAccount.AccountNumber, claim.BankAccount.AccountName, claim.Status)
+	err = row.Scan(&claim.ID, &claim.CreatedAt)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return tax.RefundClaim{}, tax.ErrAlreadyExists
+	}
+	if err != nil {
+		return tax.RefundClaim{}, err
+	}
+
+	for i, transition := range claim.Transitions {
+		transition, err = insertRefundTransition(tx, claim.ID, transition)
+		if err != nil {
+			return tax.RefundClaim{}, err
+		}
+		claim.Transitions[i] = transition
+	}
+
+	if err := tx.Commit(); err != nil {
+		return tax.RefundClaim{}, err
+	}
+	return claim, nil
+}
+
+func (p *Postgres) GetRefundClaim(id int64) (tax.RefundClaim, error) {
+	row := p.DB.QueryRow(`SELECT id, calculation_id, taxpayer_id, amount, bank_code, account_number, account_name, status, created_at
+		FROM refund_claims WHERE id = $1`, id)
+	var claim tax.RefundClaim
+	err := row.Scan(&claim.ID, &claim.CalculationID, &claim.TaxpayerID, &claim.Amount,
+		&claim.BankAccount.BankCode, &claim.BankAccount.AccountNumber, &claim.BankAccount.AccountName, &claim.Status, &claim.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return tax.RefundClaim{}, tax.ErrNotFound
+	}
+	if err != nil {
+		return tax.RefundClaim{}, err
+	}
+
+	rows, err := p.DB.Query(`SELECT from_status, to_status, actor, note, created_at
+		FROM refund_claim_transitions WHERE claim_id = $1 ORDER BY id`, id)
+	if err != nil {
+		return tax.RefundClaim{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var transition tax.RefundTransition
+		if err := rows.Scan(&transition.From, &transition.To, &transition.Actor, &transition.Note, &transition.CreatedAt); err != nil {
+			return tax.RefundClaim{}, err
+		}
+		claim.Transitions = append(claim.Transitions, transition)
+	}
+	if err := rows.Err(); err != nil {
+		return tax.RefundClaim{}, err
+	}
+	return claim, nil
+}
+
+func (p *Postgres) TransitionRefundClaim(id int64, transition tax.RefundTransition) (tax.RefundClaim, error) {
+	tx, err := p.DB.Begin()
+	if err != nil {
+		return tax.RefundClaim{}, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE refund_claims SET status = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2 AND status = $3",
+		transition.To, id, transition.From)
+	if err != nil {
+		return tax.RefundClaim{}, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return tax.RefundClaim{}, err
+	}
+	if affected == 0 {
+		return tax.RefundClaim{}, tax.ErrConflict
+	}
+
+	if _, err := insertRefundTransition(tx, id, transition); err != nil {
+		return tax.RefundClaim{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return tax.RefundClaim{}, err
+	}
+	return p.GetRefundClaim(id)
+}
+
+func insertRefundTransition(tx *sql.Tx, claimID int64, transition tax.RefundTransition) (tax.RefundTransition, error) {
+	row := tx.QueryRow(`INSERT INTO refund_claim_transitions (claim_id, from_status, to_status, actor, note)
+		VALUES ($1, $2, $3, $4, $5) RETURNING created_at`,
+		claimID, transition.From, transition.To, transition.Actor, transition.Note)
+	err := row.Scan(&transition.CreatedAt)
+	return transition, err
+}
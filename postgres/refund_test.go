@@ -0,0 +1,83 @@
+// go:build unit
+
+package postgres
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/hanqqv/assessment-tax/tax"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateRefundClaim(t *testing.T) {
+	t.Run("CreateRefundClaim Already Exists", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err, "an error was not expected when opening a stub database connection")
+		defer db.Close()
+
+		p := &Postgres{DB: db}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO refund_claims").
+			WillReturnError(&pq.Error{Code: uniqueViolation})
+		mock.ExpectRollback()
+
+		_, err = p.CreateRefundClaim(tax.RefundClaim{CalculationID: 7, Status: tax.RefundStatusSubmitted})
+		assert.ErrorIs(t, err, tax.ErrAlreadyExists)
+	})
+}
+
+func TestTransitionRefundClaim(t *testing.T) {
+	t.Run("TransitionRefundClaim Success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err, "an error was not expected when opening a stub database connection")
+		defer db.Close()
+
+		p := &Postgres{DB: db}
+		at := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE refund_claims SET status = \\$1").
+			WithArgs(tax.RefundStatusUnderReview, int64(1), tax.RefundStatusSubmitted).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("INSERT INTO refund_claim_transitions").
+			WithArgs(int64(1), tax.RefundStatusSubmitted, tax.RefundStatusUnderReview, "adminTax", "").
+			WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(at))
+		mock.ExpectCommit()
+		mock.ExpectQuery("SELECT id, calculation_id, taxpayer_id, amount, bank_code, account_number, account_name, status, created_at").
+			WithArgs(int64(1)).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "calculation_id", "taxpayer_id", "amount", "bank_code", "account_number", "account_name", "status", "created_at"}).
+				AddRow(1, 7, "1101700230708", 2500.0, "004", "1234567890", "Somchai", tax.RefundStatusUnderReview, at))
+		mock.ExpectQuery("SELECT from_status, to_status, actor, note, created_at").
+			WithArgs(int64(1)).
+			WillReturnRows(sqlmock.NewRows([]string{"from_status", "to_status", "actor", "note", "created_at"}).
+				AddRow("", tax.RefundStatusSubmitted, "1101700230708", "", at).
+				AddRow(tax.RefundStatusSubmitted, tax.RefundStatusUnderReview, "adminTax", "", at))
+
+		got, err := p.TransitionRefundClaim(1, tax.RefundTransition{From: tax.RefundStatusSubmitted, To: tax.RefundStatusUnderReview, Actor: "adminTax"})
+
+		assert.NoError(t, err, "TransitionRefundClaim returned an error: %v", err)
+		assert.Equal(t, tax.RefundStatusUnderReview, got.Status)
+		assert.Len(t, got.Transitions, 2)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+	t.Run("TransitionRefundClaim Conflict", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err, "an error was not expected when opening a stub database connection")
+		defer db.Close()
+
+		p := &Postgres{DB: db}
+
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE refund_claims SET status = \\$1").
+			WithArgs(tax.RefundStatusApproved, int64(1), tax.RefundStatusUnderReview).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		_, err = p.TransitionRefundClaim(1, tax.RefundTransition{From: tax.RefundStatusUnderReview, To: tax.RefundStatusApproved, Actor: "adminTax"})
+		assert.ErrorIs(t, err, tax.ErrConflict)
+	})
+}
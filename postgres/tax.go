@@ -18,6 +18,24 @@ func (p *Postgres) CalculateTax(userInfo tax.UserInfo) (tax.Tax, error) {
 	return calculate(userInfo, personalDeduction, maxKReceipt)
 }
 
+func (p *Postgres) CalculateTaxWithSettings(userInfo tax.UserInfo, settings tax.Settings) (tax.Tax, error) {
+	return calculate(userInfo, settings.PersonalDeduction, settings.KReceipt)
+}
+
+func (p *Postgres) Settings() (tax.Settings, error) {
+	personalDeduction, err := p.getPersonalDeduction()
+	if err != nil {
+		return tax.Settings{}, err
+	}
+
+	maxKReceipt, err := p.getMaxKReceipt()
+	if err != nil {
+		return tax.Settings{}, err
+	}
+
+	return tax.Settings{PersonalDeduction: personalDeduction, KReceipt: maxKReceipt}, nil
+}
+
 func (p *Postgres) SettingPersonalDeduction(setting tax.Setting) (float64, error) {
 	row := p.DB.QueryRow("UPDATE deductions_setting SET amount = $1 WHERE allowance_type = $2 RETURNING amount", setting.Amount, "personal")
 	var personalDeduction float64
@@ -83,6 +83,57 @@ func TestCalculateTax(t *testing.T) {
 		assert.Equal(t, wantTax, gotTax, "CalculateTax returned incorrect tax: got %v want %v", gotTax, wantTax)
 	})
 }
+func TestSettings(t *testing.T) {
+	t.Run("Settings Success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err, "an error was not expected when opening a stub database connection")
+		defer db.Close()
+
+		p := &Postgres{DB: db}
+
+		mock.ExpectQuery("SELECT amount FROM deductions_setting WHERE allowance_type = \\$1").
+			WithArgs("personal").
+			WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(60000.0))
+		mock.ExpectQuery("SELECT amount FROM deductions_setting WHERE allowance_type = \\$1").
+			WithArgs("k-receipt").
+			WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(50000.0))
+
+		settings, err := p.Settings()
+		assert.NoError(t, err)
+		assert.Equal(t, tax.Settings{PersonalDeduction: 60000.0, KReceipt: 50000.0}, settings)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+	t.Run("Settings Error", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err, "an error was not expected when opening a stub database connection")
+		defer db.Close()
+
+		p := &Postgres{DB: db}
+
+		mock.ExpectQuery("SELECT amount FROM deductions_setting WHERE allowance_type = \\$1").
+			WithArgs("personal").
+			WillReturnError(errors.New("mock error"))
+
+		_, err = p.Settings()
+		assert.Error(t, err)
+	})
+}
+
+func TestCalculateTaxWithSettings(t *testing.T) {
+	t.Run("given settings should calculate without querying the database", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err, "an error was not expected when opening a stub database connection")
+		defer db.Close()
+
+		p := &Postgres{DB: db}
+
+		got, err := p.CalculateTaxWithSettings(tax.UserInfo{TotalIncome: 600000.0}, tax.Settings{PersonalDeduction: 60000.0, KReceipt: 50000.0})
+		assert.NoError(t, err)
+		assert.Equal(t, 41000.0, got.Tax)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
 func TestGetPersonalDeduction(t *testing.T) {
 	t.Run("GetPersonalDeduction Success", func(t *testing.T) {
 		db, mock, err := sqlmock.New()
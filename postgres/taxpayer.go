@@ -0,0 +1,49 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/hanqqv/assessment-tax/tax"
+)
+
+func (p *Postgres) CreateTaxpayer(taxpayer tax.Taxpayer) (tax.Taxpayer, error) {
+	row := p.DB.QueryRow(`INSERT INTO taxpayers (id, name, address, filing_status) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (id) DO NOTHING RETURNING created_at`,
+		taxpayer.ID, taxpayer.Name, taxpayer.Address, taxpayer.FilingStatus)
+	err := row.Scan(&taxpayer.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return tax.Taxpayer{}, tax.ErrAlreadyExists
+	}
+	if err != nil {
+		return tax.Taxpayer{}, err
+	}
+	return taxpayer, nil
+}
+
+func (p *Postgres) GetTaxpayer(id string) (tax.Taxpayer, error) {
+	row := p.DB.QueryRow("SELECT id, name, address, filing_status, created_at FROM taxpayers WHERE id = $1", id)
+	var taxpayer tax.Taxpayer
+	err := row.Scan(&taxpayer.ID, &taxpayer.Name, &taxpayer.Address, &taxpayer.FilingStatus, &taxpayer.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return tax.Taxpayer{}, tax.ErrNotFound
+	}
+	if err != nil {
+		return tax.Taxpayer{}, err
+	}
+	return taxpayer, nil
+}
+
+func (p *Postgres) UpdateTaxpayer(taxpayer tax.Taxpayer) (tax.Taxpayer, error) {
+	row := p.DB.QueryRow(`UPDATE taxpayers SET name = $2, address = $3, filing_status = $4, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 RETURNING created_at`,
+		taxpayer.ID, taxpayer.Name, taxpayer.Address, taxpayer.FilingStatus)
+	err := row.Scan(&taxpayer.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return tax.Taxpayer{}, tax.ErrNotFound
+	}
+	if err != nil {
+		return tax.Taxpayer{}, err
+	}
+	return taxpayer, nil
+}
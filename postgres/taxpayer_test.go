@@ -0,0 +1,63 @@
+// go:build unit
+
+package postgres
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/hanqqv/assessment-tax/tax"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateTaxpayer(t *testing.T) {
+	t.Run("CreateTaxpayer Success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err, "an error was not expected when opening a stub database connection")
+		defer db.Close()
+
+		p := &Postgres{DB: db}
+		createdAt := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+
+		mock.ExpectQuery("INSERT INTO taxpayers \\(id, name, address, filing_status\\)").
+			WithArgs("1101700230708", "Somchai", "Bangkok", "single").
+			WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(createdAt))
+
+		got, err := p.CreateTaxpayer(tax.Taxpayer{ID: "1101700230708", Name: "Somchai", Address: "Bangkok", FilingStatus: "single"})
+
+		assert.NoError(t, err, "CreateTaxpayer returned an error: %v", err)
+		assert.Equal(t, createdAt, got.CreatedAt)
+	})
+	t.Run("CreateTaxpayer Already Exists", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err, "an error was not expected when opening a stub database connection")
+		defer db.Close()
+
+		p := &Postgres{DB: db}
+
+		mock.ExpectQuery("INSERT INTO taxpayers \\(id, name, address, filing_status\\)").
+			WithArgs("1101700230708", "Somchai", "Bangkok", "single").
+			WillReturnRows(sqlmock.NewRows([]string{"created_at"}))
+
+		_, err = p.CreateTaxpayer(tax.Taxpayer{ID: "1101700230708", Name: "Somchai", Address: "Bangkok", FilingStatus: "single"})
+		assert.ErrorIs(t, err, tax.ErrAlreadyExists)
+	})
+}
+
+func TestGetTaxpayer(t *testing.T) {
+	t.Run("GetTaxpayer Not Found", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err, "an error was not expected when opening a stub database connection")
+		defer db.Close()
+
+		p := &Postgres{DB: db}
+
+		mock.ExpectQuery("SELECT id, name, address, filing_status, created_at FROM taxpayers WHERE id = \\$1").
+			WithArgs("1101700230708").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		_, err = p.GetTaxpayer("1101700230708")
+		assert.ErrorIs(t, err, tax.ErrNotFound)
+	})
+}
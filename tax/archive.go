@@ -0,0 +1,301 @@
+package tax
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"path"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// maxArchiveFiles caps how many files one request may calculate, counting
+// every part and every archive entry.
+const maxArchiveFiles = 100
+
+const (
+	defaultEntryLimit   = 32 << 20
+	defaultArchiveLimit = 256 << 20
+)
+
+// errFileTooLarge is returned while reading an archive entry that
+// decompresses past its limit or past what is left of the request's.
+var errFileTooLarge = errors.New("file is too large")
+
+// archiveLimits bound how much the archives of one request may decompress to:
+// entry for each file and total for all of them together.
+type archiveLimits struct {
+	entry int64
+	total int64
+}
+
+// archiveBudget is what is left of archiveLimits.total. The files of a
+// request are read one after another, so it needs no lock.
+type archiveBudget struct {
+	remaining int64
+}
+
+// namedFile is a file to calculate. file is nil for an archive entry that can
+// not be calculated and err says why.
+type namedFile struct {
+	name string
+	file FileOpener
+	err  Err
+}
+
+// zipEntry is an archive file to calculate. read is how far into it any open
+// has got: a strict upload reads its file twice, and only bytes no earlier
+// read reached are taken from the budget.
+type zipEntry struct {
+	*zip.File
+	limit  int64
+	budget *archiveBudget
+	read   *int64
+}
+
+// Open reads the entry through an io.LimitReader one byte past its limit, so
+// an entry whose header understates its size is caught rather than cut short.
+func (z zipEntry) Open() (io.ReadCloser, error) {
+	rc, err := z.File.Open()
+	if err != nil {
+		return nil, err
+	}
+	return &limitedEntry{src: rc, r: io.LimitReader(rc, z.limit+1), left: z.limit, budget: z.budget, read: z.read}, nil
+}
+
+type limitedEntry struct {
+	src    io.ReadCloser
+	r      io.Reader
+	left   int64
+	budget *archiveBudget
+	read   *int64
+	offset int64
+}
+
+func (l *limitedEntry) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.left -= int64(n)
+	l.offset += int64(n)
+	if l.offset > *l.read {
+		l.budget.remaining -= l.offset - *l.read
+		*l.read = l.offset
+	}
+	if l.left < 0 || l.budget.remaining < 0 {
+		return n, errFileTooLarge
+	}
+	return n, err
+}
+
+func (l *limitedEntry) Close() error {
+	return l.src.Close()
+}
+
+func (h *Handler) archiveLimits() archiveLimits {
+	limits := archiveLimits{entry: h.entryLimit, total: h.archiveLimit}
+	if limits.entry < 1 {
+		limits.entry = defaultEntryLimit
+	}
+	if limits.total < 1 {
+		limits.total = defaultArchiveLimit
+	}
+	return limits
+}
+
+// taxFiles expands the uploaded parts into the files to calculate. A ZIP
+// archive stands for its CSV and XLSX entries, while an XLSX workbook, which
+// is also a ZIP, stays a single file. archived reports whether any archive
+// was expanded. An entry declared larger than limits.entry is listed with a
+// message, and archives declared larger than limits.total in all are refused.
+func taxFiles(parts []*multipart.FileHeader, limits archiveLimits) ([]namedFile, bool, Err) {
+	var files []namedFile
+	var archived bool
+	var declared uint64
+	budget := &archiveBudget{remaining: limits.total}
+	for _, part := range parts {
+		entries, ok, errFile := archiveEntries(part)
+		if errFile.Message != "" {
+			return nil, false, errFile
+		}
+		if !ok {
+			files = append(files, namedFile{name: part.Filename, file: &MultipartFileHeader{part}})
+			continue
+		}
+		archived = true
+		for _, entry := range entries {
+			f := namedFile{name: path.Join(part.Filename, entry.Name)}
+			switch ext := strings.ToLower(path.Ext(entry.Name)); {
+			case ext != ".csv" && ext != ".xlsx":
+				f.err = Err{Message: "file must be csv or xlsx"}
+			case entry.UncompressedSize64 > uint64(limits.entry):
+				f.err = Err{Message: "file is too large"}
+			default:
+				declared += entry.UncompressedSize64
+				f.file = zipEntry{File: entry, limit: limits.entry, budget: budget, read: new(int64)}
+			}
+			files = append(files, f)
+		}
+	}
+	if len(files) > maxArchiveFiles {
+		return nil, false, Err{Message: "too many files: at most 100 per request"}
+	}
+	if declared > uint64(limits.total) {
+		return nil, false, Err{Message: "archive is too large"}
+	}
+	return files, archived, Err{}
+}
+
+func archiveEntries(part *multipart.FileHeader) ([]*zip.File, bool, Err) {
+	src, err := part.Open()
+	if err != nil {
+		return nil, false, Err{Message: "failed to open file"}
+	}
+	defer src.Close()
+
+	magic := make([]byte, len(xlsxMagic))
+	if _, err := io.ReadFull(src, magic); err != nil || !bytes.Equal(magic, xlsxMagic) {
+		return nil, false, Err{}
+	}
+
+	// A damaged file is left to the pipeline, which reports it as an
+	// invalid xlsx the way a single upload always has.
+	zr, err := zip.NewReader(src, part.Size)
+	if err != nil {
+		return nil, false, Err{}
+	}
+	var entries []*zip.File
+	for _, f := range zr.File {
+		if f.Name == "xl/workbook.xml" {
+			return nil, false, Err{}
+		}
+		name := path.Base(f.Name)
+		if f.FileInfo().IsDir() || strings.HasPrefix(f.Name, "__MACOSX/") || strings.HasPrefix(name, ".") {
+			continue
+		}
+		entries = append(entries, f)
+	}
+	return entries, true, Err{}
+}
+
+// fileSink writes one file of a MultiFileResponse, streaming its taxes like
+// jsonSink. rows gathers the file's rows for the combined summary, which
+// they only join once the whole file has gone through.
+type fileSink struct {
+	*stream
+	count  int
+	errors []RowError
+	totals *BatchSummary
+	rows   *summaryBuilder
+}
+
+func (s *fileSink) columns(columns []string) error {
+	return nil
+}
+
+func (s *fileSink) result(row rowOutput) error {
+	b, err := s.marshal(row.result)
+	if err != nil {
+		return err
+	}
+	if s.count > 0 {
+		b = append([]byte(","), b...)
+	}
+	s.count++
+	s.rows.add(rowOutput{result: row.result, levels: row.levels})
+	return s.write(b)
+}
+
+func (s *fileSink) rowError(row rowOutput) error {
+	s.errors = append(s.errors, row.err)
+	s.rows.add(rowOutput{err: row.err})
+	return nil
+}
+
+func (s *fileSink) summary(summary BatchSummary) error {
+	s.totals = &summary
+	return nil
+}
+
+func (s *fileSink) end(errFile Err) error {
+	trailer := "]"
+	if len(s.errors) > 0 {
+		b, err := s.marshal(s.errors)
+		if err != nil {
+			return err
+		}
+		trailer += `,"errors":` + string(b)
+	}
+	if s.totals != nil {
+		b, err := s.marshal(s.totals)
+		if err != nil {
+			return err
+		}
+		trailer += `,"summary":` + string(b)
+	}
+	if errFile.Message != "" {
+		b, err := json.Marshal(errFile.messageIn(s.lang))
+		if err != nil {
+			return err
+		}
+		trailer += `,"message":` + string(b)
+	}
+	return s.write([]byte(trailer + "}"))
+}
+
+// calculateTaxFiles streams a MultiFileResponse, one file after another, so
+// only the row errors of the file in progress are held in memory.
+func (h *Handler) calculateTaxFiles(c echo.Context, files []namedFile, options fileOptions) error {
+	out := &stream{res: c.Response(), contentType: echo.MIMEApplicationJSON, lang: languageParam(c)}
+	combined := newSummaryBuilder()
+	for i, f := range files {
+		name, err := json.Marshal(f.name)
+		if err != nil {
+			return err
+		}
+		prefix := ","
+		if i == 0 {
+			prefix = `{"files":[`
+		}
+		if err := out.write([]byte(prefix + `{"file":` + string(name) + `,"taxes":[`)); err != nil {
+			return err
+		}
+
+		sink := &fileSink{stream: out, rows: newSummaryBuilder()}
+		if f.file == nil {
+			if err := sink.end(f.err); err != nil {
+				return err
+			}
+			continue
+		}
+
+		rowErrors, errFile := h.processTaxFile(c.Request().Context(), f.file, options, sink)
+		if errFile.Message == "" {
+			combined.merge(sink.rows)
+		} else {
+			if c.Request().Context().Err() != nil {
+				return nil
+			}
+			sink.errors = append(sink.errors, rowErrors...)
+		}
+		if err := sink.end(errFile); err != nil {
+			return err
+		}
+	}
+
+	summary, err := out.marshal(combined.build())
+	if err != nil {
+		return err
+	}
+	trailer := `],"summary":` + string(summary) + "}\n"
+	if len(files) == 0 {
+		trailer = `{"files":[` + trailer
+	}
+	if err := out.write([]byte(trailer)); err != nil {
+		return err
+	}
+	out.res.Flush()
+	return nil
+}
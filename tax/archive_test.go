@@ -0,0 +1,250 @@
+// go:build unit
+
+package tax
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+type testFile struct {
+	name    string
+	content []byte
+}
+
+func multiUploadRequest(target string, files ...testFile) *http.Request {
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	for _, f := range files {
+		part, _ := writer.CreateFormFile("taxFile", f.name)
+		part.Write(f.content)
+	}
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, target, body)
+	req.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+	return req
+}
+
+func buildZip(t *testing.T, files ...testFile) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		assert.NoError(t, err)
+		w.Write(f.content)
+	}
+	assert.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestCalculateTaxCSVHandlerMultipleFiles(t *testing.T) {
+	t.Run("given several csv parts should return results grouped by file with a combined summary", func(t *testing.T) {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		req := multiUploadRequest("/tax/calculations/upload-csv?mode=lenient",
+			testFile{name: "bangkok.csv", content: []byte("totalIncome,wht\n1000,0\ninvalid,0")},
+			testFile{name: "chiangmai.csv", content: []byte("totalIncome,wht\n2000,0")},
+		)
+		c := e.NewContext(req, rec)
+		p := New(&StubTax{})
+
+		err := serve(c, p.CalculateTaxCSVHandler)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var response MultiFileResponse
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		assert.Len(t, response.Files, 2)
+		assert.Equal(t, "bangkok.csv", response.Files[0].File)
+		assert.Equal(t, []TaxResponseCSV{{TotalIncome: 1000}}, response.Files[0].Taxes)
+		assert.Equal(t, []RowError{{Line: 3, Column: "totalIncome", Code: CodeInvalidType, Message: "totalIncome must be a numeric value"}}, response.Files[0].Errors)
+		assert.Equal(t, 2, response.Files[0].Summary.Rows)
+		assert.Equal(t, "chiangmai.csv", response.Files[1].File)
+		assert.Equal(t, []TaxResponseCSV{{TotalIncome: 2000}}, response.Files[1].Taxes)
+		assert.Equal(t, 3, response.Summary.Rows)
+		assert.Equal(t, 2, response.Summary.Succeeded)
+		assert.Equal(t, float64(3000), response.Summary.TotalIncome)
+	})
+	t.Run("given a zip archive should calculate each csv and xlsx entry", func(t *testing.T) {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		archive := buildZip(t,
+			testFile{name: "branches/", content: nil},
+			testFile{name: "branches/north.csv", content: []byte("totalIncome,wht\n1000,0")},
+			testFile{name: "branches/south.xlsx", content: payrollWorkbook(t)},
+			testFile{name: "readme.txt", content: []byte("payroll")},
+			testFile{name: "__MACOSX/branches/._north.csv", content: []byte("x")},
+		)
+		req := multiUploadRequest("/tax/calculations/upload-csv?mode=lenient&sheet=Payroll", testFile{name: "payroll.zip", content: archive})
+		c := e.NewContext(req, rec)
+		p := New(&StubTax{})
+
+		err := serve(c, p.CalculateTaxCSVHandler)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var response MultiFileResponse
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		assert.Len(t, response.Files, 3)
+		assert.Equal(t, "payroll.zip/branches/north.csv", response.Files[0].File)
+		assert.Equal(t, []TaxResponseCSV{{TotalIncome: 1000}}, response.Files[0].Taxes)
+		assert.Equal(t, "payroll.zip/branches/south.xlsx", response.Files[1].File)
+		assert.Len(t, response.Files[1].Taxes, 2)
+		assert.Equal(t, "Payroll!B14", response.Files[1].Errors[0].Cell)
+		assert.Equal(t, FileResult{File: "payroll.zip/readme.txt", Taxes: []TaxResponseCSV{}, Message: "file must be csv or xlsx"}, response.Files[2])
+		assert.Equal(t, 4, response.Summary.Rows)
+	})
+	t.Run("given a strict file with a bad row should report it without failing the others", func(t *testing.T) {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		req := multiUploadRequest("/tax/calculations/upload-csv",
+			testFile{name: "a.csv", content: []byte("totalIncome,wht\ninvalid,0")},
+			testFile{name: "b.csv", content: []byte("totalIncome,wht\n2000,0")},
+		)
+		c := e.NewContext(req, rec)
+		p := New(&StubTax{})
+
+		err := serve(c, p.CalculateTaxCSVHandler)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var response MultiFileResponse
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		assert.Equal(t, "totalIncome must be a numeric value", response.Files[0].Message)
+		assert.Nil(t, response.Files[0].Summary)
+		assert.Equal(t, "", response.Files[1].Message)
+		assert.Equal(t, 1, response.Summary.Rows)
+	})
+	t.Run("given a single xlsx workbook should not treat it as an archive", func(t *testing.T) {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		req := multiUploadRequest("/tax/calculations/upload-csv?mode=lenient&sheet=Payroll", testFile{name: "payroll.xlsx", content: payrollWorkbook(t)})
+		c := e.NewContext(req, rec)
+		p := New(&StubTax{})
+
+		err := serve(c, p.CalculateTaxCSVHandler)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `{"taxes":[`)
+	})
+	t.Run("given several files with csv format should return status 400", func(t *testing.T) {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		req := multiUploadRequest("/tax/calculations/upload-csv?format=csv",
+			testFile{name: "a.csv", content: []byte("totalIncome,wht\n1000,0")},
+			testFile{name: "b.csv", content: []byte("totalIncome,wht\n2000,0")},
+		)
+		c := e.NewContext(req, rec)
+		p := New(&StubTax{})
+
+		err := serve(c, p.CalculateTaxCSVHandler)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, `{"type":"about:blank","title":"Bad Request","status":400,"detail":"multiple files can only be returned as json","instance":"/tax/calculations/upload-csv","message":"multiple files can only be returned as json"}`+"\n", rec.Body.String())
+	})
+	t.Run("given a corrupt zip file should return status 400 as an invalid xlsx", func(t *testing.T) {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		req := multiUploadRequest("/tax/calculations/upload-csv", testFile{name: "payroll.zip", content: []byte("PK\x03\x04broken")})
+		c := e.NewContext(req, rec)
+		p := New(&StubTax{})
+
+		err := serve(c, p.CalculateTaxCSVHandler)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, `{"type":"about:blank","title":"Bad Request","status":400,"detail":"error reading file: invalid xlsx","instance":"/tax/calculations/upload-csv","message":"error reading file: invalid xlsx"}`+"\n", rec.Body.String())
+	})
+	t.Run("given an entry larger than the entry limit should report it without failing the others", func(t *testing.T) {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		archive := buildZip(t,
+			testFile{name: "small.csv", content: []byte("totalIncome,wht\n1000,0")},
+			testFile{name: "large.csv", content: []byte("totalIncome,wht\n1000,0\n2000,0\n3000,0")},
+		)
+		req := multiUploadRequest("/tax/calculations/upload-csv?mode=lenient", testFile{name: "payroll.zip", content: archive})
+		c := e.NewContext(req, rec)
+		p := New(&StubTax{}).WithArchiveLimits(30, 1000)
+
+		err := serve(c, p.CalculateTaxCSVHandler)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var response MultiFileResponse
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		assert.Equal(t, []TaxResponseCSV{{TotalIncome: 1000}}, response.Files[0].Taxes)
+		assert.Equal(t, FileResult{File: "payroll.zip/large.csv", Taxes: []TaxResponseCSV{}, Message: "file is too large"}, response.Files[1])
+		assert.Equal(t, 1, response.Summary.Rows)
+	})
+	t.Run("given entries larger than the archive limit together should return status 400", func(t *testing.T) {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		archive := buildZip(t,
+			testFile{name: "a.csv", content: []byte("totalIncome,wht\n1000,0")},
+			testFile{name: "b.csv", content: []byte("totalIncome,wht\n2000,0")},
+		)
+		req := multiUploadRequest("/tax/calculations/upload-csv", testFile{name: "payroll.zip", content: archive})
+		c := e.NewContext(req, rec)
+		p := New(&StubTax{}).WithArchiveLimits(30, 40)
+
+		err := serve(c, p.CalculateTaxCSVHandler)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"message":"archive is too large"`)
+	})
+}
+
+func TestZipEntry(t *testing.T) {
+	archive := buildZip(t, testFile{name: "payroll.csv", content: []byte("totalIncome,wht\n1000,0\n2000,0\n3000,0")})
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		limit  int64
+		budget int64
+		err    error
+	}{
+		{"given an entry within its limit should read all of it", 100, 100, nil},
+		{"given an entry past its limit should fail", 20, 100, errFileTooLarge},
+		{"given an entry past what is left of the budget should fail", 100, 20, errFileTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := zipEntry{File: zr.File[0], limit: tt.limit, budget: &archiveBudget{remaining: tt.budget}, read: new(int64)}.Open()
+			assert.NoError(t, err)
+			defer src.Close()
+
+			_, err = io.ReadAll(src)
+
+			assert.Equal(t, tt.err, err)
+		})
+	}
+	t.Run("given an entry read twice should take it from the budget once", func(t *testing.T) {
+		entry := zipEntry{File: zr.File[0], limit: 100, budget: &archiveBudget{remaining: 40}, read: new(int64)}
+		for i := 0; i < 2; i++ {
+			src, err := entry.Open()
+			assert.NoError(t, err)
+
+			_, err = io.ReadAll(src)
+			src.Close()
+
+			assert.NoError(t, err)
+		}
+	})
+}
@@ -0,0 +1,67 @@
+package tax
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// defaultBatchLimit is how many people one batch request may carry unless
+// WithBatchLimit says otherwise.
+const defaultBatchLimit = 1000
+
+// CalculateTaxBatchHandler calculates an array of UserInfo. Each item is
+// decoded and goes through the same checks as a single calculation against
+// one settings snapshot, and fails on its own without stopping the others.
+func (h *Handler) CalculateTaxBatchHandler(c echo.Context) error {
+	decode, errDecode := decodeParam(c)
+	if errDecode.Message != "" {
+		return problem(http.StatusBadRequest, errDecode)
+	}
+	var items []json.RawMessage
+	if status, err := bindJSON(c, &items); err.Message != "" {
+		return problem(status, err)
+	}
+
+	limit := h.batchLimit
+	if limit < 1 {
+		limit = defaultBatchLimit
+	}
+	if len(items) > limit {
+		return problem(http.StatusRequestEntityTooLarge, errorf("batch must not have more than %d items", limit))
+	}
+
+	taxYear, errYear := taxYearParam(c)
+	if errYear.Message != "" {
+		return problem(http.StatusBadRequest, errYear)
+	}
+
+	settings, err := h.store.Settings()
+	if err != nil {
+		return problem(http.StatusInternalServerError, Err{Message: "failed to get settings", cause: err})
+	}
+
+	response := BatchCalculationResponse{Results: make([]BatchCalculationResult, len(items))}
+	for i, item := range items {
+		result := BatchCalculationResult{Index: i}
+		var userInfo UserInfo
+		if errItem := decodeJSON(decode, item, &userInfo); errItem.Message != "" {
+			result.Status, result.Error = http.StatusBadRequest, &errItem
+		} else if errCalc := h.validationPublicUserInfo(userInfo); errCalc.Message != "" {
+			result.Status, result.Error = http.StatusBadRequest, &errCalc
+		} else if calculation, status, errCalc := h.calculateUserInfo(userInfo, taxYear, &settings); errCalc.Message != "" {
+			result.Status, result.Error = status, &errCalc
+			if status >= http.StatusInternalServerError {
+				logCause(c, errCalc)
+			}
+		} else {
+			result.Status, result.Tax = http.StatusOK, &calculation.Tax
+			response.Succeeded++
+		}
+		response.Results[i] = result
+	}
+	response.Failed = len(items) - response.Succeeded
+
+	return c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,83 @@
+// go:build unit
+
+package tax
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func batchRequest(body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/tax/calculations/batch", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	return req
+}
+
+func TestCalculateTaxBatchHandler(t *testing.T) {
+	t.Run("given valid and invalid items should return a result or an error for each", func(t *testing.T) {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		c := e.NewContext(batchRequest(`[
+			{"totalIncome":500000,"wht":0,"allowances":[]},
+			{"totalIncome":500000,"wht":600000,"allowances":[]},
+			{"totalIncome":500000,"wht":0,"allowances":[{"allowanceType":"bonus","amount":1000}]}
+		]`), rec)
+		stubTax := &StubTax{calculateTax: Tax{Tax: 29000, TaxLevel: []TaxLevel{}}}
+		p := New(stubTax)
+
+		err := serve(c, p.CalculateTaxBatchHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusOK, rec.Code, "expected status code %d but got %d", http.StatusOK, rec.Code)
+		assert.Equal(t, `{"succeeded":1,"failed":2,"results":[`+
+			`{"index":0,"status":200,"tax":{"tax":29000,"taxLevel":[]}},`+
+			`{"index":1,"status":400,"error":{"message":"wht must be less than or equal to total income","errors":[{"code":"WHT_EXCEEDS_INCOME","path":"wht","value":600000,"message":"wht must be less than or equal to total income"}]}},`+
+			`{"index":2,"status":400,"error":{"message":"invalid allowance type","errors":[{"code":"ALLOWANCE_TYPE_INVALID","path":"allowances[0].allowanceType","value":"bonus","message":"invalid allowance type"}]}}]}`, strings.TrimSuffix(rec.Body.String(), "\n"))
+		assert.Equal(t, 1, stubTax.settingsCalls)
+	})
+	t.Run("given a taxpayerId should fail only that item and save nothing", func(t *testing.T) {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		c := e.NewContext(batchRequest(`[{"taxpayerId":"1101700230708","totalIncome":500000,"wht":0},{"totalIncome":500000,"wht":0}]`), rec)
+		history := StubHistory{}
+		p := New(&StubTax{}).WithHistory(&history).WithTaxpayers(&StubTaxpayers{})
+
+		err := serve(c, p.CalculateTaxBatchHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusOK, rec.Code, "expected status code %d but got %d", http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `{"index":0,"status":400,"error":{"message":"taxpayerId is only accepted by POST /taxpayers/:taxpayerId/calculations","errors":[{"code":"TAXPAYER_ID_NOT_ALLOWED","path":"taxpayerId","value":"1101700230708","message":"taxpayerId is only accepted by POST /taxpayers/:taxpayerId/calculations"}]}}`)
+		assert.Equal(t, Calculation{}, history.saved)
+		assert.Contains(t, rec.Body.String(), `{"index":1,"status":200,`)
+	})
+	t.Run("given more items than the limit should return status 413", func(t *testing.T) {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		c := e.NewContext(batchRequest(`[{"totalIncome":1,"wht":0},{"totalIncome":2,"wht":0},{"totalIncome":3,"wht":0}]`), rec)
+		stubTax := &StubTax{}
+		p := New(stubTax).WithBatchLimit(2)
+
+		err := serve(c, p.CalculateTaxBatchHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code, "expected status code %d but got %d", http.StatusRequestEntityTooLarge, rec.Code)
+		assert.Equal(t, `{"type":"about:blank","title":"Request Entity Too Large","status":413,"detail":"batch must not have more than 2 items","instance":"/tax/calculations/batch","message":"batch must not have more than 2 items"}`, strings.TrimSuffix(rec.Body.String(), "\n"))
+		assert.Equal(t, 0, stubTax.settingsCalls)
+	})
+	t.Run("given a body that is not an array should return status 400", func(t *testing.T) {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		c := e.NewContext(batchRequest(`{"totalIncome":500000,"wht":0}`), rec)
+		p := New(&StubTax{})
+
+		err := serve(c, p.CalculateTaxBatchHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
+	})
+}
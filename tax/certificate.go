@@ -0,0 +1,194 @@
+package tax
+
+import (
+	"encoding/csv"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+var certificateColumns = []string{"payerTaxId", "incomeType", "amountPaid", "taxWithheld", "date"}
+
+func (h *Handler) ImportCertificatesHandler(c echo.Context) error {
+	taxpayerID := c.Param("taxpayerId")
+	if !isValidTaxpayerID(taxpayerID) {
+		return problem(http.StatusBadRequest, Err{Message: "taxpayer id must be a valid 13-digit national id"})
+	}
+
+	taxYear, errYear := taxYearParam(c)
+	if errYear.Message != "" {
+		return problem(http.StatusBadRequest, errYear)
+	}
+
+	certificates, statusRead, errRead := readCertificates(c)
+	if errRead.Message != "" {
+		return problem(statusRead, errRead)
+	}
+	if len(certificates) == 0 {
+		return problem(http.StatusBadRequest, Err{Message: "no certificates to import"})
+	}
+
+	var v validationErrors
+	for i := range certificates {
+		certificates[i].ID = 0
+		certificates[i].TaxpayerID = taxpayerID
+		certificates[i].TaxYear = taxYear
+		h.collectCertificateErrors(&v, i, certificates[i])
+	}
+	if err := v.err(); err.Message != "" {
+		return problem(http.StatusBadRequest, err)
+	}
+
+	imported, duplicates, err := h.certificates.ImportCertificates(certificates)
+	if err != nil {
+		return problem(http.StatusInternalServerError, Err{Message: "failed to import certificates", cause: err})
+	}
+	if imported == nil {
+		imported = []WHTCertificate{}
+	}
+	if duplicates == nil {
+		duplicates = []WHTCertificate{}
+	}
+
+	status := http.StatusCreated
+	if len(imported) == 0 {
+		status = http.StatusOK
+	}
+	return c.JSON(status, CertificateImportResponse{Imported: imported, Duplicates: duplicates})
+}
+
+func (h *Handler) ListCertificatesHandler(c echo.Context) error {
+	taxpayerID := c.Param("taxpayerId")
+	if !isValidTaxpayerID(taxpayerID) {
+		return problem(http.StatusBadRequest, Err{Message: "taxpayer id must be a valid 13-digit national id"})
+	}
+
+	taxYear, errYear := taxYearParam(c)
+	if errYear.Message != "" {
+		return problem(http.StatusBadRequest, errYear)
+	}
+
+	certificates, err := h.certificates.ListCertificates(taxpayerID, taxYear)
+	if err != nil {
+		return problem(http.StatusInternalServerError, Err{Message: "failed to list certificates", cause: err})
+	}
+	if certificates == nil {
+		certificates = []WHTCertificate{}
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"certificates": certificates})
+}
+
+// applyCertificates replaces the WHT of a linked taxpayer with the tax
+// withheld on their imported certificates for the year, if there are any. It
+// reads personal data, so it only runs for POST
+// /taxpayers/:taxpayerId/calculations, behind credentials.
+// The certificates win: a client may leave wht at 0.0 or repeat their total,
+// anything else is reported as a validation failure rather than dropped.
+func (h *Handler) applyCertificates(userInfo *UserInfo, taxYear int) (Err, error) {
+	if userInfo.TaxpayerID == "" || h.certificates == nil {
+		return Err{}, nil
+	}
+
+	certificates, err := h.certificates.ListCertificates(userInfo.TaxpayerID, taxYear)
+	if err != nil {
+		return Err{}, err
+	}
+	if len(certificates) == 0 {
+		return Err{}, nil
+	}
+
+	var wht float64
+	for _, certificate := range certificates {
+		wht += certificate.TaxWithheld
+	}
+	wht = round2(wht)
+	if userInfo.WHT != 0.0 && round2(userInfo.WHT) != wht {
+		var v validationErrors
+		v.add(CodeWHTCertificateMismatch, "wht", userInfo.WHT, "wht must be 0.0 or equal to the tax withheld on imported certificates", "wht")
+		return v.err(), nil
+	}
+	userInfo.WHT = wht
+	return Err{}, nil
+}
+
+func readCertificates(c echo.Context) ([]WHTCertificate, int, Err) {
+	contentType := c.Request().Header.Get(echo.HeaderContentType)
+
+	switch {
+	case strings.HasPrefix(contentType, echo.MIMEMultipartForm):
+		file, err := c.FormFile("certificateFile")
+		if err != nil {
+			return nil, http.StatusBadRequest, Err{Message: "invalid file : key must be certificateFile"}
+		}
+		src, err := file.Open()
+		if err != nil {
+			return nil, http.StatusBadRequest, Err{Message: "failed to open file"}
+		}
+		defer src.Close()
+		certificates, errParse := parseCertificatesCSV(src)
+		return certificates, http.StatusBadRequest, errParse
+	case strings.HasPrefix(contentType, "text/csv"):
+		certificates, err := parseCertificatesCSV(c.Request().Body)
+		return certificates, http.StatusBadRequest, err
+	default:
+		var certificates []WHTCertificate
+		status, err := bindJSON(c, &certificates)
+		return certificates, status, err
+	}
+}
+
+func parseCertificatesCSV(src io.Reader) ([]WHTCertificate, Err) {
+	reader := csv.NewReader(src)
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, Err{Message: "error reading file"}
+	}
+
+	index := map[string]int{}
+	for i, name := range header {
+		index[strings.TrimSpace(name)] = i
+	}
+	for _, column := range certificateColumns {
+		if _, ok := index[column]; !ok {
+			return nil, errorf("missing column %s", column)
+		}
+	}
+
+	var certificates []WHTCertificate
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errorf("line %s: invalid format", line)
+		}
+
+		value := func(column string) string {
+			return strings.TrimSpace(record[index[column]])
+		}
+
+		amountPaid, err := parseAmount(value("amountPaid"))
+		if err != nil {
+			return nil, errorf("line %s: amountPaid must be a numeric value", line)
+		}
+		taxWithheld, err := parseAmount(value("taxWithheld"))
+		if err != nil {
+			return nil, errorf("line %s: taxWithheld must be a numeric value", line)
+		}
+
+		certificates = append(certificates, WHTCertificate{
+			PayerTaxID:  value("payerTaxId"),
+			IncomeType:  value("incomeType"),
+			AmountPaid:  amountPaid,
+			TaxWithheld: taxWithheld,
+			Date:        value("date"),
+		})
+	}
+
+	return certificates, Err{}
+}
@@ -0,0 +1,221 @@
+// go:build unit
+
+package tax
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+type StubCertificates struct {
+	certificates []WHTCertificate
+	imported     []WHTCertificate
+	err          error
+}
+
+func (s *StubCertificates) ImportCertificates(certificates []WHTCertificate) ([]WHTCertificate, []WHTCertificate, error) {
+	s.imported = certificates
+	var imported, duplicates []WHTCertificate
+	for _, certificate := range certificates {
+		duplicate := false
+		for _, existing := range s.certificates {
+			if existing.PayerTaxID == certificate.PayerTaxID && existing.Date == certificate.Date && existing.TaxWithheld == certificate.TaxWithheld {
+				duplicate = true
+			}
+		}
+		if duplicate {
+			duplicates = append(duplicates, certificate)
+			continue
+		}
+		imported = append(imported, certificate)
+	}
+	return imported, duplicates, s.err
+}
+
+func (s *StubCertificates) ListCertificates(taxpayerID string, taxYear int) ([]WHTCertificate, error) {
+	return s.certificates, s.err
+}
+
+func TestImportCertificatesHandler(t *testing.T) {
+	t.Run("given json certificates should import and report duplicates", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/taxpayers/1101700230708/certificates", io.NopCloser(strings.NewReader(`[
+			{"payerTaxId": "0105556012341", "incomeType": "40(1)", "amountPaid": 50000.0, "taxWithheld": 2500.0, "date": "2024-01-31"},
+			{"payerTaxId": "0105556012341", "incomeType": "40(1)", "amountPaid": 50000.0, "taxWithheld": 2500.0, "date": "2024-02-29"}
+		]`)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("taxpayerId")
+		c.SetParamValues("1101700230708")
+
+		stubCertificates := StubCertificates{certificates: []WHTCertificate{{PayerTaxID: "0105556012341", Date: "2024-01-31", TaxWithheld: 2500.0}}}
+		p := New(&StubTax{}).WithCertificates(&stubCertificates)
+
+		err := serve(c, p.ImportCertificatesHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusCreated, rec.Code, "expected status code %d but got %d", http.StatusCreated, rec.Code)
+
+		var got CertificateImportResponse
+		err = json.Unmarshal(rec.Body.Bytes(), &got)
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Len(t, got.Imported, 1)
+		assert.Len(t, got.Duplicates, 1)
+		assert.Equal(t, "2024-01-31", got.Duplicates[0].Date)
+		assert.Equal(t, "1101700230708", stubCertificates.imported[0].TaxpayerID)
+		assert.Equal(t, DefaultTaxYear, stubCertificates.imported[0].TaxYear)
+	})
+	t.Run("given csv certificates should import all rows", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/taxpayers/1101700230708/certificates", strings.NewReader("date,payerTaxId,incomeType,amountPaid,taxWithheld\n2024-01-31,0105556012341,40(1),\"50,000\",2500\n2024-06-30,0105556012341,40(2),10000,300\n"))
+		req.Header.Set(echo.HeaderContentType, "text/csv")
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("taxpayerId")
+		c.SetParamValues("1101700230708")
+
+		stubCertificates := StubCertificates{}
+		p := New(&StubTax{}).WithCertificates(&stubCertificates)
+
+		err := serve(c, p.ImportCertificatesHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusCreated, rec.Code, "expected status code %d but got %d", http.StatusCreated, rec.Code)
+		assert.Equal(t, []WHTCertificate{
+			{TaxpayerID: "1101700230708", TaxYear: 2567, PayerTaxID: "0105556012341", IncomeType: "40(1)", AmountPaid: 50000.0, TaxWithheld: 2500.0, Date: "2024-01-31"},
+			{TaxpayerID: "1101700230708", TaxYear: 2567, PayerTaxID: "0105556012341", IncomeType: "40(2)", AmountPaid: 10000.0, TaxWithheld: 300.0, Date: "2024-06-30"},
+		}, stubCertificates.imported)
+	})
+	t.Run("given certificate outside tax year should return status 400 and error message", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/taxpayers/1101700230708/certificates", io.NopCloser(strings.NewReader(`[{"payerTaxId": "0105556012341", "incomeType": "40(1)", "amountPaid": 50000.0, "taxWithheld": 2500.0, "date": "2023-12-31"}]`)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("taxpayerId")
+		c.SetParamValues("1101700230708")
+
+		p := New(&StubTax{}).WithCertificates(&StubCertificates{})
+
+		err := serve(c, p.ImportCertificatesHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
+		assert.JSONEq(t, `{"type": "about:blank", "title": "Bad Request", "status": 400, "detail": "certificate 1: date must be within tax year 2567", "instance": "/taxpayers/1101700230708/certificates", "message": "certificate 1: date must be within tax year 2567",
+			"errors": [{"code": "DATE_OUTSIDE_TAX_YEAR", "path": "[0].date", "value": "2023-12-31", "message": "certificate 1: date must be within tax year 2567"}]}`, rec.Body.String())
+	})
+	t.Run("given several invalid certificates should report every failure with its path", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/taxpayers/1101700230708/certificates", io.NopCloser(strings.NewReader(`[{"payerTaxId": "0105556012341", "incomeType": "40(9)", "amountPaid": 50000.0, "taxWithheld": 2500.0, "date": "2024-01-31"}, {"payerTaxId": "0105556012341", "incomeType": "40(1)", "amountPaid": 1000.0, "taxWithheld": 2500.0, "date": "31/01/2024"}]`)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("taxpayerId")
+		c.SetParamValues("1101700230708")
+
+		p := New(&StubTax{}).WithCertificates(&StubCertificates{})
+
+		err := serve(c, p.ImportCertificatesHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
+		var got struct {
+			Errors []FieldError `json:"errors"`
+		}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+		var codes []string
+		for _, fieldErr := range got.Errors {
+			codes = append(codes, fieldErr.Code+" "+fieldErr.Path)
+		}
+		assert.Equal(t, []string{"INCOME_TYPE_INVALID [0].incomeType", "TAX_WITHHELD_EXCEEDS_AMOUNT [1].taxWithheld", "DATE_INVALID [1].date"}, codes)
+	})
+}
+
+func TestCalculateTaxWithCertificates(t *testing.T) {
+	t.Run("given linked taxpayer with certificates should use their total tax withheld as wht", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/taxpayers/1101700230708/calculations", io.NopCloser(strings.NewReader(`{"totalIncome": 500000.0, "wht": 0.0}`)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("taxpayerId")
+		c.SetParamValues("1101700230708")
+
+		stubTax := StubTax{}
+		stubCertificates := StubCertificates{certificates: []WHTCertificate{{TaxWithheld: 2500.0}, {TaxWithheld: 300.5}}}
+		p := New(&stubTax).WithHistory(&StubHistory{}).WithCertificates(&stubCertificates)
+
+		err := serve(c, p.CreateCalculationHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusCreated, rec.Code, "expected status code %d but got %d", http.StatusCreated, rec.Code)
+		assert.Equal(t, 2800.5, stubTax.userInfo.WHT)
+	})
+	t.Run("given linked taxpayer without certificates should keep wht from request", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/taxpayers/1101700230708/calculations", io.NopCloser(strings.NewReader(`{"totalIncome": 500000.0, "wht": 1000.0}`)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("taxpayerId")
+		c.SetParamValues("1101700230708")
+
+		stubTax := StubTax{}
+		p := New(&stubTax).WithHistory(&StubHistory{}).WithCertificates(&StubCertificates{})
+
+		err := serve(c, p.CreateCalculationHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, 1000.0, stubTax.userInfo.WHT)
+	})
+	t.Run("given wht that differs from certificates should return status 400 and not calculate", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/taxpayers/1101700230708/calculations", io.NopCloser(strings.NewReader(`{"totalIncome": 500000.0, "wht": 1000.0}`)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("taxpayerId")
+		c.SetParamValues("1101700230708")
+
+		stubTax := StubTax{}
+		stubCertificates := StubCertificates{certificates: []WHTCertificate{{TaxWithheld: 2500.0}, {TaxWithheld: 300.5}}}
+		p := New(&stubTax).WithHistory(&StubHistory{}).WithCertificates(&stubCertificates)
+
+		err := serve(c, p.CreateCalculationHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
+		assert.JSONEq(t, `{"type": "about:blank", "title": "Bad Request", "status": 400, "detail": "wht must be 0.0 or equal to the tax withheld on imported certificates", "instance": "/taxpayers/1101700230708/calculations", "message": "wht must be 0.0 or equal to the tax withheld on imported certificates",
+			"errors": [{"code": "WHT_CERTIFICATE_MISMATCH", "path": "wht", "value": 1000.0, "message": "wht must be 0.0 or equal to the tax withheld on imported certificates"}]}`, rec.Body.String())
+		assert.Equal(t, UserInfo{}, stubTax.userInfo)
+	})
+	t.Run("given certificates withholding more than the income should validate the wht they set", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/taxpayers/1101700230708/calculations", io.NopCloser(strings.NewReader(`{"totalIncome": 2000.0, "wht": 0.0}`)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("taxpayerId")
+		c.SetParamValues("1101700230708")
+
+		stubTax := StubTax{}
+		stubCertificates := StubCertificates{certificates: []WHTCertificate{{TaxWithheld: 2500.0}}}
+		p := New(&stubTax).WithHistory(&StubHistory{}).WithCertificates(&stubCertificates)
+
+		err := serve(c, p.CreateCalculationHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
+		assert.JSONEq(t, `{"type": "about:blank", "title": "Bad Request", "status": 400, "detail": "wht must be less than or equal to total income", "instance": "/taxpayers/1101700230708/calculations", "message": "wht must be less than or equal to total income",
+			"errors": [{"code": "WHT_EXCEEDS_INCOME", "path": "wht", "value": 2500.0, "message": "wht must be less than or equal to total income"}]}`, rec.Body.String())
+		assert.Equal(t, UserInfo{}, stubTax.userInfo)
+	})
+}
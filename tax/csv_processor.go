@@ -1,12 +1,18 @@
 package tax
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/csv"
-	"fmt"
+	"errors"
 	"io"
+	"log"
 	"mime/multipart"
-	"strconv"
+	"os"
+	"runtime"
 	"strings"
+	"sync"
 )
 
 type MultipartFileHeader struct {
@@ -21,54 +27,443 @@ type FileOpener interface {
 	Open() (io.ReadCloser, error)
 }
 
-func (h *Handler) processTaxFile(file FileOpener) ([]TaxResponseCSV, Err) {
+type columnError struct {
+	column string
+	code   string
+	text   Text
+}
+
+func (e *columnError) Error() string {
+	return e.text.String()
+}
+
+type rowSink interface {
+	columns(columns []string) error
+	result(row rowOutput) error
+	rowError(row rowOutput) error
+	summary(summary BatchSummary) error
+}
+
+// rowOutput is one processed row. record is the row as read from the file and
+// levels the per-tier breakdown, both kept for tabular output.
+type rowOutput struct {
+	record []string
+	result TaxResponseCSV
+	levels []TaxLevel
+	err    RowError
+}
+
+// csvRow is a data row.
+type csvRow struct {
+	line   int
+	record []string
+	err    RowError
+}
+
+type csvRowReader struct {
+	src     io.ReadCloser
+	spool   *os.File
+	records recordReader
+	header  csvHeader
+	sheet   string
+}
+
+type recordReader interface {
+	read() (record []string, line int, err error)
+}
+
+type csvRecords struct {
+	reader *csv.Reader
+}
+
+func (r *csvRecords) read() ([]string, int, error) {
+	record, err := r.reader.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return record, parseErr.StartLine, err
+		}
+		return nil, 0, err
+	}
+	line, _ := r.reader.FieldPos(0)
+	return record, line, nil
+}
+
+// fileOptions are the caller's choices for reading an uploaded file.
+type fileOptions struct {
+	mode        string
+	sheet       string
+	encoding    string
+	delimiter   rune
+	passthrough []string
+}
+
+// openCSVRows opens an uploaded file and reads its header. XLSX workbooks are
+// recognised by content and read from the sheet named in options, anything
+// else is read as CSV.
+func openCSVRows(file FileOpener, options fileOptions) (*csvRowReader, Err) {
 	src, err := file.Open()
 	if err != nil {
 		return nil, Err{Message: "failed to open file"}
 	}
-	defer src.Close()
 
-	reader := csv.NewReader(src)
+	rows := &csvRowReader{src: src}
+	buffered := bufio.NewReaderSize(src, sniffSize)
+	if magic, _ := buffered.Peek(len(xlsxMagic)); bytes.Equal(magic, xlsxMagic) {
+		if errFile := rows.openXLSX(buffered, options.sheet); errFile.Message != "" {
+			rows.Close()
+			return nil, errFile
+		}
+	} else {
+		input, delimiter := csvInput(buffered, options)
+		reader := csv.NewReader(input)
+		reader.Comma = delimiter
+		reader.ReuseRecord = true
+		rows.records = &csvRecords{reader: reader}
+	}
 
-	_, err = reader.Read()
+	record, _, err := rows.records.read()
 	if err != nil {
-		return nil, Err{Message: "error reading file"}
+		rows.Close()
+		return nil, readError(err)
 	}
 
-	var taxResponseCSV []TaxResponseCSV
-	for {
-		line, err := reader.Read()
-		if err == io.EOF {
-			break
+	header, err := parseCSVHeader(trimTrailingEmpty(record), options.passthrough)
+	if err != nil {
+		rows.Close()
+		return nil, Err{Message: err.Error(), text: errText(err)}
+	}
+	rows.header = header
+
+	return rows, Err{}
+}
+
+func (r *csvRowReader) openXLSX(buffered *bufio.Reader, sheet string) Err {
+	var at io.ReaderAt
+	var size int64
+	if ra, ok := r.src.(interface {
+		io.ReaderAt
+		io.Seeker
+	}); ok {
+		end, err := ra.Seek(0, io.SeekEnd)
+		if err != nil {
+			return Err{Message: "error reading file"}
 		}
+		at, size = ra, end
+	} else {
+		// A workbook is read at random, so one that can not be is spooled to
+		// a temporary file rather than held in memory. An archive entry is
+		// bounded by its own limit while it is copied.
+		spool, err := os.CreateTemp("", "tax-*.xlsx")
 		if err != nil {
-			return nil, Err{Message: "error reading file: invalid format"}
+			return Err{Message: "error reading file", cause: err}
+		}
+		r.spool = spool
+		n, err := io.Copy(spool, buffered)
+		if err != nil {
+			return readError(err)
+		}
+		at, size = spool, n
+	}
+
+	records, name, err := openXLSXSheet(at, size, sheet)
+	if err != nil {
+		if errors.Is(err, errSheetNotFound) {
+			return errorf("sheet %q not found", sheet)
+		}
+		return Err{Message: "error reading file: invalid xlsx"}
+	}
+	r.records = records
+	r.sheet = name
+	return Err{}
+}
+
+// next returns the next data row, or io.EOF once the file is exhausted.
+// Malformed rows are returned with err set so the caller can carry on.
+func (r *csvRowReader) next() (csvRow, error) {
+	record, line, err := r.records.read()
+	if err == io.EOF {
+		return csvRow{}, io.EOF
+	}
+	if err != nil {
+		var parseErr *csv.ParseError
+		if !errors.As(err, &parseErr) {
+			return csvRow{}, err
 		}
+		row := csvRow{line: line, record: append([]string(nil), record...)}
+		row.err = r.locate(RowError{Message: "error reading file: invalid format"}, row)
+		return row, nil
+	}
 
-		taxResponse, error := h.processCSVLine(line)
-		if error.Message != "" {
-			return nil, Err{Message: error.Message}
+	if r.sheet != "" {
+		// Excel leaves out empty trailing cells.
+		record = trimTrailingEmpty(record)
+		for len(record) < len(r.header.columns) {
+			record = append(record, "")
 		}
+	}
+	return csvRow{line: line, record: record}, nil
+}
+
+// locate points a row error at its line and, for a worksheet, at the cell of
+// the failing column, e.g. Sheet1!B14. It also carries the row's label.
+func (r *csvRowReader) locate(rowErr RowError, row csvRow) RowError {
+	line := row.line
+	rowErr.Line = line
+	rowErr.RowLabel = r.header.label(row.record)
+	if r.sheet == "" || rowErr.Column == "" {
+		return rowErr
+	}
+	for i, column := range r.header.columns {
+		if column == rowErr.Column {
+			rowErr.Cell = r.sheet + "!" + cellName(i, line)
+		}
+	}
+	return rowErr
+}
+
+func (r *csvRowReader) Close() error {
+	if closer, ok := r.records.(io.Closer); ok {
+		closer.Close()
+	}
+	if r.spool != nil {
+		r.spool.Close()
+		os.Remove(r.spool.Name())
+	}
+	return r.src.Close()
+}
 
-		taxResponseCSV = append(taxResponseCSV, taxResponse)
+// readError is the client's message for a file that failed part way through.
+func readError(err error) Err {
+	if errors.Is(err, errFileTooLarge) {
+		return Err{Message: errFileTooLarge.Error()}
 	}
+	return Err{Message: "error reading file"}
+}
 
-	return taxResponseCSV, Err{}
+func trimTrailingEmpty(record []string) []string {
+	for len(record) > 0 && strings.TrimSpace(record[len(record)-1]) == "" {
+		record = record[:len(record)-1]
+	}
+	return record
 }
 
-func (h *Handler) processCSVLine(line []string) (TaxResponseCSV, Err) {
-	userInfo, err := parseUserInfoFromCSVLine(line)
+type rowTask struct {
+	row csvRow
+	out chan rowOutput
+}
+
+// processTaxFile streams every row of the file through the calculation and
+// hands results to sink in input order as they are produced. Settings are
+// read once for the whole file and rows are calculated by a pool of workers.
+// In strict mode nothing reaches sink unless every row passes: the whole file
+// is validated first and the row errors returned, then it is read again and
+// calculated, stopping at the first row that fails to calculate. Neither pass
+// keeps the rows, so a large file is processed in flat memory.
+func (h *Handler) processTaxFile(ctx context.Context, file FileOpener, options fileOptions, sink rowSink) ([]RowError, Err) {
+	rows, errFile := openCSVRows(file, options)
+	if errFile.Message != "" {
+		return nil, errFile
+	}
+
+	strict := options.mode == ModeStrict
+	if strict {
+		rowErrors, errFile := h.validateTaxRows(rows)
+		rows.Close()
+		if errFile.Message != "" {
+			return nil, errFile
+		}
+		if len(rowErrors) > 0 {
+			return rowErrors, Err{Message: rowErrors[0].Message, text: rowErrors[0].Text}
+		}
+		if rows, errFile = openCSVRows(file, options); errFile.Message != "" {
+			return nil, errFile
+		}
+	}
+	defer rows.Close()
+
+	settings, err := h.store.Settings()
 	if err != nil {
-		return TaxResponseCSV{}, Err{Message: err.Error()}
+		return nil, Err{Message: "failed to get settings", cause: err}
 	}
 
-	if err := h.validationUserInfo(userInfo); err.Message != "" {
-		return TaxResponseCSV{}, Err{Message: err.Message}
+	if err := sink.columns(rows.header.columns); err != nil {
+		return nil, Err{Message: "failed to write result", cause: err}
+	}
+
+	summary := newSummaryBuilder()
+	work := func(row csvRow) rowOutput {
+		result, levels, rowErr := h.processCSVLine(rows.header, row.record, settings)
+		if rowErr.Message != "" {
+			return rowOutput{record: row.record, err: rows.locate(rowErr, row)}
+		}
+		return rowOutput{record: row.record, result: result, levels: levels}
+	}
+	var failed RowError
+	emit := func(output rowOutput) Err {
+		write := sink.result
+		if output.err.Message != "" {
+			if strict {
+				failed = output.err
+				return Err{Message: failed.Message, text: failed.Text}
+			}
+			write = sink.rowError
+		}
+		if err := write(output); err != nil {
+			return Err{Message: "failed to write result", cause: err}
+		}
+		summary.add(output)
+		return Err{}
+	}
+	if err := h.calculateRows(ctx, rows.next, work, emit); err.Message != "" {
+		if failed.Message != "" {
+			return []RowError{failed}, err
+		}
+		return nil, err
+	}
+
+	if err := sink.summary(summary.build()); err != nil {
+		return nil, Err{Message: "failed to write result", cause: err}
+	}
+	return nil, Err{}
+}
+
+// calculateRows runs work over the rows returned by next on a pool of workers
+// and hands every outcome to emit in input order. Rows that failed to read
+// are passed to emit as they are. It stops at the first error emit returns.
+func (h *Handler) calculateRows(ctx context.Context, next func() (csvRow, error), work func(row csvRow) rowOutput, emit func(output rowOutput) Err) Err {
+	workers := h.workers
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	// pending carries each row's outcome channel in input order, which keeps
+	// the output ordered and bounds how far the reader runs ahead.
+	tasks := make(chan rowTask)
+	pending := make(chan chan rowOutput, workers*2)
+	readErr := make(chan error, 1)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(tasks)
+		defer close(pending)
+		for {
+			row, err := next()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				readErr <- err
+				return
+			}
+
+			out := make(chan rowOutput, 1)
+			select {
+			case pending <- out:
+			case <-ctx.Done():
+				return
+			}
+
+			if row.err.Message != "" {
+				out <- rowOutput{record: row.record, err: row.err}
+				continue
+			}
+			row.record = append([]string(nil), row.record...)
+			select {
+			case tasks <- rowTask{row: row, out: out}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range tasks {
+				task.out <- work(task.row)
+			}
+		}()
+	}
+
+	for out := range pending {
+		var output rowOutput
+		select {
+		case output = <-out:
+		case <-ctx.Done():
+			return Err{Message: "request cancelled"}
+		}
+
+		if err := emit(output); err.Message != "" {
+			return err
+		}
+	}
+
+	if ctx.Err() != nil {
+		return Err{Message: "request cancelled"}
+	}
+	select {
+	case err := <-readErr:
+		return readError(err)
+	default:
+	}
+	return Err{}
+}
+
+// maxRowErrors bounds the row errors a strict upload reports, so a file
+// that fails on every row is not held in memory as errors instead.
+var maxRowErrors = 1000
+
+// validateTaxRows reads the rest of the file and checks every row without
+// calculating. It returns the first maxRowErrors row errors.
+func (h *Handler) validateTaxRows(rows *csvRowReader) ([]RowError, Err) {
+	var rowErrors []RowError
+	for len(rowErrors) < maxRowErrors {
+		row, err := rows.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, readError(err)
+		}
+
+		if row.err.Message == "" {
+			_, row.err = h.parseCSVLine(rows.header, row.record)
+			row.err = rows.locate(row.err, row)
+		}
+		if row.err.Message != "" {
+			rowErrors = append(rowErrors, row.err)
+		}
+	}
+
+	return rowErrors, Err{}
+}
+
+// processCSVLine validates and calculates one row.
+func (h *Handler) processCSVLine(header csvHeader, line []string, settings Settings) (TaxResponseCSV, []TaxLevel, RowError) {
+	userInfo, rowErr := h.parseCSVLine(header, line)
+	if rowErr.Message != "" {
+		return TaxResponseCSV{}, nil, rowErr
 	}
+	return h.calculateCSVLine(header, line, userInfo, settings)
+}
 
-	tax, err := h.store.CalculateTax(userInfo)
+// calculateCSVLine calculates a validated row.
+func (h *Handler) calculateCSVLine(header csvHeader, line []string, userInfo UserInfo, settings Settings) (TaxResponseCSV, []TaxLevel, RowError) {
+	tax, err := h.store.CalculateTaxWithSettings(userInfo, settings)
 	if err != nil {
-		return TaxResponseCSV{}, Err{Message: err.Error()}
+		// The store error is logged, not handed to the client with the row.
+		log.Printf("failed to calculate tax: %v", err)
+		return TaxResponseCSV{}, nil, RowError{Message: "failed to calculate tax"}
 	}
 
 	if tax.Tax < 0.0 {
@@ -79,38 +474,159 @@ func (h *Handler) processCSVLine(line []string) (TaxResponseCSV, Err) {
 		TotalIncome: userInfo.TotalIncome,
 		Tax:         tax.Tax,
 		TaxRefund:   tax.TaxRefund,
-	}, Err{}
+		RowLabel:    header.label(line),
+	}, tax.TaxLevel, RowError{}
 }
 
-func parseUserInfoFromCSVLine(line []string) (UserInfo, error) {
-	if len(line) != 3 {
-		return UserInfo{}, fmt.Errorf("invalid file format")
+// parseCSVLine reads and validates a row without touching any store.
+func (h *Handler) parseCSVLine(header csvHeader, line []string) (UserInfo, RowError) {
+	userInfo, err := parseUserInfoFromCSVLine(header, line)
+	if err != nil {
+		var colErr *columnError
+		if errors.As(err, &colErr) {
+			return UserInfo{}, RowError{Column: colErr.column, Code: colErr.code, Message: colErr.Error(), Text: colErr.text}
+		}
+		text := errText(err)
+		if text.Key == "" {
+			text = newText("invalid file format")
+		}
+		return UserInfo{}, RowError{Message: text.String(), Text: text}
+	}
+
+	if err := h.validationUserInfo(userInfo); err.Message != "" {
+		return UserInfo{}, validationRowError(err)
 	}
 
-	totalIncomeStr, whtStr, donationStr := strings.TrimSpace(line[0]), strings.TrimSpace(line[1]), strings.TrimSpace(line[2])
+	return userInfo, RowError{}
+}
+
+// validationRowError reports the first failed check of a row, with its code.
+func validationRowError(err Err) RowError {
+	return RowError{Column: err.field, Code: err.Errors[0].Code, Message: err.Message, Text: err.text}
+}
 
-	if totalIncomeStr == "" || whtStr == "" || donationStr == "" {
-		return UserInfo{}, fmt.Errorf("totalIncome, wht or donation value can not be empty")
+type csvHeader struct {
+	columns     []string
+	passthrough map[string]bool
+}
+
+const (
+	columnTotalIncome = "totalIncome"
+	columnWHT         = "wht"
+	columnTaxpayerID  = "taxpayerId"
+	columnID          = "id"
+	columnEmployeeID  = "employeeId"
+)
+
+// parseCSVHeader checks the column names. Besides the calculation columns a
+// file may carry id, employeeId and the passthrough columns the caller named;
+// any other column is taken for a typo and rejected. A personal column is
+// rejected once here rather than on every row, and so is a taxpayerId column
+// unless it is passed through: an upload is never linked to a taxpayer.
+func parseCSVHeader(record []string, passthrough []string) (csvHeader, error) {
+	header := csvHeader{columns: make([]string, len(record)), passthrough: map[string]bool{}}
+	allowed := map[string]bool{}
+	for _, name := range passthrough {
+		allowed[name] = true
+	}
+	seen := map[string]bool{}
+	for i, name := range record {
+		name = strings.TrimSpace(name)
+		switch {
+		case name == "personal":
+			return csvHeader{}, newTextError("column %q is not allowed: user can not fill personal allowance", name)
+		case name == columnTotalIncome, name == columnWHT, allowanceTypes[name]:
+		case name == columnID, name == columnEmployeeID:
+		case allowed[name]:
+			header.passthrough[name] = true
+		case name == columnTaxpayerID:
+			return csvHeader{}, newTextError("column %q is not allowed: taxpayerId is only accepted by POST /taxpayers/:taxpayerId/calculations", name)
+		default:
+			return csvHeader{}, newTextError("unknown column %q", name)
+		}
+		if seen[name] {
+			return csvHeader{}, newTextError("duplicate column %q", name)
+		}
+		seen[name] = true
+		header.columns[i] = name
 	}
 
-	totalIncome, err := strconv.ParseFloat(totalIncomeStr, 64)
-	if err != nil {
-		return UserInfo{}, fmt.Errorf("totalIncome must be a numeric value")
+	for _, required := range []string{columnTotalIncome, columnWHT} {
+		if !seen[required] {
+			return csvHeader{}, newTextError("missing column %q", required)
+		}
 	}
 
-	wht, err := strconv.ParseFloat(whtStr, 64)
-	if err != nil {
-		return UserInfo{}, fmt.Errorf("wht must be a numeric value")
+	return header, nil
+}
+
+func (h csvHeader) label(line []string) RowLabel {
+	var label RowLabel
+	for i, column := range h.columns {
+		if i >= len(line) {
+			break
+		}
+		switch {
+		case column == columnID:
+			label.ID = line[i]
+		case column == columnEmployeeID:
+			label.EmployeeID = line[i]
+		case h.passthrough[column]:
+			if label.Passthrough == nil {
+				label.Passthrough = map[string]string{}
+			}
+			label.Passthrough[column] = line[i]
+		}
 	}
+	return label
+}
 
-	donation, err := strconv.ParseFloat(donationStr, 64)
-	if err != nil {
-		return UserInfo{}, fmt.Errorf("donation must be a numeric value")
+func parseUserInfoFromCSVLine(header csvHeader, line []string) (UserInfo, error) {
+	if len(line) != len(header.columns) {
+		return UserInfo{}, newTextError("invalid file format")
+	}
+
+	var userInfo UserInfo
+	for i, column := range header.columns {
+		value := strings.TrimSpace(line[i])
+
+		if header.passthrough[column] {
+			continue
+		}
+		switch column {
+		case columnID, columnEmployeeID:
+			continue
+		case columnTotalIncome:
+			if value == "" {
+				return UserInfo{}, &columnError{column: column, code: CodeTotalIncomeRequired, text: newText("%s value can not be empty", column)}
+			}
+		case columnWHT:
+			if value == "" {
+				return UserInfo{}, &columnError{column: column, code: CodeAmountRequired, text: newText("%s value can not be empty", column)}
+			}
+		default:
+			if value == "" {
+				continue
+			}
+		}
+
+		amount, err := parseAmount(value)
+		if errors.Is(err, errAmountNotFinite) {
+			return UserInfo{}, &columnError{column: column, code: CodeNumberNotFinite, text: newText("%s must be a finite number", column)}
+		}
+		if err != nil {
+			return UserInfo{}, &columnError{column: column, code: CodeInvalidType, text: newText("%s must be a numeric value", column)}
+		}
+
+		switch column {
+		case columnTotalIncome:
+			userInfo.TotalIncome = amount
+		case columnWHT:
+			userInfo.WHT = amount
+		default:
+			userInfo.Allowances = append(userInfo.Allowances, Allowances{AllowanceType: column, Amount: amount})
+		}
 	}
 
-	return UserInfo{
-		TotalIncome: totalIncome,
-		WHT:         wht,
-		Allowances:  []Allowances{{AllowanceType: "donation", Amount: donation}},
-	}, nil
+	return userInfo, nil
 }
@@ -3,21 +3,58 @@
 package tax
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
+var defaultCSVHeader = csvHeader{columns: []string{"totalIncome", "wht", "donation"}}
+
 type MockFileOpener struct {
 	reader *strings.Reader
+	opens  int
 }
 
 func (m *MockFileOpener) Open() (io.ReadCloser, error) {
+	m.opens++
+	if _, err := m.reader.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
 	return io.NopCloser(m.reader), nil
 }
 
+type collectSink struct {
+	taxes  []TaxResponseCSV
+	errors []RowError
+	totals *BatchSummary
+}
+
+func (s *collectSink) columns(columns []string) error {
+	return nil
+}
+
+func (s *collectSink) result(row rowOutput) error {
+	s.taxes = append(s.taxes, row.result)
+	return nil
+}
+
+func (s *collectSink) rowError(row rowOutput) error {
+	s.errors = append(s.errors, row.err)
+	return nil
+}
+
+func (s *collectSink) summary(summary BatchSummary) error {
+	s.totals = &summary
+	return nil
+}
+
 func TestProcessCSVLine(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -47,7 +84,7 @@ func TestProcessCSVLine(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := p.processCSVLine(tt.line)
+			got, _, err := p.processCSVLine(defaultCSVHeader, tt.line, Settings{})
 			if (err.Message != "") != tt.wantErr {
 				t.Errorf("processCSVLine() error = %v, wantErr %v", err, tt.wantErr)
 			}
@@ -65,9 +102,11 @@ func TestProcessTaxFile(t *testing.T) {
 			{TotalIncome: 15000, Tax: 0, TaxRefund: 0},
 		}
 
-		got, err := p.processTaxFile(mockFile)
+		sink := &collectSink{}
+		rowErrors, err := p.processTaxFile(context.Background(), mockFile, fileOptions{mode: ModeStrict}, sink)
 		assert.Equal(t, err.Message, "", "expected no error but got %v", err.Message)
-		assert.Equal(t, want, got, "expected tax %v but got %v", want, got)
+		assert.Empty(t, rowErrors)
+		assert.Equal(t, want, sink.taxes, "expected tax %v but got %v", want, sink.taxes)
 	})
 	t.Run("given invalid file format should return error message", func(t *testing.T) {
 		stubTax := StubTax{}
@@ -75,18 +114,242 @@ func TestProcessTaxFile(t *testing.T) {
 		mockFile := &MockFileOpener{reader: strings.NewReader("totalIncome,wht,donation\n10000,2000\n15000,2500,3500\n")}
 		want := Err{Message: "error reading file: invalid format"}
 
-		got, err := p.processTaxFile(mockFile)
+		sink := &collectSink{}
+		rowErrors, err := p.processTaxFile(context.Background(), mockFile, fileOptions{mode: ModeStrict}, sink)
 		assert.Equal(t, want, err, "expected error %v but got %v", want, err)
-		assert.Nil(t, got, "expected nil but got %v", got)
+		assert.Equal(t, []RowError{{Line: 2, Message: "error reading file: invalid format"}}, rowErrors)
+		assert.Nil(t, sink.taxes, "expected nil but got %v", sink.taxes)
+	})
+	t.Run("given several invalid rows in strict mode should report every row and return no result", func(t *testing.T) {
+		stubTax := StubTax{}
+		p := New(&stubTax)
+		mockFile := &MockFileOpener{reader: strings.NewReader("totalIncome,wht,donation\nabc,0,0\n10000,2000,3000\n15000,xyz,0\n-1,0,0\n")}
+		want := []RowError{
+			{Line: 2, Column: "totalIncome", Code: CodeInvalidType, Message: "totalIncome must be a numeric value", Text: newText("%s must be a numeric value", "totalIncome")},
+			{Line: 4, Column: "wht", Code: CodeInvalidType, Message: "wht must be a numeric value", Text: newText("%s must be a numeric value", "wht")},
+			{Line: 5, Column: "totalIncome", Code: CodeTotalIncomeNegative, Message: "total income must be greater than 0.0"},
+		}
+
+		sink := &collectSink{}
+		rowErrors, err := p.processTaxFile(context.Background(), mockFile, fileOptions{mode: ModeStrict}, sink)
+		assert.Equal(t, Err{Message: "totalIncome must be a numeric value", text: newText("%s must be a numeric value", "totalIncome")}, err)
+		assert.Equal(t, want, rowErrors)
+		assert.Nil(t, sink.taxes, "expected nil but got %v", sink.taxes)
+	})
+	t.Run("given invalid rows in lenient mode should return valid rows and row errors", func(t *testing.T) {
+		stubTax := StubTax{}
+		p := New(&stubTax)
+		mockFile := &MockFileOpener{reader: strings.NewReader("totalIncome,wht,donation\n10000,2000,3000\n15000,2500\n20000,0,-5\n30000,0,0\n")}
+
+		sink := &collectSink{}
+		rowErrors, err := p.processTaxFile(context.Background(), mockFile, fileOptions{mode: ModeLenient}, sink)
+		assert.Equal(t, "", err.Message)
+		assert.Empty(t, rowErrors)
+		assert.Equal(t, []RowError{
+			{Line: 3, Message: "error reading file: invalid format"},
+			{Line: 4, Column: "donation", Code: CodeAllowanceAmountNegative, Message: "allowance amount must be greater than or equal to 0.0"},
+		}, sink.errors)
+		assert.Equal(t, []TaxResponseCSV{{TotalIncome: 10000}, {TotalIncome: 30000}}, sink.taxes)
+	})
+	t.Run("given many rows should read settings once", func(t *testing.T) {
+		stubTax := StubTax{}
+		p := New(&stubTax)
+		mockFile := &MockFileOpener{reader: strings.NewReader("totalIncome,wht\n10000,0\n20000,0\n30000,0\n")}
+
+		sink := &collectSink{}
+		_, err := p.processTaxFile(context.Background(), mockFile, fileOptions{mode: ModeLenient}, sink)
+		assert.Equal(t, "", err.Message)
+		assert.Equal(t, 1, stubTax.settingsCalls)
+		assert.Len(t, sink.taxes, 3)
+	})
+	t.Run("given strict mode should validate the file, then read it again and calculate it", func(t *testing.T) {
+		stubTax := StubTax{}
+		p := New(&stubTax)
+		mockFile := &MockFileOpener{reader: strings.NewReader("totalIncome,wht\n10000,0\n20000,0\n")}
+
+		sink := &collectSink{}
+		_, err := p.processTaxFile(context.Background(), mockFile, fileOptions{mode: ModeStrict}, sink)
+		assert.Equal(t, "", err.Message)
+		assert.Equal(t, 2, mockFile.opens)
+		assert.Len(t, sink.taxes, 2)
+	})
+	t.Run("given strict mode with invalid rows should read the file once and calculate nothing", func(t *testing.T) {
+		stubTax := StubTax{}
+		p := New(&stubTax)
+		mockFile := &MockFileOpener{reader: strings.NewReader("totalIncome,wht\n10000,0\nabc,0\n")}
+
+		sink := &collectSink{}
+		rowErrors, err := p.processTaxFile(context.Background(), mockFile, fileOptions{mode: ModeStrict}, sink)
+		assert.Equal(t, "totalIncome must be a numeric value", err.Message)
+		assert.Len(t, rowErrors, 1)
+		assert.Equal(t, 1, mockFile.opens)
+		assert.Equal(t, 0, stubTax.settingsCalls)
+		assert.Nil(t, sink.taxes)
+	})
+	t.Run("given strict mode with more invalid rows than the limit should return the first ones", func(t *testing.T) {
+		defer func(limit int) { maxRowErrors = limit }(maxRowErrors)
+		maxRowErrors = 2
+		p := New(&StubTax{})
+		mockFile := &MockFileOpener{reader: strings.NewReader("totalIncome,wht\nabc,0\ndef,0\nghi,0\n")}
+
+		rowErrors, err := p.processTaxFile(context.Background(), mockFile, fileOptions{mode: ModeStrict}, &collectSink{})
+		assert.Equal(t, "totalIncome must be a numeric value", err.Message)
+		assert.Equal(t, []int{2, 3}, []int{rowErrors[0].Line, rowErrors[1].Line})
+		assert.Len(t, rowErrors, 2)
 	})
+	t.Run("given strict mode with a row failing to calculate should stop at that row", func(t *testing.T) {
+		store := &failingStore{failAt: 20000}
+		p := New(store).WithWorkers(1)
+		mockFile := &MockFileOpener{reader: strings.NewReader("totalIncome,wht\n10000,0\n20000,0\n30000,0\n")}
+
+		sink := &collectSink{}
+		rowErrors, err := p.processTaxFile(context.Background(), mockFile, fileOptions{mode: ModeStrict}, sink)
+		assert.Equal(t, Err{Message: "failed to calculate tax"}, err)
+		assert.Equal(t, []RowError{{Line: 3, Message: "failed to calculate tax"}}, rowErrors)
+		assert.Equal(t, []TaxResponseCSV{{TotalIncome: 10000}}, sink.taxes)
+		assert.Nil(t, sink.totals)
+	})
+	t.Run("given a taxpayerId column should reject the file and save nothing", func(t *testing.T) {
+		history := StubHistory{}
+		p := New(&StubTax{}).WithHistory(&history).WithTaxpayers(&StubTaxpayers{})
+		mockFile := &MockFileOpener{reader: strings.NewReader("taxpayerId,totalIncome,wht\n1101700230708,10000,0\n")}
+
+		sink := &collectSink{}
+		_, err := p.processTaxFile(context.Background(), mockFile, fileOptions{mode: ModeLenient}, sink)
+		assert.Equal(t, `column "taxpayerId" is not allowed: taxpayerId is only accepted by POST /taxpayers/:taxpayerId/calculations`, err.Message)
+		assert.Nil(t, sink.taxes)
+		assert.Equal(t, Calculation{}, history.saved)
+	})
+	t.Run("given a taxpayerId passthrough column should echo it and save nothing", func(t *testing.T) {
+		history := StubHistory{}
+		p := New(&StubTax{}).WithHistory(&history)
+		mockFile := &MockFileOpener{reader: strings.NewReader("taxpayerId,totalIncome,wht\n1101700230708,10000,0\n")}
+
+		sink := &collectSink{}
+		_, err := p.processTaxFile(context.Background(), mockFile, fileOptions{mode: ModeStrict, passthrough: []string{"taxpayerId"}}, sink)
+		assert.Equal(t, "", err.Message)
+		assert.Equal(t, []TaxResponseCSV{{TotalIncome: 10000, RowLabel: RowLabel{Passthrough: map[string]string{"taxpayerId": "1101700230708"}}}}, sink.taxes)
+		assert.Equal(t, Calculation{}, history.saved)
+	})
+}
+
+type delayStore struct {
+	StubTax
+	delay func(userInfo UserInfo) time.Duration
+	calls int64
+}
+
+func (s *delayStore) CalculateTaxWithSettings(userInfo UserInfo, settings Settings) (Tax, error) {
+	atomic.AddInt64(&s.calls, 1)
+	time.Sleep(s.delay(userInfo))
+	return Tax{Tax: userInfo.TotalIncome / 10}, nil
+}
+
+type failingStore struct {
+	StubTax
+	failAt float64
+}
+
+func (s *failingStore) CalculateTaxWithSettings(userInfo UserInfo, settings Settings) (Tax, error) {
+	if userInfo.TotalIncome == s.failAt {
+		return Tax{}, errors.New("pq: connection refused")
+	}
+	return Tax{}, nil
+}
+
+func csvContent(rows int) string {
+	var b strings.Builder
+	b.WriteString("totalIncome,wht\n")
+	for i := 1; i <= rows; i++ {
+		fmt.Fprintf(&b, "%d,0\n", i*1000)
+	}
+	return b.String()
 }
+
+func csvFile(rows int) *MockFileOpener {
+	return &MockFileOpener{reader: strings.NewReader(csvContent(rows))}
+}
+
+func TestProcessTaxFileWorkers(t *testing.T) {
+	t.Run("given rows finishing out of order should return results in input order", func(t *testing.T) {
+		store := &delayStore{delay: func(userInfo UserInfo) time.Duration {
+			return time.Duration(int(userInfo.TotalIncome/1000)%4) * time.Millisecond
+		}}
+		p := New(store).WithWorkers(4)
+
+		sink := &collectSink{}
+		_, err := p.processTaxFile(context.Background(), csvFile(20), fileOptions{mode: ModeLenient}, sink)
+		assert.Equal(t, "", err.Message)
+		assert.Len(t, sink.taxes, 20)
+		for i, got := range sink.taxes {
+			assert.Equal(t, float64((i+1)*1000), got.TotalIncome)
+			assert.Equal(t, got.TotalIncome/10, got.Tax)
+		}
+	})
+	t.Run("given cancelled context should stop the batch", func(t *testing.T) {
+		store := &delayStore{delay: func(UserInfo) time.Duration { return time.Millisecond }}
+		p := New(store).WithWorkers(2)
+		ctx, cancel := context.WithCancel(context.Background())
+
+		sink := &cancelSink{cancel: cancel, after: 3}
+		_, err := p.processTaxFile(ctx, csvFile(1000), fileOptions{mode: ModeLenient}, sink)
+		assert.Equal(t, Err{Message: "request cancelled"}, err)
+		assert.Less(t, atomic.LoadInt64(&store.calls), int64(1000))
+	})
+}
+
+type cancelSink struct {
+	collectSink
+	cancel func()
+	after  int
+}
+
+func (s *cancelSink) result(row rowOutput) error {
+	if len(s.taxes) == s.after {
+		s.cancel()
+	}
+	return s.collectSink.result(row)
+}
+
+func benchmarkProcessTaxFile(b *testing.B, workers int) {
+	store := &delayStore{delay: func(UserInfo) time.Duration { return 50 * time.Microsecond }}
+	p := New(store).WithWorkers(workers)
+	file := csvFile(1000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.processTaxFile(context.Background(), file, fileOptions{mode: ModeLenient}, &collectSink{}); err.Message != "" {
+			b.Fatal(err.Message)
+		}
+	}
+}
+
+func BenchmarkProcessTaxFileSequential(b *testing.B) { benchmarkProcessTaxFile(b, 1) }
+func BenchmarkProcessTaxFile4Workers(b *testing.B)   { benchmarkProcessTaxFile(b, 4) }
+func BenchmarkProcessTaxFile16Workers(b *testing.B)  { benchmarkProcessTaxFile(b, 16) }
+
 func TestParseUserInfoFromCSVLine(t *testing.T) {
 	tests := []struct {
 		name    string
+		header  csvHeader
 		line    []string
 		want    UserInfo
 		wantErr bool
 	}{
+		{
+			name:    "valid input with columns in any order",
+			header:  csvHeader{columns: []string{"k-receipt", "wht", "totalIncome", "donation"}},
+			line:    []string{"20000", "5000", " 500000 ", "5000"},
+			want:    UserInfo{TotalIncome: 500000.0, WHT: 5000.0, Allowances: []Allowances{{AllowanceType: "k-receipt", Amount: 20000.0}, {AllowanceType: "donation", Amount: 5000.0}}},
+			wantErr: false,
+		},
+		{
+			name:    "empty allowance column is skipped",
+			header:  csvHeader{columns: []string{"totalIncome", "wht", "k-receipt"}},
+			line:    []string{"500000", "0", ""},
+			want:    UserInfo{TotalIncome: 500000.0},
+			wantErr: false,
+		},
 		{
 			name:    "valid input",
 			line:    []string{"500000", "5000", "5000"},
@@ -133,7 +396,11 @@ func TestParseUserInfoFromCSVLine(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := parseUserInfoFromCSVLine(tt.line)
+			header := tt.header
+			if header.columns == nil {
+				header = defaultCSVHeader
+			}
+			got, err := parseUserInfoFromCSVLine(header, tt.line)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("parseUserInfoFromCSVLine() got error = %v, wantErr %v", err, tt.wantErr)
 			}
@@ -141,3 +408,75 @@ func TestParseUserInfoFromCSVLine(t *testing.T) {
 		})
 	}
 }
+
+func TestCSVHeaderLabel(t *testing.T) {
+	header := csvHeader{columns: []string{"id", "employeeId", "totalIncome", "wht", "department"}, passthrough: map[string]bool{"department": true}}
+
+	assert.Equal(t, RowLabel{ID: "7", EmployeeID: "E-0012", Passthrough: map[string]string{"department": "Sales"}}, header.label([]string{"7", "E-0012", "500000", "0", "Sales"}))
+	assert.Equal(t, RowLabel{ID: "7"}, header.label([]string{"7"}))
+}
+
+func TestParseCSVHeader(t *testing.T) {
+	tests := []struct {
+		name        string
+		record      []string
+		passthrough []string
+		want        csvHeader
+		wantErr     string
+	}{
+		{
+			name:   "known columns in any order",
+			record: []string{"donation", " wht", "totalIncome ", "k-receipt"},
+			want:   csvHeader{columns: []string{"donation", "wht", "totalIncome", "k-receipt"}, passthrough: map[string]bool{}},
+		},
+		{
+			name:    "taxpayerId column",
+			record:  []string{"taxpayerId", "totalIncome", "wht"},
+			wantErr: `column "taxpayerId" is not allowed: taxpayerId is only accepted by POST /taxpayers/:taxpayerId/calculations`,
+		},
+		{
+			name:        "taxpayerId passthrough column",
+			record:      []string{"taxpayerId", "totalIncome", "wht"},
+			passthrough: []string{"taxpayerId"},
+			want:        csvHeader{columns: []string{"taxpayerId", "totalIncome", "wht"}, passthrough: map[string]bool{"taxpayerId": true}},
+		},
+		{
+			name:        "identifier and passthrough columns",
+			record:      []string{"employeeId", "totalIncome", "wht", "department"},
+			passthrough: []string{"department", "costCenter"},
+			want:        csvHeader{columns: []string{"employeeId", "totalIncome", "wht", "department"}, passthrough: map[string]bool{"department": true}},
+		},
+		{
+			name:    "unknown column",
+			record:  []string{"totalIncome", "wht", "bonus"},
+			wantErr: `unknown column "bonus"`,
+		},
+		{
+			name:    "duplicate column",
+			record:  []string{"totalIncome", "wht", "donation", "donation"},
+			wantErr: `duplicate column "donation"`,
+		},
+		{
+			name:    "personal column",
+			record:  []string{"totalIncome", "wht", "personal"},
+			wantErr: `column "personal" is not allowed: user can not fill personal allowance`,
+		},
+		{
+			name:    "missing required column",
+			record:  []string{"totalIncome", "donation"},
+			wantErr: `missing column "wht"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCSVHeader(tt.record, tt.passthrough)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,338 @@
+package tax
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// maxJSONBody is the largest JSON request body bindJSON reads.
+var maxJSONBody int64 = 8 << 20
+
+// bindJSON reads a JSON request body into v and returns the status to answer
+// with when it can not. In the default strict mode unknown fields, duplicate
+// keys, values of the wrong type, numbers too large to be finite and anything
+// after the document are refused, each reported with the path of its field.
+// ?decode=lenient binds the way c.Bind always has.
+func bindJSON(c echo.Context, v interface{}) (int, Err) {
+	decode, errDecode := decodeParam(c)
+	if errDecode.Message != "" {
+		return http.StatusBadRequest, errDecode
+	}
+	req := c.Request()
+	req.Body = http.MaxBytesReader(c.Response(), req.Body, maxJSONBody)
+
+	if decode == ModeLenient {
+		if err := c.Bind(v); err != nil {
+			return bodyError(err)
+		}
+		return http.StatusOK, Err{}
+	}
+
+	if !strings.HasPrefix(req.Header.Get(echo.HeaderContentType), echo.MIMEApplicationJSON) {
+		return http.StatusBadRequest, Err{Message: "invalid request body"}
+	}
+	data, err := io.ReadAll(req.Body)
+	if err != nil {
+		return bodyError(err)
+	}
+	if errBody := decodeStrict(data, v); errBody.Message != "" {
+		return http.StatusBadRequest, errBody
+	}
+	return http.StatusOK, Err{}
+}
+
+// bodyError tells a body cut off at maxJSONBody from one that is malformed.
+func bodyError(err error) (int, Err) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge, Err{Message: "request body is too large"}
+	}
+	return http.StatusBadRequest, Err{Message: "invalid request body"}
+}
+
+// decodeParam picks how JSON request bodies are decoded from ?decode=. It is
+// kept apart from ?mode=, which says how the rows of an uploaded file are
+// handled.
+func decodeParam(c echo.Context) (string, Err) {
+	switch decode := c.QueryParam("decode"); decode {
+	case "", ModeStrict:
+		return ModeStrict, Err{}
+	case ModeLenient:
+		return ModeLenient, Err{}
+	default:
+		return "", Err{Message: "decode must be strict or lenient"}
+	}
+}
+
+// decodeJSON decodes one JSON document the way decodeParam picked, for
+// documents not read through bindJSON such as the lines of an NDJSON stream
+// and the items of a batch.
+func decodeJSON(decode string, data []byte, v interface{}) Err {
+	if decode == ModeLenient {
+		if err := json.Unmarshal(data, v); err != nil {
+			return Err{Message: "invalid request body"}
+		}
+		return Err{}
+	}
+	return decodeStrict(data, v)
+}
+
+func decodeStrict(data []byte, v interface{}) Err {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	doc, err := readJSONValue(dec)
+	if err != nil {
+		return Err{Message: "invalid request body"}
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return Err{Message: "unexpected data after the request body"}
+	}
+
+	var errs validationErrors
+	checkJSONValue(&errs, doc, reflect.TypeOf(v).Elem(), "")
+	if errDoc := errs.err(); errDoc.Message != "" {
+		return errDoc
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return Err{Message: "invalid request body"}
+	}
+	return Err{}
+}
+
+// jsonValue is a decoded JSON value that, unlike a map, keeps every key of an
+// object in order, duplicates included.
+type jsonValue struct {
+	kind   string
+	scalar interface{}
+	keys   []string
+	values []jsonValue
+}
+
+func readJSONValue(dec *json.Decoder) (jsonValue, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return jsonValue{}, err
+	}
+	switch tok := tok.(type) {
+	case json.Delim:
+		value := jsonValue{kind: "array"}
+		if tok == '{' {
+			value.kind = "object"
+		}
+		for dec.More() {
+			if value.kind == "object" {
+				key, err := dec.Token()
+				if err != nil {
+					return jsonValue{}, err
+				}
+				value.keys = append(value.keys, key.(string))
+			}
+			item, err := readJSONValue(dec)
+			if err != nil {
+				return jsonValue{}, err
+			}
+			value.values = append(value.values, item)
+		}
+		if _, err := dec.Token(); err != nil {
+			return jsonValue{}, err
+		}
+		return value, nil
+	case json.Number:
+		return jsonValue{kind: "number", scalar: tok}, nil
+	case string:
+		return jsonValue{kind: "string", scalar: tok}, nil
+	case bool:
+		return jsonValue{kind: "boolean", scalar: tok}, nil
+	default:
+		return jsonValue{kind: "null"}, nil
+	}
+}
+
+// raw is the value as it is reported back in a FieldError.
+func (v jsonValue) raw() interface{} {
+	switch v.kind {
+	case "object":
+		object := make(map[string]interface{}, len(v.keys))
+		for i, key := range v.keys {
+			object[key] = v.values[i].raw()
+		}
+		return object
+	case "array":
+		array := make([]interface{}, len(v.values))
+		for i, item := range v.values {
+			array[i] = item.raw()
+		}
+		return array
+	default:
+		return v.scalar
+	}
+}
+
+var (
+	jsonUnmarshaler = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	jsonRawMessage  = reflect.TypeOf(json.RawMessage(nil))
+)
+
+func checkJSONValue(errs *validationErrors, value jsonValue, t reflect.Type, path string) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	// A json.RawMessage is decoded on its own later, which checks it then.
+	if t == jsonRawMessage {
+		return
+	}
+	if value.kind == "null" || t.Kind() == reflect.Interface || reflect.PtrTo(t).Implements(jsonUnmarshaler) {
+		checkDuplicateKeys(errs, value, path)
+		return
+	}
+
+	want := jsonKind(t)
+	if value.kind != want {
+		errs.addf(CodeInvalidType, path, value.raw(), "", kindMessages[want], fieldName(path))
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		fields := jsonFields(t)
+		seen := map[string]bool{}
+		for i, key := range value.keys {
+			child := joinPath(path, key)
+			field, ok := fields[key]
+			switch {
+			case seen[key]:
+				errs.addf(CodeDuplicateField, child, value.values[i].raw(), "", "duplicate field %q", child)
+			case !ok:
+				errs.addf(CodeUnknownField, child, value.values[i].raw(), "", "unknown field %q", child)
+			default:
+				checkJSONValue(errs, value.values[i], field, child)
+			}
+			seen[key] = true
+		}
+	case reflect.Map:
+		seen := map[string]bool{}
+		for i, key := range value.keys {
+			child := joinPath(path, key)
+			if seen[key] {
+				errs.addf(CodeDuplicateField, child, value.values[i].raw(), "", "duplicate field %q", child)
+			} else {
+				checkJSONValue(errs, value.values[i], t.Elem(), child)
+			}
+			seen[key] = true
+		}
+	case reflect.Slice, reflect.Array:
+		for i, item := range value.values {
+			checkJSONValue(errs, item, t.Elem(), path+"["+strconv.Itoa(i)+"]")
+		}
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(value.scalar.(json.Number).String(), t.Bits())
+		if err != nil || math.IsInf(n, 0) {
+			errs.addf(CodeNumberNotFinite, path, value.raw(), "", "%s must be a finite number", fieldName(path))
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if _, err := strconv.ParseInt(value.scalar.(json.Number).String(), 10, t.Bits()); err != nil {
+			errs.addf(CodeInvalidType, path, value.raw(), "", "%s must be an integer", fieldName(path))
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if _, err := strconv.ParseUint(value.scalar.(json.Number).String(), 10, t.Bits()); err != nil {
+			errs.addf(CodeInvalidType, path, value.raw(), "", "%s must be an integer", fieldName(path))
+		}
+	}
+}
+
+// checkDuplicateKeys looks for repeated keys in a value bound to an
+// interface, which takes any shape.
+func checkDuplicateKeys(errs *validationErrors, value jsonValue, path string) {
+	seen := map[string]bool{}
+	for i, item := range value.values {
+		child := path + "[" + strconv.Itoa(i) + "]"
+		if value.kind == "object" {
+			child = joinPath(path, value.keys[i])
+			if seen[value.keys[i]] {
+				errs.addf(CodeDuplicateField, child, item.raw(), "", "duplicate field %q", child)
+				continue
+			}
+			seen[value.keys[i]] = true
+		}
+		checkDuplicateKeys(errs, item, child)
+	}
+}
+
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map:
+		return "object"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	default:
+		return "number"
+	}
+}
+
+// jsonFields maps the JSON names of t's fields, including those of embedded
+// structs, to their types. Unlike encoding/json, names match exactly.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			for embedded, fieldType := range jsonFields(field.Type) {
+				if _, ok := fields[embedded]; !ok {
+					fields[embedded] = fieldType
+				}
+			}
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func fieldName(path string) string {
+	if path == "" {
+		return "request body"
+	}
+	return path
+}
+
+// kindMessages is the message for a value that is not of the JSON kind a
+// field takes.
+var kindMessages = map[string]string{
+	"object":  "%s must be an object",
+	"array":   "%s must be an array",
+	"string":  "%s must be a string",
+	"boolean": "%s must be a boolean",
+	"number":  "%s must be a number",
+}
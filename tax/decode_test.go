@@ -0,0 +1,157 @@
+// go:build unit
+
+package tax
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeStrict(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want Err
+	}{
+		{"given known fields should decode", `{"totalIncome": 500000, "wht": 0, "allowances": [{"allowanceType": "donation", "amount": 100}]}`, Err{}},
+		{"given null field should decode", `{"totalIncome": 500000, "wht": null, "allowances": null}`, Err{}},
+		{"given unknown field should report it", `{"totalIncome": 500000, "withholding": 1000}`,
+			Err{Message: `unknown field "withholding"`, Errors: []FieldError{{Code: CodeUnknownField, Path: "withholding", Value: json.Number("1000"), Message: `unknown field "withholding"`, text: newText("unknown field %q", "withholding")}}, text: newText("unknown field %q", "withholding")}},
+		{"given field in another case should report it as unknown", `{"TotalIncome": 500000}`,
+			Err{Message: `unknown field "TotalIncome"`, Errors: []FieldError{{Code: CodeUnknownField, Path: "TotalIncome", Value: json.Number("500000"), Message: `unknown field "TotalIncome"`, text: newText("unknown field %q", "TotalIncome")}}, text: newText("unknown field %q", "TotalIncome")}},
+		{"given duplicate key should report it", `{"totalIncome": 500000, "wht": 0, "wht": 25000}`,
+			Err{Message: `duplicate field "wht"`, Errors: []FieldError{{Code: CodeDuplicateField, Path: "wht", Value: json.Number("25000"), Message: `duplicate field "wht"`, text: newText("duplicate field %q", "wht")}}, text: newText("duplicate field %q", "wht")}},
+		{"given nested problems should report every one with its path", `{"totalIncome": 1e400, "allowances": [{"allowanceType": "donation", "amount": 1}, {"allowanceType": "k-receipt", "amount": "100", "bonus": true}]}`,
+			Err{Message: "totalIncome must be a finite number", Errors: []FieldError{
+				{Code: CodeNumberNotFinite, Path: "totalIncome", Value: json.Number("1e400"), Message: "totalIncome must be a finite number", text: newText("%s must be a finite number", "totalIncome")},
+				{Code: CodeInvalidType, Path: "allowances[1].amount", Value: "100", Message: "allowances[1].amount must be a number", text: newText("%s must be a number", "allowances[1].amount")},
+				{Code: CodeUnknownField, Path: "allowances[1].bonus", Value: true, Message: `unknown field "allowances[1].bonus"`, text: newText("unknown field %q", "allowances[1].bonus")},
+			}, text: newText("%s must be a finite number", "totalIncome")}},
+		{"given array for the body should report its type", `[]`,
+			Err{Message: "request body must be an object", Errors: []FieldError{{Code: CodeInvalidType, Path: "", Value: []interface{}{}, Message: "request body must be an object", text: newText("%s must be an object", "request body")}}, text: newText("%s must be an object", "request body")}},
+		{"given trailing data should return error message", `{"totalIncome": 500000} {"totalIncome": 1}`, Err{Message: "unexpected data after the request body"}},
+		{"given NaN should return error message", `{"totalIncome": NaN}`, Err{Message: "invalid request body"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var userInfo UserInfo
+
+			got := decodeStrict([]byte(tt.body), &userInfo)
+
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestStrictJSONHandlers(t *testing.T) {
+	t.Run("given unknown field should return status 400 with the field", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/tax/calculations", strings.NewReader(`{"totalIncome": 500000.0, "withholding": 25000.0}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		err := serve(c, New(&StubTax{}).CalculateTaxHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
+		assert.JSONEq(t, `{"type": "about:blank", "title": "Bad Request", "status": 400, "detail": "unknown field \"withholding\"", "instance": "/tax/calculations", "message": "unknown field \"withholding\"",
+			"errors": [{"code": "UNKNOWN_FIELD", "path": "withholding", "value": 25000.0, "message": "unknown field \"withholding\""}]}`, rec.Body.String())
+	})
+	t.Run("given lenient decoding should ignore unknown field", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/tax/calculations?decode=lenient", strings.NewReader(`{"totalIncome": 500000.0, "withholding": 25000.0}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		err := serve(c, New(&StubTax{calculateTax: Tax{Tax: 29000, TaxLevel: []TaxLevel{}}}).CalculateTaxHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusOK, rec.Code, "expected status code %d but got %d", http.StatusOK, rec.Code)
+	})
+	t.Run("given lenient file mode should still decode the body strictly", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/tax/calculations?mode=lenient", strings.NewReader(`{"totalIncome": 500000.0, "withholding": 25000.0}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		err := serve(c, New(&StubTax{}).CalculateTaxHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"code":"UNKNOWN_FIELD"`)
+	})
+	t.Run("given body over the limit should return status 413", func(t *testing.T) {
+		defer func(limit int64) { maxJSONBody = limit }(maxJSONBody)
+		maxJSONBody = 16
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/tax/calculations", strings.NewReader(`{"totalIncome": 500000.0, "wht": 0.0}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		err := serve(c, New(&StubTax{}).CalculateTaxHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code, "expected status code %d but got %d", http.StatusRequestEntityTooLarge, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"message":"request body is too large"`)
+	})
+	t.Run("given unknown decoding should return status 400", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/admin/deductions/personal?decode=loose", strings.NewReader(`{"amount": 60000.0}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		err := serve(c, New(&StubTax{}).SettingPersonalDeductionHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"message":"decode must be strict or lenient"`)
+	})
+	t.Run("given batch item with unknown field should fail that item only", func(t *testing.T) {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		c := e.NewContext(batchRequest(`[{"totalIncome":500000},{"totalIncome":500000,"withholding":0},{"totalIncome":500000,"wht":0,"wht":1}]`), rec)
+
+		err := serve(c, New(&StubTax{}).CalculateTaxBatchHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusOK, rec.Code, "expected status code %d but got %d", http.StatusOK, rec.Code)
+		assert.Equal(t, `{"succeeded":1,"failed":2,"results":[{"index":0,"status":200,"tax":{"tax":0,"taxLevel":null}},`+
+			`{"index":1,"status":400,"error":{"message":"unknown field \"withholding\"","errors":[{"code":"UNKNOWN_FIELD","path":"withholding","value":0,"message":"unknown field \"withholding\""}]}},`+
+			`{"index":2,"status":400,"error":{"message":"duplicate field \"wht\"","errors":[{"code":"DUPLICATE_FIELD","path":"wht","value":1,"message":"duplicate field \"wht\""}]}}]}`, strings.TrimSuffix(rec.Body.String(), "\n"))
+	})
+	t.Run("given batch that is not an array should return status 400", func(t *testing.T) {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		c := e.NewContext(batchRequest(`{"totalIncome":500000}`), rec)
+
+		err := serve(c, New(&StubTax{}).CalculateTaxBatchHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"message":"request body must be an array"`)
+	})
+	t.Run("given ndjson line with duplicate key should return an error line", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/tax/calculations/ndjson", strings.NewReader(`{"totalIncome":500000,"wht":0,"wht":1}`))
+		req.Header.Set(echo.HeaderContentType, MIMEApplicationNDJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		err := serve(c, New(&StubTax{}).CalculateTaxNDJSONHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, `{"line":1,"status":400,"error":{"message":"duplicate field \"wht\"","errors":[{"code":"DUPLICATE_FIELD","path":"wht","value":1,"message":"duplicate field \"wht\""}]}}`+"\n", rec.Body.String())
+	})
+}
@@ -0,0 +1,74 @@
+package tax
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+const maxWHTShare = 0.3
+
+func (h *Handler) ValidateTaxCSVHandler(c echo.Context) error {
+	file, err := c.FormFile("taxFile")
+	if err != nil {
+		return problem(http.StatusBadRequest, Err{Message: "invalid file : key must be taxFile"})
+	}
+
+	options, errOptions := fileOptionsParam(c)
+	if errOptions.Message != "" {
+		return problem(http.StatusBadRequest, errOptions)
+	}
+
+	report, errFile := h.validateFileReport(&MultipartFileHeader{file}, options)
+	if errFile.Message != "" {
+		return problem(http.StatusBadRequest, errFile)
+	}
+
+	return c.JSON(http.StatusOK, report)
+}
+
+// validateFileReport checks every row of a file the way an upload would,
+// short of calculating: nothing is read from or written to a store.
+func (h *Handler) validateFileReport(file FileOpener, options fileOptions) (ValidationReport, Err) {
+	rows, errFile := openCSVRows(file, options)
+	if errFile.Message != "" {
+		return ValidationReport{}, errFile
+	}
+	defer rows.Close()
+
+	report := ValidationReport{
+		Columns:  rows.header.columns,
+		Errors:   []RowError{},
+		Warnings: []RowError{},
+	}
+	for {
+		row, err := rows.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return ValidationReport{}, readError(err)
+		}
+		report.Rows++
+
+		if row.err.Message != "" {
+			report.Errors = append(report.Errors, row.err)
+			continue
+		}
+
+		userInfo, rowErr := h.parseCSVLine(rows.header, row.record)
+		if rowErr.Message != "" {
+			report.Errors = append(report.Errors, rows.locate(rowErr, row))
+			continue
+		}
+		report.ValidRows++
+
+		if userInfo.WHT > userInfo.TotalIncome*maxWHTShare {
+			report.Warnings = append(report.Warnings, rows.locate(RowError{Column: columnWHT, Message: "wht is more than 30% of total income"}, row))
+		}
+	}
+
+	report.Valid = len(report.Errors) == 0
+	return report, Err{}
+}
@@ -0,0 +1,67 @@
+// go:build unit
+
+package tax
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateTaxCSVHandler(t *testing.T) {
+	t.Run("given a file with bad rows should return status 200 and a report without calling the store", func(t *testing.T) {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		req := uploadRequest("/tax/calculations/upload-csv/validate", "totalIncome,wht,donation\n1000,200,0\n1000,400,0\n1000,2000,0\nabc,0,0")
+		c := e.NewContext(req, rec)
+		stubTax := StubTax{}
+		p := New(&stubTax)
+
+		err := serve(c, p.ValidateTaxCSVHandler)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var report ValidationReport
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+		assert.False(t, report.Valid)
+		assert.Equal(t, 4, report.Rows)
+		assert.Equal(t, 2, report.ValidRows)
+		assert.Equal(t, []string{"totalIncome", "wht", "donation"}, report.Columns)
+		assert.Equal(t, []RowError{
+			{Line: 4, Column: "wht", Code: CodeWHTExceedsIncome, Message: "wht must be less than or equal to total income"},
+			{Line: 5, Column: "totalIncome", Code: CodeInvalidType, Message: "totalIncome must be a numeric value"},
+		}, report.Errors)
+		assert.Equal(t, []RowError{{Line: 3, Column: "wht", Message: "wht is more than 30% of total income"}}, report.Warnings)
+		assert.Equal(t, 0, stubTax.settingsCalls)
+		assert.Equal(t, UserInfo{}, stubTax.userInfo)
+	})
+	t.Run("given a taxpayerId column should return status 400", func(t *testing.T) {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		req := uploadRequest("/tax/calculations/upload-csv/validate", "taxpayerId,totalIncome,wht\n1101700230708,500000,0")
+		c := e.NewContext(req, rec)
+		p := New(&StubTax{})
+
+		err := serve(c, p.ValidateTaxCSVHandler)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"message":"column \"taxpayerId\" is not allowed: taxpayerId is only accepted by POST /taxpayers/:taxpayerId/calculations"`)
+	})
+	t.Run("given a file without the required header should return status 400", func(t *testing.T) {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		req := uploadRequest("/tax/calculations/upload-csv/validate", "wht,donation\n0,0")
+		c := e.NewContext(req, rec)
+		p := New(&StubTax{})
+
+		err := serve(c, p.ValidateTaxCSVHandler)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
@@ -0,0 +1,153 @@
+package tax
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/transform"
+)
+
+const (
+	EncodingUTF8       = "utf-8"
+	EncodingTIS620     = "tis-620"
+	EncodingWindows874 = "windows-874"
+
+	sniffSize = 64 * 1024
+)
+
+var delimiters = map[string]rune{
+	",":         ',',
+	"comma":     ',',
+	";":         ';',
+	"semicolon": ';',
+	"\t":        '\t',
+	"tab":       '\t',
+}
+
+// thousands matches amounts grouped the Thai way, e.g. 1,500,000.00.
+var thousands = regexp.MustCompile(`^[-+]?\d{1,3}(,\d{3})+(\.\d+)?$`)
+
+// decimal matches the plain decimal amounts strconv.ParseFloat is trusted
+// with; it also reads hex floats, which no spreadsheet means as an amount.
+var decimal = regexp.MustCompile(`^[-+]?(\d+\.?\d*|\.\d+)([eE][-+]?\d+)?$`)
+
+var (
+	errAmountNotNumeric = errors.New("amount is not a number")
+	errAmountNotFinite  = errors.New("amount is not a finite number")
+)
+
+// csvInput prepares a CSV upload for csv.Reader: it drops a UTF-8 BOM,
+// decodes TIS-620/Windows-874 to UTF-8 and picks the delimiter. Both the
+// encoding and the delimiter are detected from the start of the file unless
+// given in options.
+func csvInput(buffered *bufio.Reader, options fileOptions) (io.Reader, rune) {
+	if bom, _ := buffered.Peek(len(utf8BOM)); bytes.Equal(bom, utf8BOM) {
+		buffered.Discard(len(utf8BOM))
+		if options.encoding == "" {
+			options.encoding = EncodingUTF8
+		}
+	}
+
+	sample, _ := buffered.Peek(sniffSize)
+	if options.encoding == "" {
+		options.encoding = detectEncoding(sample)
+	}
+	delimiter := options.delimiter
+	if delimiter == 0 {
+		delimiter = detectDelimiter(sample)
+	}
+
+	if options.encoding == EncodingUTF8 {
+		return buffered, delimiter
+	}
+	return transform.NewReader(buffered, charmap.Windows874.NewDecoder()), delimiter
+}
+
+// detectEncoding assumes UTF-8 when the sample is valid UTF-8 and the Thai
+// Windows code page otherwise. TIS-620 is a subset of Windows-874.
+func detectEncoding(sample []byte) string {
+	if utf8.Valid(sample) {
+		return EncodingUTF8
+	}
+	// The sample may end part way through a character.
+	if i := lastRuneStart(sample); i > 0 && utf8.Valid(sample[:i]) {
+		return EncodingUTF8
+	}
+	return EncodingWindows874
+}
+
+func lastRuneStart(sample []byte) int {
+	for i := len(sample) - 1; i >= 0 && i >= len(sample)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(sample[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+// detectDelimiter counts the candidate delimiters outside quotes on the
+// header line and picks the most frequent, defaulting to a comma.
+func detectDelimiter(sample []byte) rune {
+	if i := bytes.IndexByte(sample, '\n'); i >= 0 {
+		sample = sample[:i]
+	}
+
+	counts := map[rune]int{}
+	quoted := false
+	for _, b := range sample {
+		switch b {
+		case '"':
+			quoted = !quoted
+		case ',', ';', '\t':
+			if !quoted {
+				counts[rune(b)]++
+			}
+		}
+	}
+
+	delimiter := ','
+	for _, candidate := range []rune{';', '\t'} {
+		if counts[candidate] > counts[delimiter] {
+			delimiter = candidate
+		}
+	}
+	return delimiter
+}
+
+func delimiterName(delimiter rune) string {
+	switch delimiter {
+	case ',':
+		return "comma"
+	case ';':
+		return "semicolon"
+	case '\t':
+		return "tab"
+	default:
+		return ""
+	}
+}
+
+// parseAmount reads a decimal amount, grouped in thousands or not. Inf, NaN
+// and numbers too large for a float64 are errAmountNotFinite; anything else
+// that is not a decimal, hex floats included, is errAmountNotNumeric.
+func parseAmount(value string) (float64, error) {
+	if thousands.MatchString(value) {
+		value = strings.ReplaceAll(value, ",", "")
+	}
+	amount, err := strconv.ParseFloat(value, 64)
+	if math.IsInf(amount, 0) || math.IsNaN(amount) {
+		return 0, errAmountNotFinite
+	}
+	if err != nil || !decimal.MatchString(value) {
+		return 0, errAmountNotNumeric
+	}
+	return amount, nil
+}
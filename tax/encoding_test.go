@@ -0,0 +1,166 @@
+// go:build unit
+
+package tax
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/encoding/charmap"
+)
+
+func windows874(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := charmap.Windows874.NewEncoder().Bytes([]byte(s))
+	assert.NoError(t, err)
+	return b
+}
+
+func TestProcessTaxFileEncodings(t *testing.T) {
+	tests := []struct {
+		name    string
+		content []byte
+		options fileOptions
+		want    []TaxResponseCSV
+		errors  []RowError
+	}{
+		{
+			name:    "given utf-8 file with bom should strip it from the header",
+			content: []byte("\xef\xbb\xbftotalIncome,wht\n500000,0\n"),
+			want:    []TaxResponseCSV{{TotalIncome: 500000}},
+		},
+		{
+			name:    "given semicolon delimited file should detect the delimiter",
+			content: []byte("totalIncome;wht;donation\n\"1,500,000.00\";\"15,000\";0\n"),
+			want:    []TaxResponseCSV{{TotalIncome: 1500000}},
+		},
+		{
+			name:    "given tab delimited file should detect the delimiter",
+			content: []byte("totalIncome\twht\n1,500,000.00\t0\n"),
+			want:    []TaxResponseCSV{{TotalIncome: 1500000}},
+		},
+		{
+			name:    "given delimiter option should use it",
+			content: []byte("totalIncome;wht\n500000;0\n"),
+			options: fileOptions{delimiter: ';'},
+			want:    []TaxResponseCSV{{TotalIncome: 500000}},
+		},
+		{
+			name:    "given windows-874 file should decode thai text",
+			content: windows874(t, "totalIncome,wht\n500000,ไม่มี\n"),
+			errors:  []RowError{{Line: 2, Column: "wht", Code: CodeInvalidType, Message: "wht must be a numeric value", Text: newText("%s must be a numeric value", "wht")}},
+		},
+		{
+			name:    "given badly grouped number should reject it",
+			content: []byte("totalIncome,wht\n\"1,50,000\",0\n"),
+			errors:  []RowError{{Line: 2, Column: "totalIncome", Code: CodeInvalidType, Message: "totalIncome must be a numeric value", Text: newText("%s must be a numeric value", "totalIncome")}},
+		},
+		{
+			name:    "given inf, nan or a number too large should reject them as not finite",
+			content: []byte("totalIncome,wht\nInf,0\n500000,NaN\n1e400,0\n"),
+			errors: []RowError{
+				{Line: 2, Column: "totalIncome", Code: CodeNumberNotFinite, Message: "totalIncome must be a finite number", Text: newText("%s must be a finite number", "totalIncome")},
+				{Line: 3, Column: "wht", Code: CodeNumberNotFinite, Message: "wht must be a finite number", Text: newText("%s must be a finite number", "wht")},
+				{Line: 4, Column: "totalIncome", Code: CodeNumberNotFinite, Message: "totalIncome must be a finite number", Text: newText("%s must be a finite number", "totalIncome")},
+			},
+		},
+		{
+			name:    "given hex float should reject it",
+			content: []byte("totalIncome,wht\n0x1p19,0\n"),
+			errors:  []RowError{{Line: 2, Column: "totalIncome", Code: CodeInvalidType, Message: "totalIncome must be a numeric value", Text: newText("%s must be a numeric value", "totalIncome")}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(&StubTax{})
+			tt.options.mode = ModeLenient
+
+			sink := &collectSink{}
+			_, err := p.processTaxFile(context.Background(), bytesFile(tt.content), tt.options, sink)
+
+			assert.Equal(t, "", err.Message)
+			assert.Equal(t, tt.want, sink.taxes)
+			assert.Equal(t, tt.errors, sink.errors)
+		})
+	}
+}
+
+func TestCSVInput(t *testing.T) {
+	t.Run("given windows-874 bytes should decode to utf-8", func(t *testing.T) {
+		input, delimiter := csvInput(bufio.NewReader(bytes.NewReader(windows874(t, "taxpayer;ชื่อ\n"))), fileOptions{})
+
+		got, err := io.ReadAll(input)
+		assert.NoError(t, err)
+		assert.Equal(t, "taxpayer;ชื่อ\n", string(got))
+		assert.Equal(t, ';', delimiter)
+	})
+	t.Run("given encoding option should not detect it", func(t *testing.T) {
+		input, _ := csvInput(bufio.NewReader(strings.NewReader("ชื่อ")), fileOptions{encoding: EncodingTIS620})
+
+		got, err := io.ReadAll(input)
+		assert.NoError(t, err)
+		assert.NotEqual(t, "ชื่อ", string(got))
+	})
+}
+
+func TestDetectEncoding(t *testing.T) {
+	assert.Equal(t, EncodingUTF8, detectEncoding([]byte("ภาษีเงินได้")))
+	assert.Equal(t, EncodingUTF8, detectEncoding([]byte("ภาษี")[:5]))
+	assert.Equal(t, EncodingWindows874, detectEncoding(windows874(t, "ภาษีเงินได้")))
+}
+
+func TestDetectDelimiter(t *testing.T) {
+	assert.Equal(t, ',', detectDelimiter([]byte("totalIncome,wht\n1;2;3;4")))
+	assert.Equal(t, ';', detectDelimiter([]byte(`"a,b";wht;donation`)))
+	assert.Equal(t, '\t', detectDelimiter([]byte("totalIncome\twht")))
+	assert.Equal(t, ',', detectDelimiter([]byte("totalIncome")))
+}
+
+func TestFileOptionsParam(t *testing.T) {
+	tests := []struct {
+		query string
+		want  fileOptions
+		err   string
+	}{
+		{query: "", want: fileOptions{mode: ModeStrict}},
+		{query: "encoding=TIS-620&delimiter=tab&sheet=Payroll", want: fileOptions{mode: ModeStrict, sheet: "Payroll", encoding: EncodingTIS620, delimiter: '\t'}},
+		{query: "delimiter=%3B", want: fileOptions{mode: ModeStrict, delimiter: ';'}},
+		{query: "encoding=latin-1", err: "encoding must be utf-8, tis-620 or windows-874"},
+		{query: "delimiter=pipe", err: "delimiter must be comma, semicolon or tab"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			e := echo.New()
+			c := e.NewContext(httptest.NewRequest(http.MethodPost, "/tax/calculations/upload-csv?"+tt.query, nil), httptest.NewRecorder())
+
+			got, err := fileOptionsParam(c)
+
+			assert.Equal(t, tt.err, err.Message)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCalculateTaxCSVHandlerWindows874(t *testing.T) {
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(uploadRequest("/tax/calculations/upload-csv?encoding=windows-874", string(windows874(t, "totalIncome;wht\n\"1,500,000.00\";0\n"))), rec)
+
+	p := New(&StubTax{})
+
+	err := serve(c, p.CalculateTaxCSVHandler)
+
+	assert.NoError(t, err, "expected no error but got %v", err)
+	assert.Equal(t, http.StatusOK, rec.Code, "expected status code %d but got %d", http.StatusOK, rec.Code)
+	assert.Equal(t, `{"taxes":[{"totalIncome":1500000,"tax":0}],"summary":{"rows":1,"succeeded":1,"failed":0,"totalIncome":1500000,"totalTax":0,"totalRefund":0,"brackets":[],"averageEffectiveRate":0,"tax":{"min":0,"max":0,"p50":0,"p90":0,"p99":0}}}`, strings.TrimSuffix(rec.Body.String(), "\n"))
+}
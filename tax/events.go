@@ -0,0 +1,201 @@
+package tax
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const MIMETextEventStream = "text/event-stream"
+
+// eventInterval is how often a progress event is sent while a job runs.
+var eventInterval = 500 * time.Millisecond
+
+type JobProgress struct {
+	JobID         string  `json:"jobId"`
+	Status        string  `json:"status"`
+	Processed     int     `json:"processed"`
+	Succeeded     int     `json:"succeeded"`
+	Failed        int     `json:"failed"`
+	RowsPerSecond float64 `json:"rowsPerSecond"`
+}
+
+type JobFinished struct {
+	JobID     string        `json:"jobId"`
+	Status    string        `json:"status"`
+	Processed int           `json:"processed"`
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	Message   string        `json:"message,omitempty"`
+	Summary   *BatchSummary `json:"summary,omitempty"`
+	text      Text
+}
+
+// batchProgress is shared between a running job and its event streams. The
+// row loop only bumps counters; streams sample them on their own clock.
+type batchProgress struct {
+	started   atomic.Bool
+	processed atomic.Int64
+	failed    atomic.Int64
+	done      chan struct{}
+	finished  JobFinished
+	lost      bool
+}
+
+func (p *batchProgress) row(failed bool) {
+	if failed {
+		p.failed.Add(1)
+	}
+	p.processed.Add(1)
+}
+
+// finish publishes the last event. It is written before done is closed so
+// readers that see done closed also see it.
+func (p *batchProgress) finish(job Job, summary *BatchSummary) {
+	p.finished = JobFinished{
+		JobID:     job.ID,
+		Status:    job.Status,
+		Processed: job.Processed,
+		Succeeded: job.Succeeded,
+		Failed:    job.Failed,
+		Message:   job.Message,
+		Summary:   summary,
+		text:      job.MessageText,
+	}
+	close(p.done)
+}
+
+// abandon ends the streams of a run that lost its claim without a finished
+// event of its own.
+func (p *batchProgress) abandon() {
+	p.lost = true
+	close(p.done)
+}
+
+// trackJob registers a job before it starts so a stream opened right after
+// the upload finds it.
+func (h *Handler) trackJob(id string) *batchProgress {
+	progress, _ := h.running.LoadOrStore(id, &batchProgress{done: make(chan struct{})})
+	return progress.(*batchProgress)
+}
+
+// JobEventsHandler streams server-sent events for a job: a progress event
+// every eventInterval and a finished event carrying the batch summary.
+func (h *Handler) JobEventsHandler(c echo.Context) error {
+	job, status, errJob := h.findJob(c)
+	if errJob.Message != "" {
+		return problem(status, errJob)
+	}
+
+	lang := languageParam(c)
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, MIMETextEventStream)
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.WriteHeader(http.StatusOK)
+
+	value, ok := h.running.Load(job.ID)
+	if !ok {
+		return h.writeStoredJobEvent(res, lang, job)
+	}
+	progress := value.(*batchProgress)
+
+	ticker := time.NewTicker(eventInterval)
+	defer ticker.Stop()
+	var last int64
+	var rate float64
+	for {
+		if err := writeEvent(res, lang, "progress", progress.snapshot(job.ID, rate)); err != nil {
+			return err
+		}
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-progress.done:
+			if progress.lost {
+				return h.writeLatestJobEvent(res, lang, job.ID)
+			}
+			return writeEvent(res, lang, "finished", progress.finished)
+		case <-ticker.C:
+			processed := progress.processed.Load()
+			rate = float64(processed-last) / eventInterval.Seconds()
+			last = processed
+		}
+	}
+}
+
+// snapshot reads the counters. rate is the throughput over the last interval.
+func (p *batchProgress) snapshot(id string, rate float64) JobProgress {
+	processed := p.processed.Load()
+	failed := p.failed.Load()
+	event := JobProgress{
+		JobID:         id,
+		Status:        JobStatusQueued,
+		Processed:     int(processed),
+		Succeeded:     int(processed - failed),
+		Failed:        int(failed),
+		RowsPerSecond: rate,
+	}
+	if p.started.Load() {
+		event.Status = JobStatusRunning
+	}
+	return event
+}
+
+// writeLatestJobEvent reads the job again after this process stopped running
+// it and sends what the run that took it over has saved.
+func (h *Handler) writeLatestJobEvent(res *echo.Response, lang string, id string) error {
+	job, err := h.jobs.GetJob(id)
+	if err != nil {
+		return err
+	}
+	return h.writeStoredJobEvent(res, lang, job)
+}
+
+// writeStoredJobEvent answers for a job this process is not running: the
+// finished event of a completed or failed job, or its last saved progress.
+func (h *Handler) writeStoredJobEvent(res *echo.Response, lang string, job Job) error {
+	if job.Status != JobStatusCompleted && job.Status != JobStatusFailed {
+		return writeEvent(res, lang, "progress", JobProgress{
+			JobID:     job.ID,
+			Status:    job.Status,
+			Processed: job.Processed,
+			Succeeded: job.Succeeded,
+			Failed:    job.Failed,
+		})
+	}
+
+	finished := JobFinished{
+		JobID:     job.ID,
+		Status:    job.Status,
+		Processed: job.Processed,
+		Succeeded: job.Succeeded,
+		Failed:    job.Failed,
+		Message:   job.Message,
+		text:      job.MessageText,
+	}
+	if job.Status == JobStatusCompleted {
+		if result, err := h.jobs.GetJobResult(job.ID); err == nil {
+			var batch batchResult
+			if err := json.Unmarshal(result, &batch); err == nil {
+				finished.Summary = batch.Summary
+			}
+		}
+	}
+	return writeEvent(res, lang, "finished", finished)
+}
+
+func writeEvent(res *echo.Response, lang string, name string, data interface{}) error {
+	b, err := marshalLocalized(lang, data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(res, "event: %s\ndata: %s\n\n", name, b); err != nil {
+		return err
+	}
+	res.Flush()
+	return nil
+}
@@ -0,0 +1,74 @@
+// go:build unit
+
+package tax
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func jobEventsRequest(p *Handler, id string) *httptest.ResponseRecorder {
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+	c.SetParamNames("jobId")
+	c.SetParamValues(id)
+	serve(c, p.JobEventsHandler)
+	return rec
+}
+
+func TestJobEventsHandler(t *testing.T) {
+	t.Run("given a running job should stream progress until the finished event", func(t *testing.T) {
+		interval := eventInterval
+		eventInterval = 5 * time.Millisecond
+		defer func() { eventInterval = interval }()
+
+		jobs := &StubJobs{}
+		p := New(&StubTax{}).WithJobs(jobs)
+		job, _ := jobs.CreateJob(Job{Status: JobStatusRunning, Mode: ModeLenient}, strings.NewReader(""))
+		progress := p.trackJob(job.ID)
+		progress.started.Store(true)
+		go func() {
+			progress.row(false)
+			progress.row(false)
+			progress.row(true)
+			time.Sleep(30 * time.Millisecond)
+			job.Status, job.Processed, job.Succeeded, job.Failed = JobStatusCompleted, 3, 2, 1
+			progress.finish(job, &BatchSummary{Rows: 3, Succeeded: 2, Failed: 1, Brackets: []BracketCount{}})
+		}()
+
+		rec := jobEventsRequest(p, stubJobID(1))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, MIMETextEventStream, rec.Header().Get(echo.HeaderContentType))
+		body := rec.Body.String()
+		assert.Contains(t, body, "event: progress\ndata: {\"jobId\":\""+stubJobID(1)+"\",\"status\":\"running\",\"processed\":3,\"succeeded\":2,\"failed\":1,")
+		assert.True(t, strings.HasSuffix(body, "event: finished\ndata: {\"jobId\":\""+stubJobID(1)+"\",\"status\":\"completed\",\"processed\":3,\"succeeded\":2,\"failed\":1,"+
+			"\"summary\":{\"rows\":3,\"succeeded\":2,\"failed\":1,\"totalIncome\":0,\"totalTax\":0,\"totalRefund\":0,\"brackets\":[],\"averageEffectiveRate\":0,\"tax\":{\"min\":0,\"max\":0,\"p50\":0,\"p90\":0,\"p99\":0}}}\n\n"), body)
+	})
+	t.Run("given a finished job should send its summary at once", func(t *testing.T) {
+		jobs := &StubJobs{}
+		p := New(&StubTax{}).WithJobs(jobs)
+		job, _ := jobs.CreateJob(Job{Status: JobStatusQueued, Mode: ModeLenient}, strings.NewReader("totalIncome,wht\n1000,0\ninvalid,0\n"))
+		p.runJob(job)
+
+		rec := jobEventsRequest(p, stubJobID(1))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.True(t, strings.HasPrefix(rec.Body.String(), "event: finished\ndata: {\"jobId\":\""+stubJobID(1)+"\",\"status\":\"completed\",\"processed\":2,\"succeeded\":1,\"failed\":1,\"summary\":{\"rows\":2,"), rec.Body.String())
+		assert.Equal(t, 1, strings.Count(rec.Body.String(), "event: "))
+	})
+	t.Run("given an unknown job should return status 404", func(t *testing.T) {
+		p := New(&StubTax{}).WithJobs(&StubJobs{})
+
+		rec := jobEventsRequest(p, stubJobID(9))
+
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
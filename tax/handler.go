@@ -1,55 +1,249 @@
 package tax
 
 import (
+	"errors"
+	"io"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/labstack/echo/v4"
 )
 
 type Handler struct {
-	store Storer
+	store        Storer
+	history      HistoryStorer
+	taxpayers    TaxpayerStorer
+	refunds      RefundStorer
+	ledger       LedgerStorer
+	certificates CertificateStorer
+	jobs         JobStorer
+	idempotency  IdempotencyStorer
+	jobSlots     chan struct{}
+	running      sync.Map
+	workers      int
+	batchLimit   int
+	entryLimit   int64
+	archiveLimit int64
 }
 
 type Storer interface {
 	CalculateTax(userInfo UserInfo) (Tax, error)
+	CalculateTaxWithSettings(userInfo UserInfo, settings Settings) (Tax, error)
+	Settings() (Settings, error)
 	SettingPersonalDeduction(setting Setting) (float64, error)
 	SettingMaxKReceipt(setting Setting) (float64, error)
 }
 
+type HistoryStorer interface {
+	SaveCalculation(calculation Calculation) (Calculation, error)
+	ListCalculations(filter CalculationFilter) ([]Calculation, int, error)
+	GetCalculation(taxpayerID string, id int64) (Calculation, error)
+}
+
+type TaxpayerStorer interface {
+	CreateTaxpayer(taxpayer Taxpayer) (Taxpayer, error)
+	GetTaxpayer(id string) (Taxpayer, error)
+	UpdateTaxpayer(taxpayer Taxpayer) (Taxpayer, error)
+}
+
+type RefundStorer interface {
+	CreateRefundClaim(claim RefundClaim) (RefundClaim, error)
+	GetRefundClaim(id int64) (RefundClaim, error)
+	TransitionRefundClaim(id int64, transition RefundTransition) (RefundClaim, error)
+}
+
+type LedgerStorer interface {
+	GetInstalmentPlan(calculationID int64) (int, error)
+	SaveInstalmentPlan(calculationID int64, instalments int) error
+	// RecordPayment stores payment unless the payments of its calculation
+	// would add up to more than taxDue, in which case it returns ErrConflict.
+	RecordPayment(payment Payment, taxDue float64) (Payment, error)
+	ListPayments(calculationID int64) ([]Payment, error)
+}
+
+type CertificateStorer interface {
+	ImportCertificates(certificates []WHTCertificate) (imported []WHTCertificate, duplicates []WHTCertificate, err error)
+	ListCertificates(taxpayerID string, taxYear int) ([]WHTCertificate, error)
+}
+
+type JobStorer interface {
+	// CreateJob saves a queued job with its input, which is read in chunks
+	// so an upload is never held in memory whole.
+	CreateJob(job Job, input io.Reader) (Job, error)
+	GetJob(id string) (Job, error)
+	// ClaimJob marks a queued job, or a running one not updated for longer
+	// than lease, as running under a new Claim and drops the rows of any
+	// earlier run. It returns ErrConflict when the job is finished or held by
+	// a live run.
+	ClaimJob(id string, lease time.Duration) (Job, error)
+	// TouchJob keeps claim on a running job alive. It, UpdateJob,
+	// AppendJobRows and CompleteJob return ErrConflict once another run has
+	// taken the job over, so a run that lost its claim writes nothing more.
+	TouchJob(id, claim string) error
+	OpenJobInput(id string) (io.ReadCloser, error)
+	UpdateJob(job Job) error
+	// AppendJobRows saves output rows, each a JSON document, numbered on
+	// from first.
+	AppendJobRows(id, claim string, first int, rows [][]byte) error
+	// ListJobRows returns up to limit rows numbered after after, in order.
+	ListJobRows(id string, after int, limit int) ([][]byte, error)
+	// ListJobErrors returns up to limit row errors of a job, in row order,
+	// skipping the first offset.
+	ListJobErrors(id string, offset int, limit int) ([]RowError, error)
+	CompleteJob(job Job, result []byte) error
+	GetJobResult(id string) ([]byte, error)
+	ListUnfinishedJobs() ([]Job, error)
+}
+
+type IdempotencyStorer interface {
+	// ReserveIdempotencyKey claims key within scope for a request. A
+	// reservation older than lease and a response older than ttl no longer
+	// hold the key. When the key is taken it returns the stored request with
+	// ErrAlreadyExists.
+	ReserveIdempotencyKey(scope, key, hash string, lease, ttl time.Duration) (IdempotentResponse, error)
+	CompleteIdempotencyKey(response IdempotentResponse) error
+	ReleaseIdempotencyKey(scope, key string) error
+}
+
+var (
+	ErrNotFound      = errors.New("not found")
+	ErrAlreadyExists = errors.New("already exists")
+	ErrConflict      = errors.New("conflict")
+)
+
 func New(db Storer) *Handler {
 	return &Handler{store: db}
 }
 
+func (h *Handler) WithHistory(history HistoryStorer) *Handler {
+	h.history = history
+	return h
+}
+
+func (h *Handler) WithTaxpayers(taxpayers TaxpayerStorer) *Handler {
+	h.taxpayers = taxpayers
+	return h
+}
+
+func (h *Handler) WithRefunds(refunds RefundStorer) *Handler {
+	h.refunds = refunds
+	return h
+}
+
+func (h *Handler) WithLedger(ledger LedgerStorer) *Handler {
+	h.ledger = ledger
+	return h
+}
+
+func (h *Handler) WithCertificates(certificates CertificateStorer) *Handler {
+	h.certificates = certificates
+	return h
+}
+
+func (h *Handler) WithJobs(jobs JobStorer) *Handler {
+	h.jobs = jobs
+	h.jobSlots = make(chan struct{}, maxRunningJobs)
+	return h
+}
+
+func (h *Handler) WithIdempotency(idempotency IdempotencyStorer) *Handler {
+	h.idempotency = idempotency
+	return h
+}
+
+// WithWorkers sets how many rows of an uploaded file are calculated at once.
+// It defaults to GOMAXPROCS.
+func (h *Handler) WithWorkers(workers int) *Handler {
+	h.workers = workers
+	return h
+}
+
+// WithBatchLimit sets the largest batch POST /tax/calculations/batch accepts.
+// It defaults to 1000 items.
+func (h *Handler) WithBatchLimit(limit int) *Handler {
+	h.batchLimit = limit
+	return h
+}
+
+// WithArchiveLimits sets how many bytes a file in an uploaded ZIP archive,
+// and all the archive files of one request together, may decompress to. They
+// default to 32 MiB and 256 MiB.
+func (h *Handler) WithArchiveLimits(entry, total int64) *Handler {
+	h.entryLimit = entry
+	h.archiveLimit = total
+	return h
+}
+
+// Err is a failure as the client sees it. cause is the error behind it, which
+// is logged and never sent.
 type Err struct {
-	Message string `json:"message"`
+	Message string       `json:"message"`
+	Errors  []FieldError `json:"errors,omitempty"`
+	text    Text
+	field   string
+	cause   error
+}
+
+// FieldError is one failed check of a request body. Path is the JSON path of
+// the field, e.g. allowances[2].amount.
+type FieldError struct {
+	Code    string      `json:"code"`
+	Path    string      `json:"path"`
+	Value   interface{} `json:"value"`
+	Message string      `json:"message"`
+	text    Text
 }
 
 func (h *Handler) CalculateTaxHandler(c echo.Context) error {
 	var userInfo UserInfo
-	if err := c.Bind(&userInfo); err != nil {
-		return c.JSON(http.StatusBadRequest, Err{Message: "invalid request body"})
+	if status, err := bindJSON(c, &userInfo); err.Message != "" {
+		return problem(status, err)
+	}
+	if err := h.validationPublicUserInfo(userInfo); err.Message != "" {
+		return problem(http.StatusBadRequest, err)
 	}
-	if err := h.validationUserInfo(userInfo); err.Message != "" {
-		return c.JSON(http.StatusBadRequest, err)
+
+	taxYear, errYear := taxYearParam(c)
+	if errYear.Message != "" {
+		return problem(http.StatusBadRequest, errYear)
+	}
+
+	calculation, status, errCalc := h.calculateUserInfo(userInfo, taxYear, nil)
+	if errCalc.Message != "" {
+		return problem(status, errCalc)
 	}
 
-	for _, allowance := range userInfo.Allowances {
-		if !h.isValidAllowanceType(allowance.AllowanceType) {
-			return c.JSON(http.StatusBadRequest, Err{Message: "invalid allowance type"})
+	return c.JSON(http.StatusOK, calculation.Tax)
+
+}
+
+// calculateUserInfo runs a request through the calculation. The caller has
+// validated it, once, in the form it is calculated in. With settings nil the
+// current settings are read for this request; a batch passes one snapshot for
+// every item. The calculation keeps the snapshot, so a setting changed
+// mid-batch never shows up against a calculation that did not use it. Saving
+// it is up to the caller.
+func (h *Handler) calculateUserInfo(userInfo UserInfo, taxYear int, settings *Settings) (Calculation, int, Err) {
+	if settings == nil {
+		current, err := h.store.Settings()
+		if err != nil {
+			return Calculation{}, http.StatusInternalServerError, Err{Message: "failed to get settings", cause: err}
 		}
+		settings = &current
 	}
 
-	tax, err := h.store.CalculateTax(userInfo)
+	tax, err := h.store.CalculateTaxWithSettings(userInfo, *settings)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, Err{Message: "failed to calculate tax"})
+		return Calculation{}, http.StatusInternalServerError, Err{Message: "failed to calculate tax", cause: err}
 	}
 
 	if tax.Tax < 0.0 {
 		refund(&tax)
 	}
 
-	return c.JSON(http.StatusOK, tax)
-
+	return Calculation{TaxpayerID: userInfo.TaxpayerID, TaxYear: taxYear, UserInfo: userInfo, Tax: tax, Settings: *settings}, http.StatusOK, Err{}
 }
 
 func refund(tax *Tax) {
@@ -59,17 +253,17 @@ func refund(tax *Tax) {
 
 func (h *Handler) SettingPersonalDeductionHandler(c echo.Context) error {
 	var setting Setting
-	if err := c.Bind(&setting); err != nil {
-		return c.JSON(http.StatusBadRequest, Err{Message: "invalid request body"})
+	if status, err := bindJSON(c, &setting); err.Message != "" {
+		return problem(status, err)
 	}
 
 	if err := h.validationPersonalDeductionSetting(setting); err.Message != "" {
-		return c.JSON(http.StatusBadRequest, err)
+		return problem(http.StatusBadRequest, err)
 	}
 
 	personalDeduction, err := h.store.SettingPersonalDeduction(setting)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, Err{Message: "failed to set personal deduction"})
+		return problem(http.StatusInternalServerError, Err{Message: "failed to set personal deduction", cause: err})
 	}
 
 	response := PersonalDeductionResponse{
@@ -81,17 +275,17 @@ func (h *Handler) SettingPersonalDeductionHandler(c echo.Context) error {
 
 func (h *Handler) SettingMaxKReceiptHandler(c echo.Context) error {
 	var setting Setting
-	if err := c.Bind(&setting); err != nil {
-		return c.JSON(http.StatusBadRequest, Err{Message: "invalid request body"})
+	if status, err := bindJSON(c, &setting); err.Message != "" {
+		return problem(status, err)
 	}
 
 	if err := h.validationMaxKReceiptSetting(setting); err.Message != "" {
-		return c.JSON(http.StatusBadRequest, err)
+		return problem(http.StatusBadRequest, err)
 	}
 
 	maxKReceipt, err := h.store.SettingMaxKReceipt(setting)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, Err{Message: "failed to set max k-receipt"})
+		return problem(http.StatusInternalServerError, Err{Message: "failed to set max k-receipt", cause: err})
 	}
 	response := KReceiptResponse{
 		KReceipt: maxKReceipt,
@@ -103,12 +297,99 @@ func (h *Handler) SettingMaxKReceiptHandler(c echo.Context) error {
 func (h *Handler) CalculateTaxCSVHandler(c echo.Context) error {
 	file, err := c.FormFile("taxFile")
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, Err{Message: "invalid file : key must be taxFile"})
+		return problem(http.StatusBadRequest, Err{Message: "invalid file : key must be taxFile"})
+	}
+
+	options, errOptions := fileOptionsParam(c)
+	if errOptions.Message != "" {
+		return problem(http.StatusBadRequest, errOptions)
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		return problem(http.StatusBadRequest, Err{Message: "invalid file : key must be taxFile"})
+	}
+	files, archived, errFiles := taxFiles(form.File["taxFile"], h.archiveLimits())
+	if errFiles.Message != "" {
+		return problem(http.StatusBadRequest, errFiles)
+	}
+	grouped := archived || len(files) > 1
+
+	if c.QueryParam("async") == "true" {
+		if grouped {
+			return problem(http.StatusBadRequest, Err{Message: "async mode accepts a single csv or xlsx file"})
+		}
+		return h.enqueueJob(c, file, options)
 	}
 
-	taxResponseCSV, errors := h.processTaxFile(&MultipartFileHeader{file})
-	if errors.Message != "" {
-		return c.JSON(http.StatusBadRequest, errors)
+	format, errFormat := formatParam(c)
+	if errFormat.Message != "" {
+		return problem(http.StatusBadRequest, errFormat)
 	}
-	return c.JSON(http.StatusOK, map[string]interface{}{"taxes": taxResponseCSV})
+
+	if grouped {
+		if format != FormatJSON {
+			return problem(http.StatusBadRequest, Err{Message: "multiple files can only be returned as json"})
+		}
+		return h.calculateTaxFiles(c, files, options)
+	}
+
+	sink := newCSVStreamSink(c, format)
+	rowErrors, errFile := h.processTaxFile(c.Request().Context(), &MultipartFileHeader{file}, options, sink)
+	if errFile.Message != "" {
+		if sink.started() {
+			return sink.fail(errFile, rowErrors)
+		}
+		return &Problem{Status: http.StatusBadRequest, Err: errFile, Rows: rowErrors}
+	}
+	return sink.close()
+}
+
+func modeParam(c echo.Context) (string, Err) {
+	switch mode := c.QueryParam("mode"); mode {
+	case "", ModeStrict:
+		return ModeStrict, Err{}
+	case ModeLenient:
+		return ModeLenient, Err{}
+	default:
+		return "", Err{Message: "mode must be strict or lenient"}
+	}
+}
+
+// splitList reads a comma separated parameter, dropping blank entries.
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func fileOptionsParam(c echo.Context) (fileOptions, Err) {
+	mode, errMode := modeParam(c)
+	if errMode.Message != "" {
+		return fileOptions{}, errMode
+	}
+	options := fileOptions{mode: mode, sheet: c.QueryParam("sheet")}
+
+	switch encoding := strings.ToLower(c.QueryParam("encoding")); encoding {
+	case "", EncodingUTF8, EncodingTIS620, EncodingWindows874:
+		options.encoding = encoding
+	default:
+		return fileOptions{}, Err{Message: "encoding must be utf-8, tis-620 or windows-874"}
+	}
+
+	if delimiter := c.QueryParam("delimiter"); delimiter != "" {
+		comma, ok := delimiters[strings.ToLower(delimiter)]
+		if !ok {
+			return fileOptions{}, Err{Message: "delimiter must be comma, semicolon or tab"}
+		}
+		options.delimiter = comma
+	}
+
+	options.passthrough = splitList(c.QueryParam("passthrough"))
+
+	return options, Err{}
 }
@@ -0,0 +1,168 @@
+package tax
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// CreateCalculationHandler calculates for the taxpayer in the path the same
+// way POST /tax/calculations does and answers with the saved calculation. It
+// is the only way a calculation reaches a taxpayer's history.
+func (h *Handler) CreateCalculationHandler(c echo.Context) error {
+	taxpayerID := c.Param("taxpayerId")
+	if !isValidTaxpayerID(taxpayerID) {
+		return problem(http.StatusBadRequest, Err{Message: "taxpayer id must be a valid 13-digit national id"})
+	}
+
+	taxYear, errYear := taxYearParam(c)
+	if errYear.Message != "" {
+		return problem(http.StatusBadRequest, errYear)
+	}
+
+	var userInfo UserInfo
+	if status, err := bindJSON(c, &userInfo); err.Message != "" {
+		return problem(status, err)
+	}
+	if userInfo.TaxpayerID != "" && userInfo.TaxpayerID != taxpayerID {
+		return problem(http.StatusBadRequest, Err{Message: "taxpayerId does not match taxpayer id in path"})
+	}
+	userInfo.TaxpayerID = taxpayerID
+
+	if err := h.checkTaxpayerProfile(taxpayerID); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return problem(http.StatusBadRequest, Err{Message: "taxpayer profile not found"})
+		}
+		return problem(http.StatusInternalServerError, Err{Message: "failed to get taxpayer", cause: err})
+	}
+
+	errWHT, err := h.applyCertificates(&userInfo, taxYear)
+	if err != nil {
+		return problem(http.StatusInternalServerError, Err{Message: "failed to get certificates", cause: err})
+	}
+	if errWHT.Message != "" {
+		return problem(http.StatusBadRequest, errWHT)
+	}
+	// Validated once the certificates have set the wht it is calculated with.
+	if err := h.validationUserInfo(userInfo); err.Message != "" {
+		return problem(http.StatusBadRequest, err)
+	}
+
+	calculation, status, errCalc := h.calculateUserInfo(userInfo, taxYear, nil)
+	if errCalc.Message != "" {
+		return problem(status, errCalc)
+	}
+
+	saved, err := h.history.SaveCalculation(calculation)
+	if err != nil {
+		return problem(http.StatusInternalServerError, Err{Message: "failed to save calculation", cause: err})
+	}
+
+	return c.JSON(http.StatusCreated, saved)
+}
+
+func (h *Handler) ListCalculationsHandler(c echo.Context) error {
+	taxpayerID := c.Param("taxpayerId")
+	if !isValidTaxpayerID(taxpayerID) {
+		return problem(http.StatusBadRequest, Err{Message: "taxpayer id must be a valid 13-digit national id"})
+	}
+
+	var taxYear int
+	if c.QueryParam("year") != "" {
+		year, errYear := taxYearParam(c)
+		if errYear.Message != "" {
+			return problem(http.StatusBadRequest, errYear)
+		}
+		taxYear = year
+	}
+
+	page, pageSize, errPage := pageParams(c)
+	if errPage.Message != "" {
+		return problem(http.StatusBadRequest, errPage)
+	}
+
+	calculations, total, err := h.history.ListCalculations(CalculationFilter{
+		TaxpayerID: taxpayerID,
+		TaxYear:    taxYear,
+		Page:       page,
+		PageSize:   pageSize,
+	})
+	if err != nil {
+		return problem(http.StatusInternalServerError, Err{Message: "failed to list calculations", cause: err})
+	}
+	if calculations == nil {
+		calculations = []Calculation{}
+	}
+
+	return c.JSON(http.StatusOK, CalculationPage{
+		Calculations: calculations,
+		Page:         page,
+		PageSize:     pageSize,
+		Total:        total,
+	})
+}
+
+func (h *Handler) GetCalculationHandler(c echo.Context) error {
+	taxpayerID := c.Param("taxpayerId")
+	if !isValidTaxpayerID(taxpayerID) {
+		return problem(http.StatusBadRequest, Err{Message: "taxpayer id must be a valid 13-digit national id"})
+	}
+
+	id, err := strconv.ParseInt(c.Param("calculationId"), 10, 64)
+	if err != nil || id <= 0 {
+		return problem(http.StatusBadRequest, Err{Message: "calculation id must be a positive integer"})
+	}
+
+	calculation, err := h.history.GetCalculation(taxpayerID, id)
+	if errors.Is(err, ErrNotFound) {
+		return problem(http.StatusNotFound, Err{Message: "calculation not found"})
+	}
+	if err != nil {
+		return problem(http.StatusInternalServerError, Err{Message: "failed to get calculation", cause: err})
+	}
+
+	return c.JSON(http.StatusOK, calculation)
+}
+
+func taxYearParam(c echo.Context) (int, Err) {
+	yearStr := c.QueryParam("year")
+	if yearStr == "" {
+		return DefaultTaxYear, Err{}
+	}
+
+	year, err := strconv.Atoi(yearStr)
+	if err != nil || year <= 0 {
+		return 0, Err{Message: "year must be a positive integer"}
+	}
+
+	return year, Err{}
+}
+
+func pageParams(c echo.Context) (int, int, Err) {
+	page, pageSize := 1, defaultPageSize
+
+	if pageStr := c.QueryParam("page"); pageStr != "" {
+		p, err := strconv.Atoi(pageStr)
+		if err != nil || p < 1 {
+			return 0, 0, Err{Message: "page must be a positive integer"}
+		}
+		page = p
+	}
+
+	if pageSizeStr := c.QueryParam("pageSize"); pageSizeStr != "" {
+		s, err := strconv.Atoi(pageSizeStr)
+		if err != nil || s < 1 || s > maxPageSize {
+			return 0, 0, Err{Message: "pageSize must be between 1 and 100"}
+		}
+		pageSize = s
+	}
+
+	return page, pageSize, Err{}
+}
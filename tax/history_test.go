@@ -0,0 +1,231 @@
+// go:build unit
+
+package tax
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+type StubHistory struct {
+	saved        Calculation
+	calculations []Calculation
+	total        int
+	filter       CalculationFilter
+	err          error
+}
+
+func (s *StubHistory) SaveCalculation(calculation Calculation) (Calculation, error) {
+	s.saved = calculation
+	calculation.ID = 1
+	return calculation, s.err
+}
+
+func (s *StubHistory) ListCalculations(filter CalculationFilter) ([]Calculation, int, error) {
+	s.filter = filter
+	return s.calculations, s.total, s.err
+}
+
+func (s *StubHistory) GetCalculation(taxpayerID string, id int64) (Calculation, error) {
+	if s.err != nil {
+		return Calculation{}, s.err
+	}
+	for _, calculation := range s.calculations {
+		if calculation.TaxpayerID == taxpayerID && calculation.ID == id {
+			return calculation, nil
+		}
+	}
+	return Calculation{}, ErrNotFound
+}
+
+func TestCreateCalculationHandler(t *testing.T) {
+	t.Run("given valid user info should store calculation and return status 201", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/taxpayers/1101700230708/calculations?year=2567", io.NopCloser(strings.NewReader(`{"totalIncome": 500000.0, "wht": 30000.0, "allowances": [{"allowanceType": "donation", "amount": 0.0}]}`)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/taxpayers/:taxpayerId/calculations")
+		c.SetParamNames("taxpayerId")
+		c.SetParamValues("1101700230708")
+
+		stubHistory := StubHistory{}
+		p := New(&StubTax{calculateTax: Tax{Tax: -1000.0}, settings: Settings{PersonalDeduction: 70000.0, KReceipt: 50000.0}}).WithHistory(&stubHistory)
+
+		err := serve(c, p.CreateCalculationHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusCreated, rec.Code, "expected status code %d but got %d", http.StatusCreated, rec.Code)
+		assert.Equal(t, "1101700230708", stubHistory.saved.TaxpayerID)
+		assert.Equal(t, 2567, stubHistory.saved.TaxYear)
+		assert.Equal(t, 500000.0, stubHistory.saved.UserInfo.TotalIncome)
+		assert.Equal(t, Tax{Tax: 0.0, TaxRefund: 1000.0}, stubHistory.saved.Tax)
+		assert.Equal(t, Settings{PersonalDeduction: 70000.0, KReceipt: 50000.0}, stubHistory.saved.Settings)
+	})
+	t.Run("given taxpayer without profile should return status 400 and not store calculation", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/taxpayers/1101700230708/calculations", io.NopCloser(strings.NewReader(`{"totalIncome": 500000.0}`)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("taxpayerId")
+		c.SetParamValues("1101700230708")
+
+		stubHistory := StubHistory{}
+		p := New(&StubTax{}).WithHistory(&stubHistory).WithTaxpayers(&StubTaxpayers{})
+
+		err := serve(c, p.CreateCalculationHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
+		assert.JSONEq(t, `{"type": "about:blank", "title": "Bad Request", "status": 400, "detail": "taxpayer profile not found", "instance": "/taxpayers/1101700230708/calculations", "message": "taxpayer profile not found"}`, rec.Body.String())
+		assert.Equal(t, Calculation{}, stubHistory.saved)
+	})
+	t.Run("given invalid user info should return status 400 and not store calculation", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/taxpayers/1101700230708/calculations", io.NopCloser(strings.NewReader(`{"wht": 0.0}`)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("taxpayerId")
+		c.SetParamValues("1101700230708")
+
+		stubHistory := StubHistory{}
+		p := New(&StubTax{}).WithHistory(&stubHistory)
+
+		err := serve(c, p.CreateCalculationHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
+		assert.JSONEq(t, `{"type": "about:blank", "title": "Bad Request", "status": 400, "detail": "total income is required", "instance": "/taxpayers/1101700230708/calculations", "message": "total income is required", "errors": [{"code": "TOTAL_INCOME_REQUIRED", "path": "totalIncome", "value": 0, "message": "total income is required"}]}`, rec.Body.String())
+		assert.Equal(t, Calculation{}, stubHistory.saved)
+	})
+	t.Run("given history store error should return status 500 and error message", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/taxpayers/1101700230708/calculations", io.NopCloser(strings.NewReader(`{"totalIncome": 500000.0}`)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("taxpayerId")
+		c.SetParamValues("1101700230708")
+
+		p := New(&StubTax{}).WithHistory(&StubHistory{err: errors.New("db down")})
+
+		err := serve(c, p.CreateCalculationHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusInternalServerError, rec.Code, "expected status code %d but got %d", http.StatusInternalServerError, rec.Code)
+		assert.JSONEq(t, `{"type": "about:blank", "title": "Internal Server Error", "status": 500, "detail": "failed to save calculation", "instance": "/taxpayers/1101700230708/calculations", "message": "failed to save calculation"}`, rec.Body.String())
+	})
+}
+
+func TestListCalculationsHandler(t *testing.T) {
+	t.Run("given taxpayer with calculations should return requested page", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodGet, "/taxpayers/1101700230708/calculations?year=2567&page=2&pageSize=1", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("taxpayerId")
+		c.SetParamValues("1101700230708")
+
+		stubHistory := StubHistory{
+			calculations: []Calculation{{ID: 2, TaxpayerID: "1101700230708", TaxYear: 2567}},
+			total:        2,
+		}
+		p := New(&StubTax{}).WithHistory(&stubHistory)
+
+		err := serve(c, p.ListCalculationsHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusOK, rec.Code, "expected status code %d but got %d", http.StatusOK, rec.Code)
+		assert.Equal(t, CalculationFilter{TaxpayerID: "1101700230708", TaxYear: 2567, Page: 2, PageSize: 1}, stubHistory.filter)
+
+		var got CalculationPage
+		err = json.Unmarshal(rec.Body.Bytes(), &got)
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, 2, got.Total)
+		assert.Len(t, got.Calculations, 1)
+	})
+	t.Run("given no year should list all years", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodGet, "/taxpayers/1101700230708/calculations", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("taxpayerId")
+		c.SetParamValues("1101700230708")
+
+		stubHistory := StubHistory{}
+		p := New(&StubTax{}).WithHistory(&stubHistory)
+
+		err := serve(c, p.ListCalculationsHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusOK, rec.Code, "expected status code %d but got %d", http.StatusOK, rec.Code)
+		assert.Equal(t, CalculationFilter{TaxpayerID: "1101700230708", Page: 1, PageSize: defaultPageSize}, stubHistory.filter)
+		assert.JSONEq(t, `{"calculations": [], "page": 1, "pageSize": 20, "total": 0}`, rec.Body.String())
+	})
+	t.Run("given invalid page size should return status 400 and error message", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodGet, "/taxpayers/1101700230708/calculations?pageSize=1000", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("taxpayerId")
+		c.SetParamValues("1101700230708")
+
+		p := New(&StubTax{}).WithHistory(&StubHistory{})
+
+		err := serve(c, p.ListCalculationsHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
+		assert.JSONEq(t, `{"type": "about:blank", "title": "Bad Request", "status": 400, "detail": "pageSize must be between 1 and 100", "instance": "/taxpayers/1101700230708/calculations", "message": "pageSize must be between 1 and 100"}`, rec.Body.String())
+	})
+}
+
+func TestGetCalculationHandler(t *testing.T) {
+	t.Run("given existing calculation should return status 200 and calculation", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodGet, "/taxpayers/1101700230708/calculations/7", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("taxpayerId", "calculationId")
+		c.SetParamValues("1101700230708", "7")
+
+		want := Calculation{ID: 7, TaxpayerID: "1101700230708", TaxYear: 2567, Tax: Tax{Tax: 29000.0}}
+		p := New(&StubTax{}).WithHistory(&StubHistory{calculations: []Calculation{want}})
+
+		err := serve(c, p.GetCalculationHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusOK, rec.Code, "expected status code %d but got %d", http.StatusOK, rec.Code)
+
+		var got Calculation
+		err = json.Unmarshal(rec.Body.Bytes(), &got)
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, want, got)
+	})
+	t.Run("given unknown calculation should return status 404 and error message", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodGet, "/taxpayers/1101700230708/calculations/7", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("taxpayerId", "calculationId")
+		c.SetParamValues("1101700230708", "7")
+
+		p := New(&StubTax{}).WithHistory(&StubHistory{})
+
+		err := serve(c, p.GetCalculationHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusNotFound, rec.Code, "expected status code %d but got %d", http.StatusNotFound, rec.Code)
+		assert.JSONEq(t, `{"type": "about:blank", "title": "Not Found", "status": 404, "detail": "calculation not found", "instance": "/taxpayers/1101700230708/calculations/7", "message": "calculation not found"}`, rec.Body.String())
+	})
+}
@@ -0,0 +1,480 @@
+package tax
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+const (
+	LangEnglish = "en"
+	LangThai    = "th"
+)
+
+var languages = language.NewMatcher([]language.Tag{language.English, language.Thai})
+
+// thaiMessages is the Thai catalog: the Thai text of every message, keyed by
+// the English template. The verbs are filled in as Text describes.
+var thaiMessages = map[string]string{
+	// HTTP status titles, which are also the messages of Echo's own errors.
+	"Bad Request":                    "คำขอไม่ถูกต้อง",
+	"Unauthorized":                   "ไม่ได้รับอนุญาต",
+	"Not Found":                      "ไม่พบข้อมูล",
+	"Method Not Allowed":             "ไม่รองรับเมธอดนี้",
+	"Conflict":                       "ข้อมูลขัดแย้งกัน",
+	"Request Entity Too Large":       "คำขอมีขนาดใหญ่เกินไป",
+	"Unsupported Media Type":         "ไม่รองรับชนิดของข้อมูล",
+	"Too Many Requests":              "มีคำขอมากเกินไป",
+	"Internal Server Error":          "เกิดข้อผิดพลาดภายในระบบ",
+	"Service Unavailable":            "ระบบไม่พร้อมให้บริการ",
+	"internal server error":          "เกิดข้อผิดพลาดภายในระบบ",
+	"failed to write error response": "ไม่สามารถเขียนคำตอบข้อผิดพลาดได้",
+
+	// Request bodies and parameters.
+	"invalid request body":                           "รูปแบบคำขอไม่ถูกต้อง",
+	"unexpected data after the request body":         "มีข้อมูลเกินมาหลังเนื้อหาคำขอ",
+	"request body is too large":                      "เนื้อหาคำขอมีขนาดใหญ่เกินไป",
+	"decode must be strict or lenient":               "decode ต้องเป็น strict หรือ lenient",
+	"unknown field %q":                               "ไม่รู้จักฟิลด์ %q",
+	"duplicate field %q":                             "ฟิลด์ %q ซ้ำกัน",
+	"%s must be an object":                           "%s ต้องเป็นออบเจ็กต์",
+	"%s must be an array":                            "%s ต้องเป็นอาร์เรย์",
+	"%s must be a string":                            "%s ต้องเป็นข้อความ",
+	"%s must be a boolean":                           "%s ต้องเป็น true หรือ false",
+	"%s must be a number":                            "%s ต้องเป็นตัวเลข",
+	"%s must be an integer":                          "%s ต้องเป็นจำนวนเต็ม",
+	"%s must be a finite number":                     "%s ต้องเป็นตัวเลขที่มีค่าจำกัด",
+	"content type must be %s":                        "ชนิดของข้อมูลต้องเป็น %s",
+	"mode must be strict or lenient":                 "mode ต้องเป็น strict หรือ lenient",
+	"format must be json, ndjson, csv or xlsx":       "format ต้องเป็น json, ndjson, csv หรือ xlsx",
+	"encoding must be utf-8, tis-620 or windows-874": "encoding ต้องเป็น utf-8, tis-620 หรือ windows-874",
+	"delimiter must be comma, semicolon or tab":      "delimiter ต้องเป็น comma, semicolon หรือ tab",
+	"year must be a positive integer":                "ปีต้องเป็นจำนวนเต็มบวก",
+	"page must be a positive integer":                "page ต้องเป็นจำนวนเต็มบวก",
+	"pageSize must be between 1 and 100":             "pageSize ต้องอยู่ระหว่าง 1 ถึง 100",
+	"batch must not have more than %d items":         "คำขอต้องมีไม่เกิน %d รายการ",
+	"line is too long":                               "บรรทัดยาวเกินไป",
+
+	// Tax calculation.
+	"invalid allowance type":                                "ประเภทค่าลดหย่อนไม่ถูกต้อง",
+	"taxpayerId must be a valid 13-digit national id":       "taxpayerId ต้องเป็นเลขประจำตัวประชาชน 13 หลักที่ถูกต้อง",
+	"total income is required":                              "กรุณาระบุเงินได้ทั้งหมด",
+	"total income must be greater than 0.0":                 "เงินได้ทั้งหมดต้องมากกว่า 0 บาท",
+	"wht must be greater than or equal to 0.0":              "ภาษีหัก ณ ที่จ่ายต้องไม่น้อยกว่า 0 บาท",
+	"wht must be less than or equal to total income":        "ภาษีหัก ณ ที่จ่ายต้องไม่เกินเงินได้ทั้งหมด",
+	"missing allowanceType key":                             "กรุณาระบุ allowanceType",
+	"k-receipt amount must be greater than or equal to 0.0": "ค่าลดหย่อน k-receipt ต้องไม่น้อยกว่า 0 บาท",
+	"allowance amount must be greater than or equal to 0.0": "ค่าลดหย่อนต้องไม่น้อยกว่า 0 บาท",
+	"user can not fill personal allowance":                  "ไม่สามารถระบุค่าลดหย่อนส่วนตัวได้",
+	"taxpayer profile not found":                            "ไม่พบข้อมูลผู้เสียภาษี",
+	"failed to get settings":                                "ไม่สามารถอ่านการตั้งค่าได้",
+	"failed to calculate tax":                               "ไม่สามารถคำนวณภาษีได้",
+	"failed to save calculation":                            "ไม่สามารถบันทึกผลการคำนวณได้",
+
+	// Settings.
+	"amount is required": "กรุณาระบุจำนวนเงิน",
+	"personal deduction amount must be greater than or equal to 10,000.0": "ค่าลดหย่อนส่วนตัวต้องไม่น้อยกว่า 10,000 บาท",
+	"personal deduction amount must be less than or equal to 100,000.0":   "ค่าลดหย่อนส่วนตัวต้องไม่เกิน 100,000 บาท",
+	"max k-receipt amount must be greater than 0.0":                       "ค่าลดหย่อน k-receipt สูงสุดต้องมากกว่า 0 บาท",
+	"max k-receipt amount must be less than or equal to 100,000.0":        "ค่าลดหย่อน k-receipt สูงสุดต้องไม่เกิน 100,000 บาท",
+	"failed to set personal deduction":                                    "ไม่สามารถตั้งค่าลดหย่อนส่วนตัวได้",
+	"failed to set max k-receipt":                                         "ไม่สามารถตั้งค่าลดหย่อน k-receipt สูงสุดได้",
+
+	// Taxpayers.
+	"taxpayer id must be a valid 13-digit national id": "เลขประจำตัวผู้เสียภาษีต้องเป็นเลขประจำตัวประชาชน 13 หลักที่ถูกต้อง",
+	"id must be a valid 13-digit national id":          "id ต้องเป็นเลขประจำตัวประชาชน 13 หลักที่ถูกต้อง",
+	"name is required":    "กรุณาระบุชื่อ",
+	"address is required": "กรุณาระบุที่อยู่",
+	"filingStatus must be one of single, married-joint or married-separate": "filingStatus ต้องเป็น single, married-joint หรือ married-separate",
+	"bankCode is required":                  "กรุณาระบุรหัสธนาคาร",
+	"accountName is required":               "กรุณาระบุชื่อบัญชี",
+	"accountNumber must be 10 to 15 digits": "เลขที่บัญชีต้องมี 10 ถึง 15 หลัก",
+	"taxpayer already exists":               "มีข้อมูลผู้เสียภาษีนี้อยู่แล้ว",
+	"taxpayer not found":                    "ไม่พบผู้เสียภาษี",
+	"failed to create taxpayer":             "ไม่สามารถสร้างข้อมูลผู้เสียภาษีได้",
+	"failed to get taxpayer":                "ไม่สามารถอ่านข้อมูลผู้เสียภาษีได้",
+	"failed to update taxpayer":             "ไม่สามารถแก้ไขข้อมูลผู้เสียภาษีได้",
+
+	// Withholding tax certificates.
+	"certificate %s: payerTaxId must be a valid 13-digit tax id":            "หนังสือรับรองที่ %s: payerTaxId ต้องเป็นเลขประจำตัวผู้เสียภาษี 13 หลักที่ถูกต้อง",
+	"certificate %s: incomeType must be one of 40(1) to 40(8)":              "หนังสือรับรองที่ %s: incomeType ต้องเป็น 40(1) ถึง 40(8)",
+	"certificate %s: amountPaid must be greater than 0.0":                   "หนังสือรับรองที่ %s: amountPaid ต้องมากกว่า 0 บาท",
+	"certificate %s: taxWithheld must be greater than or equal to 0.0":      "หนังสือรับรองที่ %s: taxWithheld ต้องไม่น้อยกว่า 0 บาท",
+	"certificate %s: taxWithheld must be less than or equal to amountPaid":  "หนังสือรับรองที่ %s: taxWithheld ต้องไม่เกิน amountPaid",
+	"certificate %s: date must be in YYYY-MM-DD format":                     "หนังสือรับรองที่ %s: วันที่ต้องอยู่ในรูปแบบ YYYY-MM-DD",
+	"certificate %s: date must be within tax year %s":                       "หนังสือรับรองที่ %s: วันที่ต้องอยู่ในปีภาษี %s",
+	"wht must be 0.0 or equal to the tax withheld on imported certificates": "ภาษีหัก ณ ที่จ่ายต้องเป็น 0 หรือเท่ากับภาษีที่หักไว้ในหนังสือรับรองที่นำเข้า",
+	"invalid file : key must be certificateFile":                            "ไฟล์ไม่ถูกต้อง: ต้องส่งไฟล์ในชื่อ certificateFile",
+	"no certificates to import":                                             "ไม่มีหนังสือรับรองให้นำเข้า",
+	"missing column %s":                                                     "ไม่พบคอลัมน์ %s",
+	"line %s: invalid format":                                               "บรรทัดที่ %s: รูปแบบไม่ถูกต้อง",
+	"line %s: amountPaid must be a numeric value":                           "บรรทัดที่ %s: amountPaid ต้องเป็นตัวเลข",
+	"line %s: taxWithheld must be a numeric value":                          "บรรทัดที่ %s: taxWithheld ต้องเป็นตัวเลข",
+	"failed to import certificates":                                         "ไม่สามารถนำเข้าหนังสือรับรองได้",
+	"failed to list certificates":                                           "ไม่สามารถอ่านรายการหนังสือรับรองได้",
+	"failed to get certificates":                                            "ไม่สามารถอ่านหนังสือรับรองได้",
+
+	// Uploaded files.
+	"invalid file : key must be taxFile":                             "ไฟล์ไม่ถูกต้อง: ต้องส่งไฟล์ในชื่อ taxFile",
+	"failed to open file":                                            "ไม่สามารถเปิดไฟล์ได้",
+	"error reading file":                                             "ไม่สามารถอ่านไฟล์ได้",
+	"error reading file: invalid format":                             "ไม่สามารถอ่านไฟล์ได้: รูปแบบไม่ถูกต้อง",
+	"error reading file: invalid xlsx":                               "ไม่สามารถอ่านไฟล์ได้: ไฟล์ xlsx ไม่ถูกต้อง",
+	"invalid file format":                                            "รูปแบบไฟล์ไม่ถูกต้อง",
+	"file must be csv or xlsx":                                       "ไฟล์ต้องเป็น csv หรือ xlsx",
+	"file is too large":                                              "ไฟล์มีขนาดใหญ่เกินไป",
+	"archive is too large":                                           "ไฟล์บีบอัดมีขนาดใหญ่เกินไป",
+	"too many files: at most 100 per request":                        "มีไฟล์มากเกินไป: ส่งได้ไม่เกิน 100 ไฟล์ต่อคำขอ",
+	"multiple files can only be returned as json":                    "ไฟล์หลายไฟล์ส่งคืนได้เฉพาะรูปแบบ json",
+	"request cancelled":                                              "คำขอถูกยกเลิก",
+	"failed to write result":                                         "ไม่สามารถเขียนผลลัพธ์ได้",
+	"sheet %q not found":                                             "ไม่พบชีต %q",
+	"column %q is not allowed: user can not fill personal allowance": "ไม่อนุญาตให้มีคอลัมน์ %q: ไม่สามารถระบุค่าลดหย่อนส่วนตัวได้",
+	"unknown column %q":                                              "ไม่รู้จักคอลัมน์ %q",
+	"duplicate column %q":                                            "คอลัมน์ %q ซ้ำกัน",
+	"missing column %q":                                              "ไม่พบคอลัมน์ %q",
+	"%s value can not be empty":                                      "%s ต้องไม่เป็นค่าว่าง",
+	"%s must be a numeric value":                                     "%s ต้องเป็นตัวเลข",
+	"wht is more than 30% of total income":                           "ภาษีหัก ณ ที่จ่ายเกิน 30% ของเงินได้ทั้งหมด",
+
+	// Jobs.
+	"async mode is not enabled":                    "ไม่ได้เปิดใช้งานโหมดประมวลผลเบื้องหลัง",
+	"async mode accepts a single csv or xlsx file": "โหมดประมวลผลเบื้องหลังรับไฟล์ csv หรือ xlsx ได้ครั้งละหนึ่งไฟล์",
+	"job id must be a uuid":                        "รหัสงานต้องเป็น UUID",
+	"job not found":                                "ไม่พบงาน",
+	"job is %s":                                    "งานมีสถานะ %s",
+	"failed to create job":                         "ไม่สามารถสร้างงานได้",
+	"failed to get job":                            "ไม่สามารถอ่านข้อมูลงานได้",
+	"failed to get job result":                     "ไม่สามารถอ่านผลลัพธ์ของงานได้",
+	"failed to list job errors":                    "ไม่สามารถอ่านข้อผิดพลาดของงานได้",
+
+	// Calculations are linked to a taxpayer only behind credentials.
+	"taxpayerId is only accepted by POST /taxpayers/:taxpayerId/calculations":                           "รับ taxpayerId เฉพาะที่ POST /taxpayers/:taxpayerId/calculations",
+	"column %q is not allowed: taxpayerId is only accepted by POST /taxpayers/:taxpayerId/calculations": "ไม่อนุญาตให้มีคอลัมน์ %q: รับ taxpayerId เฉพาะที่ POST /taxpayers/:taxpayerId/calculations",
+
+	// History, ledger and refunds.
+	"taxpayerId does not match taxpayer id in path":                             "taxpayerId ไม่ตรงกับเลขประจำตัวผู้เสียภาษีในพาธ",
+	"calculation id must be a positive integer":                                 "รหัสการคำนวณต้องเป็นจำนวนเต็มบวก",
+	"calculation not found":                                                     "ไม่พบผลการคำนวณ",
+	"failed to list calculations":                                               "ไม่สามารถอ่านรายการผลการคำนวณได้",
+	"failed to get calculation":                                                 "ไม่สามารถอ่านผลการคำนวณได้",
+	"calculation has no tax due":                                                "ผลการคำนวณไม่มีภาษีที่ต้องชำระ",
+	"instalments must be between 1 and 3":                                       "จำนวนงวดต้องอยู่ระหว่าง 1 ถึง 3",
+	"instalments are only available when tax due is 3,000.0 or more":            "ผ่อนชำระได้เมื่อภาษีที่ต้องชำระตั้งแต่ 3,000 บาทขึ้นไป",
+	"instalment plan can not change after a payment is recorded":                "ไม่สามารถเปลี่ยนแผนผ่อนชำระหลังจากบันทึกการชำระเงินแล้ว",
+	"payment amount must be greater than 0.0":                                   "จำนวนเงินที่ชำระต้องมากกว่า 0 บาท",
+	"payment exceeds outstanding balance":                                       "จำนวนเงินที่ชำระเกินยอดคงค้าง",
+	"failed to get ledger":                                                      "ไม่สามารถอ่านบัญชีภาษีได้",
+	"failed to save instalment plan":                                            "ไม่สามารถบันทึกแผนผ่อนชำระได้",
+	"failed to record payment":                                                  "ไม่สามารถบันทึกการชำระเงินได้",
+	"calculation has no tax refund":                                             "ผลการคำนวณไม่มีภาษีที่ขอคืนได้",
+	"refund claim id must be a positive integer":                                "รหัสคำขอคืนภาษีต้องเป็นจำนวนเต็มบวก",
+	"refund claim already exists for this calculation":                          "มีคำขอคืนภาษีสำหรับผลการคำนวณนี้อยู่แล้ว",
+	"refund claim not found":                                                    "ไม่พบคำขอคืนภาษี",
+	"status is required":                                                        "กรุณาระบุสถานะ",
+	"status must be one of submitted, under_review, approved, paid or rejected": "สถานะต้องเป็น submitted, under_review, approved, paid หรือ rejected",
+	"refund claim can not move from %s to %s":                                   "ไม่สามารถเปลี่ยนสถานะคำขอคืนภาษีจาก %s เป็น %s",
+	"refund claim was modified concurrently":                                    "คำขอคืนภาษีถูกแก้ไขพร้อมกันจากคำขออื่น",
+	"failed to create refund claim":                                             "ไม่สามารถสร้างคำขอคืนภาษีได้",
+	"failed to get refund claim":                                                "ไม่สามารถอ่านคำขอคืนภาษีได้",
+	"failed to update refund claim":                                             "ไม่สามารถแก้ไขคำขอคืนภาษีได้",
+
+	// Idempotency keys.
+	"idempotency keys are not supported":                         "ไม่รองรับ Idempotency-Key",
+	"idempotency key was used for a different request":           "Idempotency-Key นี้ถูกใช้กับคำขออื่นแล้ว",
+	"a request with this idempotency key is in progress":         "คำขอที่ใช้ Idempotency-Key นี้กำลังดำเนินการอยู่",
+	"the response to this idempotency key was too large to keep": "คำตอบของ Idempotency-Key นี้มีขนาดใหญ่เกินกว่าจะเก็บไว้ได้",
+	"failed to check idempotency key":                            "ไม่สามารถตรวจสอบ Idempotency-Key ได้",
+}
+
+// levelLabels names the tax levels the store reports, which are written the
+// Thai way, in each language.
+var levelLabels = map[string]map[string]string{
+	LangEnglish: {
+		"0-150,000":           "0-150,000",
+		"150,001-500,000":     "150,001-500,000",
+		"500,001-1,000,000":   "500,001-1,000,000",
+		"1,000,001-2,000,000": "1,000,001-2,000,000",
+		"2,000,001 ขึ้นไป":    "2,000,001 and above",
+	},
+	LangThai: {
+		"0-150,000":           "0-150,000 บาท",
+		"150,001-500,000":     "150,001-500,000 บาท",
+		"500,001-1,000,000":   "500,001-1,000,000 บาท",
+		"1,000,001-2,000,000": "1,000,001-2,000,000 บาท",
+		"2,000,001 ขึ้นไป":    "2,000,001 บาทขึ้นไป",
+	},
+}
+
+// Text is a message as its catalog key, which is the English template, and
+// the arguments its verbs are filled with. %s is filled with an argument as it
+// is, %q with the argument quoted and %d with a count, written with Thai digit
+// grouping in Thai.
+type Text struct {
+	Key  string   `json:"key"`
+	Args []string `json:"args,omitempty"`
+}
+
+var (
+	templateVerb = regexp.MustCompile(`%[qsd]`)
+	thaiPrinter  = message.NewPrinter(language.Thai)
+)
+
+func newText(key string, args ...interface{}) Text {
+	text := Text{Key: key}
+	for _, arg := range args {
+		text.Args = append(text.Args, fmt.Sprint(arg))
+	}
+	return text
+}
+
+// textOf is the Text message was rendered from. A message without arguments
+// carries no Text and is its own key.
+func textOf(message string, text Text) Text {
+	if text.Key == "" {
+		return Text{Key: message}
+	}
+	return text
+}
+
+func (t Text) String() string {
+	return t.render(LangEnglish)
+}
+
+// render writes t in lang. A key missing from lang's catalog is written in
+// English.
+func (t Text) render(lang string) string {
+	template := t.Key
+	if thai, ok := thaiMessages[t.Key]; ok && lang == LangThai {
+		template = thai
+	}
+	if len(t.Args) == 0 {
+		return template
+	}
+	i := 0
+	return templateVerb.ReplaceAllStringFunc(template, func(verb string) string {
+		if i >= len(t.Args) {
+			return verb
+		}
+		arg := t.Args[i]
+		i++
+		switch verb {
+		case "%q":
+			return strconv.Quote(arg)
+		case "%d":
+			if n, err := strconv.Atoi(arg); err == nil && lang == LangThai {
+				return thaiPrinter.Sprintf("%d", n)
+			}
+		}
+		return arg
+	})
+}
+
+// errorf is an Err whose message is the catalog entry key filled with args.
+func errorf(key string, args ...interface{}) Err {
+	text := newText(key, args...)
+	return Err{Message: text.String(), text: text}
+}
+
+// textError is an error whose message is a catalog entry, for code that
+// returns a plain error rather than an Err.
+type textError struct {
+	text Text
+}
+
+func newTextError(key string, args ...interface{}) error {
+	return &textError{text: newText(key, args...)}
+}
+
+func (e *textError) Error() string {
+	return e.text.String()
+}
+
+// errText is the Text of err's message, if it came from the catalog.
+func errText(err error) Text {
+	var textErr *textError
+	if errors.As(err, &textErr) {
+		return textErr.text
+	}
+	return Text{}
+}
+
+func levelLabel(lang, level string) string {
+	if label, ok := levelLabels[lang][level]; ok {
+		return label
+	}
+	return level
+}
+
+// localizer is a response value with text to write in the client's language.
+// localize is called on a copy of the value, before the values it holds are
+// localized in turn.
+type localizer interface {
+	localize(lang string)
+}
+
+func (e Err) messageIn(lang string) string {
+	return textOf(e.Message, e.text).render(lang)
+}
+
+func (e RowError) messageIn(lang string) string {
+	return textOf(e.Message, e.Text).render(lang)
+}
+
+func (e *Err) localize(lang string) {
+	e.Message = e.messageIn(lang)
+}
+
+func (e *FieldError) localize(lang string) {
+	e.Message = textOf(e.Message, e.text).render(lang)
+}
+
+func (e *RowError) localize(lang string) {
+	e.Message = e.messageIn(lang)
+}
+
+func (l *TaxLevel) localize(lang string) {
+	l.Level = levelLabel(lang, l.Level)
+}
+
+func (b *BracketCount) localize(lang string) {
+	b.Level = levelLabel(lang, b.Level)
+}
+
+func (p *ProblemDetails) localize(lang string) {
+	p.Title = Text{Key: p.Title}.render(lang)
+	p.Detail = textOf(p.Detail, p.text).render(lang)
+	p.Message = textOf(p.Message, p.text).render(lang)
+}
+
+func (j *Job) localize(lang string) {
+	j.Message = textOf(j.Message, j.MessageText).render(lang)
+}
+
+func (f *JobFinished) localize(lang string) {
+	f.Message = textOf(f.Message, f.text).render(lang)
+}
+
+// localized is a copy of v with every localizer in it written in lang. Only
+// exported fields are followed, the way encoding/json sees v, and maps are
+// left as they are since they only hold the client's own data, such as
+// passthrough columns.
+func localized(lang string, v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.New(v.Type().Elem())
+		out.Elem().Set(localized(lang, v.Elem()))
+		return out
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.New(v.Type()).Elem()
+		out.Set(localized(lang, v.Elem()))
+		return out
+	case reflect.Slice:
+		if v.IsNil() || !holdsText(v.Type().Elem()) {
+			return v
+		}
+		out := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			out.Index(i).Set(localized(lang, v.Index(i)))
+		}
+		return out
+	case reflect.Struct:
+		out := reflect.New(v.Type()).Elem()
+		out.Set(v)
+		if l, ok := out.Addr().Interface().(localizer); ok {
+			l.localize(lang)
+		}
+		for i := 0; i < out.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				out.Field(i).Set(localized(lang, out.Field(i)))
+			}
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// holdsText reports whether values of t may hold a localizer.
+func holdsText(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Struct:
+		return true
+	default:
+		return false
+	}
+}
+
+// marshalLocalized encodes v as JSON in lang.
+func marshalLocalized(lang string, v interface{}) ([]byte, error) {
+	if lang == "" || v == nil {
+		return json.Marshal(v)
+	}
+	return json.Marshal(localized(lang, reflect.ValueOf(v)).Interface())
+}
+
+// languageParam picks the response language from ?lang=, falling back to the
+// Accept-Language header. It is empty when the client asked for neither, and
+// responses then keep their original wording.
+func languageParam(c echo.Context) string {
+	accept := c.QueryParam("lang")
+	if accept == "" {
+		accept = c.Request().Header.Get("Accept-Language")
+	}
+	if accept == "" {
+		return ""
+	}
+	tags, _, err := language.ParseAcceptLanguage(accept)
+	if err != nil || len(tags) == 0 {
+		return ""
+	}
+	_, index, confidence := languages.Match(tags...)
+	if confidence == language.No {
+		return ""
+	}
+	if index == 1 {
+		return LangThai
+	}
+	return LangEnglish
+}
+
+// JSONSerializer writes c.JSON responses in the language the client asked
+// for. Set it as the Echo instance's JSONSerializer.
+type JSONSerializer struct {
+	echo.DefaultJSONSerializer
+}
+
+func (s JSONSerializer) Serialize(c echo.Context, i interface{}, indent string) error {
+	lang := languageParam(c)
+	if lang == "" {
+		return s.DefaultJSONSerializer.Serialize(c, i, indent)
+	}
+
+	b, err := marshalLocalized(lang, i)
+	if err != nil {
+		return err
+	}
+	if indent != "" {
+		indented := new(bytes.Buffer)
+		if err := json.Indent(indented, b, "", indent); err != nil {
+			return err
+		}
+		b = indented.Bytes()
+	}
+	_, err = c.Response().Write(append(b, '\n'))
+	return err
+}
@@ -0,0 +1,272 @@
+// go:build unit
+
+package tax
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLanguageParam(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		accept string
+		want   string
+	}{
+		{"given no language should keep the original wording", "/", "", ""},
+		{"given thai accept-language should pick thai", "/", "th-TH,th;q=0.9,en;q=0.8", LangThai},
+		{"given english accept-language should pick english", "/", "en-US,en;q=0.9", LangEnglish},
+		{"given lang parameter should override accept-language", "/?lang=th", "en-US", LangThai},
+		{"given unsupported language should keep the original wording", "/", "fr-FR", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept-Language", tt.accept)
+			}
+			c := echo.New().NewContext(req, httptest.NewRecorder())
+
+			assert.Equal(t, tt.want, languageParam(c))
+		})
+	}
+}
+
+func TestTextRender(t *testing.T) {
+	tests := []struct {
+		name string
+		text Text
+		lang string
+		want string
+	}{
+		{"given a message in thai should use the catalog", Text{Key: "total income is required"}, LangThai, "กรุณาระบุเงินได้ทั้งหมด"},
+		{"given a message in english should keep the key", Text{Key: "total income is required"}, LangEnglish, "total income is required"},
+		{"given no language should write english", Text{Key: "taxpayer already exists"}, "", "taxpayer already exists"},
+		{"given %q should quote the argument", newText("unknown column %q", "withholding"), LangThai, `ไม่รู้จักคอลัมน์ "withholding"`},
+		{"given %s should copy the argument", newText("%s must be a numeric value", "donation"), LangThai, "donation ต้องเป็นตัวเลข"},
+		{"given %d in thai should group digits", newText("batch must not have more than %d items", 1000), LangThai, "คำขอต้องมีไม่เกิน 1,000 รายการ"},
+		{"given %d in english should not group digits", newText("batch must not have more than %d items", 1000), LangEnglish, "batch must not have more than 1000 items"},
+		{"given a key missing from the catalog should write english", newText("no such message %q", "x"), LangThai, `no such message "x"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.text.render(tt.lang))
+		})
+	}
+}
+
+func TestErrorf(t *testing.T) {
+	err := errorf("sheet %q not found", "Taxes")
+
+	assert.Equal(t, `sheet "Taxes" not found`, err.Message)
+	assert.Equal(t, `ไม่พบชีต "Taxes"`, err.messageIn(LangThai))
+}
+
+func TestMarshalLocalized(t *testing.T) {
+	t.Run("given english should rename tax levels only", func(t *testing.T) {
+		got, err := marshalLocalized(LangEnglish, Tax{Tax: 1.5, TaxLevel: []TaxLevel{{Level: "1,000,001-2,000,000"}, {Level: "2,000,001 ขึ้นไป", Tax: 1.5}}})
+
+		assert.NoError(t, err)
+		assert.Equal(t, `{"tax":1.5,"taxLevel":[{"level":"1,000,001-2,000,000","tax":0},{"level":"2,000,001 and above","tax":1.5}]}`, string(got))
+	})
+	t.Run("given thai should translate messages but not client data", func(t *testing.T) {
+		var v validationErrors
+		v.add(CodeWHTExceedsIncome, "wht", 600000, "wht must be less than or equal to total income", "wht")
+		rowErr := RowError{Line: 2, Message: "total income is required", RowLabel: RowLabel{ID: "total income is required", Passthrough: map[string]string{"message": "total income is required"}}}
+
+		got, err := marshalLocalized(LangThai, struct {
+			Err  Err               `json:"err"`
+			Row  RowError          `json:"row"`
+			Note map[string]string `json:"note"`
+		}{v.err(), rowErr, map[string]string{"message": "total income is required"}})
+
+		assert.NoError(t, err)
+		assert.Equal(t, `{"err":{"message":"ภาษีหัก ณ ที่จ่ายต้องไม่เกินเงินได้ทั้งหมด","errors":[{"code":"WHT_EXCEEDS_INCOME","path":"wht","value":600000,"message":"ภาษีหัก ณ ที่จ่ายต้องไม่เกินเงินได้ทั้งหมด"}]},`+
+			`"row":{"line":2,"message":"กรุณาระบุเงินได้ทั้งหมด","id":"total income is required","passthrough":{"message":"total income is required"}},`+
+			`"note":{"message":"total income is required"}}`, string(got))
+	})
+	t.Run("given a value should leave it unchanged", func(t *testing.T) {
+		errs := []FieldError{{Code: CodeTotalIncomeRequired, Message: "total income is required"}}
+
+		_, err := marshalLocalized(LangThai, Err{Message: "total income is required", Errors: errs})
+
+		assert.NoError(t, err)
+		assert.Equal(t, "total income is required", errs[0].Message)
+	})
+}
+
+func TestLocalizedResponses(t *testing.T) {
+	t.Run("given thai accept-language should return validation errors in thai", func(t *testing.T) {
+		e := echo.New()
+		e.JSONSerializer = JSONSerializer{}
+		req := httptest.NewRequest(http.MethodPost, "/tax/calculations", strings.NewReader(`{"wht": 0.0}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("Accept-Language", "th")
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		err := serve(c, New(&StubTax{}).CalculateTaxHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
+		assert.Equal(t, `{"type":"about:blank","title":"คำขอไม่ถูกต้อง","status":400,"detail":"กรุณาระบุเงินได้ทั้งหมด","instance":"/tax/calculations","message":"กรุณาระบุเงินได้ทั้งหมด","errors":[{"code":"TOTAL_INCOME_REQUIRED","path":"totalIncome","value":0,"message":"กรุณาระบุเงินได้ทั้งหมด"}]}`, strings.TrimSuffix(rec.Body.String(), "\n"))
+	})
+	t.Run("given lang=en should return tax levels in english", func(t *testing.T) {
+		e := echo.New()
+		e.JSONSerializer = JSONSerializer{}
+		req := httptest.NewRequest(http.MethodPost, "/tax/calculations?lang=en", strings.NewReader(`{"totalIncome": 500000.0, "wht": 0.0}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		stubTax := &StubTax{calculateTax: Tax{Tax: 29000, TaxLevel: []TaxLevel{{Level: "150,001-500,000", Tax: 29000}, {Level: "2,000,001 ขึ้นไป", Tax: 0}}}}
+
+		err := serve(c, New(stubTax).CalculateTaxHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusOK, rec.Code, "expected status code %d but got %d", http.StatusOK, rec.Code)
+		assert.Equal(t, `{"tax":29000,"taxLevel":[{"level":"150,001-500,000","tax":29000},{"level":"2,000,001 and above","tax":0}]}`, strings.TrimSuffix(rec.Body.String(), "\n"))
+	})
+	t.Run("given csv output in thai should write row errors in thai", func(t *testing.T) {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		c := e.NewContext(uploadRequest("/tax/calculations/upload-csv?mode=lenient&format=csv&lang=th", "totalIncome,wht\nabc,0\n"), rec)
+
+		err := serve(c, New(&StubTax{}).CalculateTaxCSVHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusOK, rec.Code, "expected status code %d but got %d", http.StatusOK, rec.Code)
+		assert.Equal(t, "\ufefftotalIncome,wht,tax,taxRefund,error\nabc,0,,,totalIncome ต้องเป็นตัวเลข\n", rec.Body.String())
+	})
+	t.Run("given thai should leave passthrough columns as they are", func(t *testing.T) {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		c := e.NewContext(uploadRequest("/tax/calculations/upload-csv?mode=lenient&passthrough=message&lang=th", "totalIncome,wht,message\nabc,0,total income is required\n"), rec)
+
+		err := serve(c, New(&StubTax{}).CalculateTaxCSVHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusOK, rec.Code, "expected status code %d but got %d", http.StatusOK, rec.Code)
+		assert.Equal(t, `{"taxes":[],"errors":[{"line":2,"column":"totalIncome","code":"INVALID_TYPE","message":"totalIncome ต้องเป็นตัวเลข","passthrough":{"message":"total income is required"}}],"summary":{"rows":1,"succeeded":0,"failed":1,"totalIncome":0,"totalTax":0,"totalRefund":0,"brackets":[],"averageEffectiveRate":0,"tax":{"min":0,"max":0,"p50":0,"p90":0,"p99":0}}}`, strings.TrimSuffix(rec.Body.String(), "\n"))
+	})
+	t.Run("given thai should translate conflicts and tax levels", func(t *testing.T) {
+		e := echo.New()
+		e.JSONSerializer = JSONSerializer{}
+		req := httptest.NewRequest(http.MethodPost, "/tax/calculations?lang=th", strings.NewReader(`{"totalIncome": 500000.0, "wht": 0.0}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		stubTax := &StubTax{calculateTax: Tax{Tax: 29000, TaxLevel: []TaxLevel{{Level: "150,001-500,000", Tax: 29000}, {Level: "2,000,001 ขึ้นไป", Tax: 0}}}}
+
+		err := serve(c, New(stubTax).CalculateTaxHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, `{"tax":29000,"taxLevel":[{"level":"150,001-500,000 บาท","tax":29000},{"level":"2,000,001 บาทขึ้นไป","tax":0}]}`, strings.TrimSuffix(rec.Body.String(), "\n"))
+
+		rec = httptest.NewRecorder()
+		c = e.NewContext(httptest.NewRequest(http.MethodPost, "/taxpayers?lang=th", nil), rec)
+
+		ErrorHandler(problem(http.StatusConflict, Err{Message: "taxpayer already exists"}), c)
+
+		assert.Equal(t, `{"type":"about:blank","title":"ข้อมูลขัดแย้งกัน","status":409,"detail":"มีข้อมูลผู้เสียภาษีนี้อยู่แล้ว","instance":"/taxpayers","message":"มีข้อมูลผู้เสียภาษีนี้อยู่แล้ว"}`, strings.TrimSuffix(rec.Body.String(), "\n"))
+	})
+}
+
+// TestThaiCatalog checks that every message written in the package has Thai
+// text, and the catalog nothing else: the Message literals, the keys given to
+// errorf, newText, newTextError and addf, the messages given to
+// validationErrors.add, the kind messages and the status titles.
+func TestThaiCatalog(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	assert.NoError(t, err)
+
+	keys := map[string]bool{}
+	for _, key := range kindMessages {
+		keys[key] = true
+	}
+	for _, status := range []int{
+		http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound, http.StatusMethodNotAllowed,
+		http.StatusConflict, http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType,
+		http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusServiceUnavailable,
+	} {
+		keys[http.StatusText(status)] = true
+	}
+
+	fset := token.NewFileSet()
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, name, nil, 0)
+		assert.NoError(t, err)
+		ast.Inspect(file, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.KeyValueExpr:
+				if ident, ok := n.Key.(*ast.Ident); ok && ident.Name == "Message" {
+					addLiteral(keys, n.Value)
+				}
+			case *ast.CallExpr:
+				switch name := calledName(n.Fun); {
+				case (name == "errorf" || name == "newText" || name == "newTextError") && len(n.Args) > 0:
+					addLiteral(keys, n.Args[0])
+				case name == "addf" && len(n.Args) > 4:
+					addLiteral(keys, n.Args[4])
+				case name == "add" && len(n.Args) == 5:
+					addLiteral(keys, n.Args[3])
+				}
+			}
+			return true
+		})
+	}
+
+	var missing []string
+	for key := range keys {
+		if _, ok := thaiMessages[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	sort.Strings(missing)
+	assert.Empty(t, missing, "messages without thai text")
+
+	var unused []string
+	for key := range thaiMessages {
+		if !keys[key] {
+			unused = append(unused, key)
+		}
+	}
+	sort.Strings(unused)
+	assert.Empty(t, unused, "thai text for messages that are never written")
+}
+
+func calledName(fun ast.Expr) string {
+	switch fun := fun.(type) {
+	case *ast.Ident:
+		return fun.Name
+	case *ast.SelectorExpr:
+		return fun.Sel.Name
+	}
+	return ""
+}
+
+func addLiteral(keys map[string]bool, expr ast.Expr) {
+	lit, ok := expr.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return
+	}
+	if key, err := strconv.Unquote(lit.Value); err == nil && key != "" {
+		keys[key] = true
+	}
+}
@@ -0,0 +1,198 @@
+package tax
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const HeaderIdempotencyKey = "Idempotency-Key"
+
+var (
+	// idempotencyLease is how long a key stays reserved for a request that
+	// has not finished. Past it the request is taken to have died with its
+	// process and a retry may claim the key.
+	idempotencyLease = 10 * time.Minute
+	// idempotencyTTL is how long a response is replayed for its key.
+	idempotencyTTL = 24 * time.Hour
+	// idempotentResponseLimit is the largest response body kept for replay.
+	idempotentResponseLimit = 1 << 20
+)
+
+// idempotentHeaders are the response headers replayed with a stored body.
+var idempotentHeaders = []string{echo.HeaderContentType, echo.HeaderContentDisposition, echo.HeaderLocation}
+
+// Idempotent is route middleware. A request carrying an Idempotency-Key is
+// answered once per route; a retry with the same key and the same content
+// gets the stored response back, while the same key with other content is
+// refused.
+func (h *Handler) Idempotent(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		key := c.Request().Header.Get(HeaderIdempotencyKey)
+		if key == "" {
+			return next(c)
+		}
+		if h.idempotency == nil {
+			return problem(http.StatusBadRequest, Err{Message: "idempotency keys are not supported"})
+		}
+		scope := c.Request().Method + " " + c.Path()
+
+		hash, cleanup, err := requestHash(c)
+		defer cleanup()
+		if err != nil {
+			status, errBody := bodyError(err)
+			return problem(status, errBody)
+		}
+
+		stored, err := h.idempotency.ReserveIdempotencyKey(scope, key, hash, idempotencyLease, idempotencyTTL)
+		if errors.Is(err, ErrAlreadyExists) {
+			switch {
+			case stored.Hash != hash:
+				return problem(http.StatusConflict, Err{Message: "idempotency key was used for a different request"})
+			case stored.Status == 0:
+				return problem(http.StatusConflict, Err{Message: "a request with this idempotency key is in progress"})
+			case stored.Oversized:
+				return problem(http.StatusConflict, Err{Message: "the response to this idempotency key was too large to keep"})
+			}
+			for name, value := range stored.Header {
+				c.Response().Header().Set(name, value)
+			}
+			c.Response().Header().Set("Idempotent-Replayed", "true")
+			c.Response().WriteHeader(stored.Status)
+			_, err := c.Response().Write(stored.Body)
+			return err
+		}
+		if err != nil {
+			return problem(http.StatusInternalServerError, Err{Message: "failed to check idempotency key", cause: err})
+		}
+
+		// Unless a response is stored below, the key is given back, so a
+		// handler that panics does not leave it reserved.
+		completed := false
+		res := c.Response()
+		tee := &teeWriter{ResponseWriter: res.Writer}
+		res.Writer = tee
+		defer func() {
+			res.Writer = tee.ResponseWriter
+			if completed {
+				return
+			}
+			if err := h.idempotency.ReleaseIdempotencyKey(scope, key); err != nil {
+				log.Printf("idempotency key %q: %v", key, err)
+			}
+		}()
+
+		if err := next(c); err != nil {
+			// Written here rather than by the router so the error response
+			// is kept like any other.
+			c.Error(err)
+		}
+
+		// Server errors are not kept so the client can retry them.
+		if res.Status >= http.StatusInternalServerError {
+			return nil
+		}
+
+		response := IdempotentResponse{Scope: scope, Key: key, Hash: hash, Status: res.Status, Header: map[string]string{}, Oversized: tee.oversized}
+		if !tee.oversized {
+			response.Body = tee.body.Bytes()
+		}
+		for _, name := range idempotentHeaders {
+			if value := res.Header().Get(name); value != "" {
+				response.Header[name] = value
+			}
+		}
+		if err := h.idempotency.CompleteIdempotencyKey(response); err != nil {
+			log.Printf("idempotency key %q: %v", key, err)
+			return nil
+		}
+		completed = true
+		return nil
+	}
+}
+
+// requestHash identifies a request by route, query and content. Headers do
+// not count: a retry asking for another format or language is still the same
+// request and gets the response that was stored. For an upload only the files
+// count, since the multipart boundary changes on every retry. Any other body
+// is hashed as it is copied to a temporary file, which stands in for the body
+// until cleanup is called. It is cut off at maxJSONBody, the most bindJSON
+// would read of it anyway, so an oversized body never fills the disk.
+func requestHash(c echo.Context) (string, func(), error) {
+	req := c.Request()
+	hash := sha256.New()
+	io.WriteString(hash, req.Method+" "+c.Path()+"?"+c.QueryParams().Encode()+"\n")
+
+	if strings.HasPrefix(req.Header.Get(echo.HeaderContentType), echo.MIMEMultipartForm) {
+		form, err := c.MultipartForm()
+		if err != nil {
+			return "", func() {}, err
+		}
+		for _, file := range form.File["taxFile"] {
+			src, err := file.Open()
+			if err != nil {
+				return "", func() {}, err
+			}
+			io.WriteString(hash, file.Filename+"\n")
+			_, err = io.Copy(hash, src)
+			src.Close()
+			if err != nil {
+				return "", func() {}, err
+			}
+		}
+		return hex.EncodeToString(hash.Sum(nil)), func() {}, nil
+	}
+
+	spool, err := os.CreateTemp("", "tax-request-*")
+	if err != nil {
+		return "", func() {}, err
+	}
+	cleanup := func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}
+	body := http.MaxBytesReader(c.Response(), req.Body, maxJSONBody)
+	if _, err := io.Copy(io.MultiWriter(hash, spool), body); err != nil {
+		return "", cleanup, err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return "", cleanup, err
+	}
+	req.Body = io.NopCloser(spool)
+	return hex.EncodeToString(hash.Sum(nil)), cleanup, nil
+}
+
+// teeWriter keeps a copy of the response body as it is written, up to
+// idempotentResponseLimit. Past it the copy is dropped and oversized set.
+type teeWriter struct {
+	http.ResponseWriter
+	body      bytes.Buffer
+	oversized bool
+}
+
+func (w *teeWriter) Write(b []byte) (int, error) {
+	switch {
+	case w.oversized:
+	case w.body.Len()+len(b) > idempotentResponseLimit:
+		w.oversized = true
+		w.body = bytes.Buffer{}
+	default:
+		w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *teeWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,267 @@
+// go:build unit
+
+package tax
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/stretchr/testify/assert"
+)
+
+type StubIdempotency struct {
+	mu        sync.Mutex
+	responses map[string]IdempotentResponse
+}
+
+func (s *StubIdempotency) ReserveIdempotencyKey(scope, key, hash string, lease, ttl time.Duration) (IdempotentResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.responses == nil {
+		s.responses = map[string]IdempotentResponse{}
+	}
+	stored, ok := s.responses[scope+" "+key]
+	age := time.Since(stored.CreatedAt)
+	if ok && !(stored.Status == 0 && age > lease) && age <= ttl {
+		return stored, ErrAlreadyExists
+	}
+	s.responses[scope+" "+key] = IdempotentResponse{Scope: scope, Key: key, Hash: hash, CreatedAt: time.Now()}
+	return s.responses[scope+" "+key], nil
+}
+
+func (s *StubIdempotency) CompleteIdempotencyKey(response IdempotentResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	response.CreatedAt = s.responses[response.Scope+" "+response.Key].CreatedAt
+	s.responses[response.Scope+" "+response.Key] = response
+	return nil
+}
+
+func (s *StubIdempotency) ReleaseIdempotencyKey(scope, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.responses, scope+" "+key)
+	return nil
+}
+
+func idempotentServer(store Storer, idempotency IdempotencyStorer) *echo.Echo {
+	e := echo.New()
+	e.HTTPErrorHandler = ErrorHandler
+	p := New(store).WithIdempotency(idempotency)
+	e.POST("/tax/calculations", p.CalculateTaxHandler, p.Idempotent)
+	e.POST("/tax/calculations/upload-csv", p.CalculateTaxCSVHandler, p.Idempotent)
+	return e
+}
+
+func TestIdempotent(t *testing.T) {
+	t.Run("given the same key and file twice should replay the stored response", func(t *testing.T) {
+		stubTax := &StubTax{calculateTax: Tax{Tax: 29000}}
+		e := idempotentServer(stubTax, &StubIdempotency{})
+
+		first := httptest.NewRecorder()
+		req := uploadRequest("/tax/calculations/upload-csv", "totalIncome,wht\n500000,0")
+		req.Header.Set(HeaderIdempotencyKey, "payroll-2024-03")
+		e.ServeHTTP(first, req)
+
+		stubTax.calculateTax = Tax{Tax: 1}
+		second := httptest.NewRecorder()
+		req = uploadRequest("/tax/calculations/upload-csv", "totalIncome,wht\n500000,0")
+		req.Header.Set(HeaderIdempotencyKey, "payroll-2024-03")
+		e.ServeHTTP(second, req)
+
+		assert.Equal(t, http.StatusOK, second.Code)
+		assert.Equal(t, first.Body.String(), second.Body.String())
+		assert.Contains(t, second.Body.String(), `"tax":29000`)
+		assert.Equal(t, echo.MIMEApplicationJSON, second.Header().Get(echo.HeaderContentType))
+		assert.Equal(t, "true", second.Header().Get("Idempotent-Replayed"))
+	})
+	t.Run("given the same key with another file should return status 409", func(t *testing.T) {
+		e := idempotentServer(&StubTax{}, &StubIdempotency{})
+
+		req := uploadRequest("/tax/calculations/upload-csv", "totalIncome,wht\n500000,0")
+		req.Header.Set(HeaderIdempotencyKey, "payroll-2024-03")
+		e.ServeHTTP(httptest.NewRecorder(), req)
+
+		rec := httptest.NewRecorder()
+		req = uploadRequest("/tax/calculations/upload-csv", "totalIncome,wht\n600000,0")
+		req.Header.Set(HeaderIdempotencyKey, "payroll-2024-03")
+		e.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.Equal(t, `{"type":"about:blank","title":"Conflict","status":409,"detail":"idempotency key was used for a different request","instance":"/tax/calculations/upload-csv","message":"idempotency key was used for a different request"}`+"\n", rec.Body.String())
+	})
+	t.Run("given the same key on another calculation body should return status 409", func(t *testing.T) {
+		e := idempotentServer(&StubTax{}, &StubIdempotency{})
+		send := func(body string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/tax/calculations", strings.NewReader(body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			req.Header.Set(HeaderIdempotencyKey, "calc-1")
+			e.ServeHTTP(rec, req)
+			return rec
+		}
+
+		first := send(`{"totalIncome":500000,"wht":0,"allowances":[]}`)
+		again := send(`{"totalIncome":500000,"wht":0,"allowances":[]}`)
+		other := send(`{"totalIncome":600000,"wht":0,"allowances":[]}`)
+
+		assert.Equal(t, http.StatusOK, first.Code)
+		assert.Equal(t, http.StatusOK, again.Code)
+		assert.Equal(t, "true", again.Header().Get("Idempotent-Replayed"))
+		assert.Equal(t, http.StatusConflict, other.Code)
+	})
+	t.Run("given a server error should release the key for a retry", func(t *testing.T) {
+		idempotency := &StubIdempotency{}
+		e := idempotentServer(&StubTax{err: errors.New("connection refused")}, idempotency)
+
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/tax/calculations", strings.NewReader(`{"totalIncome":500000,"wht":0,"allowances":[]}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(HeaderIdempotencyKey, "calc-1")
+		e.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.Empty(t, idempotency.responses)
+	})
+	t.Run("given no key should not store anything", func(t *testing.T) {
+		idempotency := &StubIdempotency{}
+		e := idempotentServer(&StubTax{}, idempotency)
+
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, uploadRequest("/tax/calculations/upload-csv", "totalIncome,wht\n500000,0"))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, idempotency.responses)
+	})
+	t.Run("given a handler that panics should release the key", func(t *testing.T) {
+		idempotency := &StubIdempotency{}
+		e := echo.New()
+		e.Use(middleware.Recover())
+		p := New(&StubTax{}).WithIdempotency(idempotency)
+		e.POST("/tax/calculations", func(c echo.Context) error { panic("boom") }, p.Idempotent)
+
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/tax/calculations", strings.NewReader(`{"totalIncome":500000}`))
+		req.Header.Set(HeaderIdempotencyKey, "calc-1")
+		e.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.Empty(t, idempotency.responses)
+	})
+	t.Run("given a reservation older than the lease should let a retry take the key", func(t *testing.T) {
+		idempotency := &StubIdempotency{responses: map[string]IdempotentResponse{
+			"POST /tax/calculations calc-1": {Scope: "POST /tax/calculations", Key: "calc-1", Hash: "abc", CreatedAt: time.Now().Add(-time.Hour)},
+		}}
+		e := idempotentServer(&StubTax{}, idempotency)
+
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/tax/calculations", strings.NewReader(`{"totalIncome":500000,"wht":0,"allowances":[]}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(HeaderIdempotencyKey, "calc-1")
+		e.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, http.StatusOK, idempotency.responses["POST /tax/calculations calc-1"].Status)
+	})
+	t.Run("given the same key on another route should answer each on its own", func(t *testing.T) {
+		e := idempotentServer(&StubTax{}, &StubIdempotency{})
+
+		first := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/tax/calculations", strings.NewReader(`{"totalIncome":500000,"wht":0,"allowances":[]}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(HeaderIdempotencyKey, "shared")
+		e.ServeHTTP(first, req)
+
+		second := httptest.NewRecorder()
+		req = uploadRequest("/tax/calculations/upload-csv", "totalIncome,wht\n500000,0")
+		req.Header.Set(HeaderIdempotencyKey, "shared")
+		e.ServeHTTP(second, req)
+
+		assert.Equal(t, http.StatusOK, first.Code)
+		assert.Equal(t, http.StatusOK, second.Code)
+		assert.Empty(t, second.Header().Get("Idempotent-Replayed"))
+	})
+	t.Run("given the same key in another language should replay the stored response", func(t *testing.T) {
+		e := idempotentServer(&StubTax{}, &StubIdempotency{})
+		send := func(lang string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			req := uploadRequest("/tax/calculations/upload-csv", "totalIncome,wht\n500000,0")
+			req.Header.Set(HeaderIdempotencyKey, "payroll-2024-03")
+			req.Header.Set("Accept-Language", lang)
+			e.ServeHTTP(rec, req)
+			return rec
+		}
+
+		first := send("en")
+		other := send("th")
+
+		assert.Equal(t, http.StatusOK, first.Code)
+		assert.Equal(t, http.StatusOK, other.Code)
+		assert.Equal(t, "true", other.Header().Get("Idempotent-Replayed"))
+		assert.Equal(t, first.Body.String(), other.Body.String())
+	})
+	t.Run("given a response too large to keep should refuse to replay it", func(t *testing.T) {
+		limit := idempotentResponseLimit
+		idempotentResponseLimit = 10
+		defer func() { idempotentResponseLimit = limit }()
+		idempotency := &StubIdempotency{}
+		store := &StubTax{}
+		e := idempotentServer(store, idempotency)
+		send := func() *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			req := uploadRequest("/tax/calculations/upload-csv", "totalIncome,wht\n500000,0")
+			req.Header.Set(HeaderIdempotencyKey, "payroll-2024-03")
+			e.ServeHTTP(rec, req)
+			return rec
+		}
+
+		first := send()
+		again := send()
+
+		assert.Equal(t, http.StatusOK, first.Code)
+		assert.Empty(t, idempotency.responses["POST /tax/calculations/upload-csv payroll-2024-03"].Body)
+		assert.Equal(t, http.StatusConflict, again.Code)
+		assert.Contains(t, again.Body.String(), `"message":"the response to this idempotency key was too large to keep"`)
+		assert.Equal(t, 1, store.settingsCalls, "the request should not run again")
+	})
+	t.Run("given a body over the limit should return status 413 without reserving the key", func(t *testing.T) {
+		limit := maxJSONBody
+		maxJSONBody = 16
+		defer func() { maxJSONBody = limit }()
+		idempotency := &StubIdempotency{}
+		e := idempotentServer(&StubTax{}, idempotency)
+
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/tax/calculations", strings.NewReader(`{"totalIncome":500000,"wht":0,"allowances":[]}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(HeaderIdempotencyKey, "calc-1")
+		e.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+		assert.Equal(t, MIMEApplicationProblemJSON, rec.Header().Get(echo.HeaderContentType))
+		assert.Contains(t, rec.Body.String(), `"message":"request body is too large"`)
+		assert.Empty(t, idempotency.responses)
+	})
+	t.Run("given a key without a store should return status 400", func(t *testing.T) {
+		e := echo.New()
+		e.HTTPErrorHandler = ErrorHandler
+		p := New(&StubTax{})
+		e.POST("/tax/calculations", p.CalculateTaxHandler, p.Idempotent)
+
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/tax/calculations", strings.NewReader(`{"totalIncome":500000,"wht":0,"allowances":[]}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(HeaderIdempotencyKey, "calc-1")
+		e.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"message":"idempotency keys are not supported"`)
+	})
+}
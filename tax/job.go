@@ -0,0 +1,497 @@
+package tax
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	maxRunningJobs   = 2
+	jobProgressEvery = 100
+	jobResultPage    = 500
+)
+
+// jobLease is how long a running job may go without an update before another
+// process takes it over. Running jobs touch their claim well within it.
+var jobLease = time.Minute
+
+// jobIDPattern matches the random UUIDs jobs are stored under, so a job id
+// is never a counter that can be walked.
+var jobIDPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+type bytesFile []byte
+
+type bytesReadCloser struct {
+	*bytes.Reader
+}
+
+func (bytesReadCloser) Close() error {
+	return nil
+}
+
+func (b bytesFile) Open() (io.ReadCloser, error) {
+	return bytesReadCloser{bytes.NewReader(b)}, nil
+}
+
+// jobInput opens the stored input of a job.
+type jobInput struct {
+	jobs JobStorer
+	id   string
+}
+
+func (j jobInput) Open() (io.ReadCloser, error) {
+	return j.jobs.OpenJobInput(j.id)
+}
+
+func (h *Handler) enqueueJob(c echo.Context, file *multipart.FileHeader, options fileOptions) error {
+	if h.jobs == nil {
+		return problem(http.StatusBadRequest, Err{Message: "async mode is not enabled"})
+	}
+
+	rows, errFile := openCSVRows(&MultipartFileHeader{file}, options)
+	if errFile.Message != "" {
+		return problem(http.StatusBadRequest, errFile)
+	}
+	rows.Close()
+
+	src, err := file.Open()
+	if err != nil {
+		return problem(http.StatusBadRequest, Err{Message: "failed to open file"})
+	}
+	defer src.Close()
+
+	job, err := h.jobs.CreateJob(Job{
+		Status:      JobStatusQueued,
+		Mode:        options.mode,
+		Sheet:       options.sheet,
+		Encoding:    options.encoding,
+		Delimiter:   delimiterName(options.delimiter),
+		Passthrough: options.passthrough,
+	}, src)
+	if err != nil {
+		return problem(http.StatusInternalServerError, Err{Message: "failed to create job", cause: err})
+	}
+	h.startJob(job)
+
+	job.Errors = []RowError{}
+	c.Response().Header().Set(echo.HeaderLocation, "/tax/jobs/"+job.ID)
+	return c.JSON(http.StatusAccepted, job)
+}
+
+// GetJobHandler answers the counts of a job with the first page of its row
+// errors; ListJobErrorsHandler serves the rest.
+func (h *Handler) GetJobHandler(c echo.Context) error {
+	job, status, errJob := h.findJob(c)
+	if errJob.Message != "" {
+		return problem(status, errJob)
+	}
+
+	rowErrors, err := h.jobs.ListJobErrors(job.ID, 0, defaultPageSize)
+	if err != nil {
+		return problem(http.StatusInternalServerError, Err{Message: "failed to list job errors", cause: err})
+	}
+	job.Errors = rowErrors
+	if job.Errors == nil {
+		job.Errors = []RowError{}
+	}
+
+	return c.JSON(http.StatusOK, job)
+}
+
+// ListJobErrorsHandler pages through the row errors of a job, which can run
+// to one per row of a large file.
+func (h *Handler) ListJobErrorsHandler(c echo.Context) error {
+	job, status, errJob := h.findJob(c)
+	if errJob.Message != "" {
+		return problem(status, errJob)
+	}
+
+	page, pageSize, errPage := pageParams(c)
+	if errPage.Message != "" {
+		return problem(http.StatusBadRequest, errPage)
+	}
+
+	rowErrors, err := h.jobs.ListJobErrors(job.ID, (page-1)*pageSize, pageSize)
+	if err != nil {
+		return problem(http.StatusInternalServerError, Err{Message: "failed to list job errors", cause: err})
+	}
+	if rowErrors == nil {
+		rowErrors = []RowError{}
+	}
+
+	return c.JSON(http.StatusOK, JobErrorPage{
+		Errors:   rowErrors,
+		Page:     page,
+		PageSize: pageSize,
+		Total:    job.Failed,
+	})
+}
+
+func (h *Handler) GetJobResultHandler(c echo.Context) error {
+	job, status, errJob := h.findJob(c)
+	if errJob.Message != "" {
+		return problem(status, errJob)
+	}
+	if job.Status != JobStatusCompleted {
+		return problem(http.StatusConflict, errorf("job is %s", job.Status))
+	}
+
+	format, errFormat := formatParam(c)
+	if errFormat.Message != "" {
+		return problem(http.StatusBadRequest, errFormat)
+	}
+
+	result, err := h.jobs.GetJobResult(job.ID)
+	if err != nil {
+		return problem(http.StatusInternalServerError, Err{Message: "failed to get job result", cause: err})
+	}
+	var batch batchResult
+	if err := json.Unmarshal(result, &batch); err != nil {
+		return problem(http.StatusInternalServerError, Err{Message: "failed to get job result", cause: err})
+	}
+
+	sink := newCSVStreamSink(c, format)
+	if err := sink.columns(batch.Columns); err != nil {
+		return err
+	}
+	for after := 0; ; after += jobResultPage {
+		rows, err := h.jobs.ListJobRows(job.ID, after, jobResultPage)
+		if err != nil {
+			if sink.started() {
+				return sink.fail(Err{Message: "failed to get job result", cause: err}, nil)
+			}
+			return problem(http.StatusInternalServerError, Err{Message: "failed to get job result", cause: err})
+		}
+		for _, data := range rows {
+			var row batchRow
+			if err := json.Unmarshal(data, &row); err != nil {
+				return sink.fail(Err{Message: "failed to get job result", cause: err}, nil)
+			}
+			output := row.output()
+			write := sink.result
+			if output.err.Message != "" {
+				write = sink.rowError
+			}
+			if err := write(output); err != nil {
+				return err
+			}
+		}
+		if len(rows) < jobResultPage {
+			break
+		}
+	}
+	if batch.Summary != nil {
+		if err := sink.summary(*batch.Summary); err != nil {
+			return err
+		}
+	}
+	return sink.close()
+}
+
+// ResumeJobs starts the jobs left queued, or running without a live claim,
+// by this or another process. Nothing of an interrupted run is kept, so its
+// rows are calculated again; calculations already saved are not repeated.
+func (h *Handler) ResumeJobs() error {
+	jobs, err := h.jobs.ListUnfinishedJobs()
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		if _, ok := h.running.Load(job.ID); ok {
+			continue
+		}
+		h.startJob(job)
+	}
+	return nil
+}
+
+// WatchJobs calls ResumeJobs every jobLease until ctx is done, which picks up
+// the jobs of a process that stopped while running them.
+func (h *Handler) WatchJobs(ctx context.Context) {
+	ticker := time.NewTicker(jobLease)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := h.ResumeJobs(); err != nil {
+				log.Printf("resume jobs: %v", err)
+			}
+		}
+	}
+}
+
+// startJob claims job and runs it in the background. A job another run
+// holds is left alone.
+func (h *Handler) startJob(job Job) {
+	claimed, err := h.jobs.ClaimJob(job.ID, jobLease)
+	if err != nil {
+		if !errors.Is(err, ErrConflict) {
+			log.Printf("job %s: %v", job.ID, err)
+		}
+		return
+	}
+	h.trackJob(claimed.ID)
+	go h.runJob(claimed)
+}
+
+func (h *Handler) runJob(job Job) {
+	progress := h.trackJob(job.ID)
+	defer h.running.Delete(job.ID)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stop := h.keepJobClaimed(job, cancel)
+	defer stop()
+
+	h.jobSlots <- struct{}{}
+	defer func() { <-h.jobSlots }()
+	progress.started.Store(true)
+
+	job.Status = JobStatusRunning
+	job.Processed, job.Succeeded, job.Failed = 0, 0, 0
+	job.Errors = nil
+	job.Message = ""
+	job.MessageText = Text{}
+
+	sink := &jobSink{h: h, job: &job, live: progress}
+	rowErrors, errFile := h.processTaxFile(ctx, jobInput{h.jobs, job.ID}, fileOptions{
+		mode:        job.Mode,
+		sheet:       job.Sheet,
+		encoding:    job.Encoding,
+		delimiter:   delimiters[job.Delimiter],
+		passthrough: job.Passthrough,
+	}, sink)
+	if ctx.Err() != nil || errors.Is(errFile.cause, ErrConflict) {
+		h.abandonJob(job, progress)
+		return
+	}
+	if errFile.Message != "" {
+		job.Status = JobStatusFailed
+		job.Message = errFile.Message
+		job.MessageText = errFile.text
+		if len(rowErrors) > 0 {
+			job.Failed = len(rowErrors)
+			if err := h.saveJobErrors(job, rowErrors); err != nil {
+				log.Printf("job %s: %v", job.ID, err)
+			}
+		}
+		err := h.jobs.UpdateJob(job)
+		if errors.Is(err, ErrConflict) {
+			h.abandonJob(job, progress)
+			return
+		}
+		if err != nil {
+			log.Printf("job %s: %v", job.ID, err)
+		}
+		progress.finish(job, nil)
+		return
+	}
+
+	result, err := json.Marshal(sink.batch)
+	if err == nil {
+		job.Status = JobStatusCompleted
+		err = h.jobs.CompleteJob(job, result)
+	}
+	if errors.Is(err, ErrConflict) {
+		h.abandonJob(job, progress)
+		return
+	}
+	if err != nil {
+		log.Printf("job %s: %v", job.ID, err)
+	}
+	progress.finish(job, sink.batch.Summary)
+}
+
+// abandonJob ends a run that lost its claim to another. Nothing more is
+// written; event streams fall back to what the new run saves.
+func (h *Handler) abandonJob(job Job, progress *batchProgress) {
+	log.Printf("job %s: claim lost, stopping this run", job.ID)
+	progress.abandon()
+}
+
+// keepJobClaimed touches the claim on a job a few times per lease until the
+// returned function is called. It calls lost and stops once the claim has
+// gone to another run.
+func (h *Handler) keepJobClaimed(job Job, lost func()) func() {
+	ticker := time.NewTicker(jobLease / 3)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				err := h.jobs.TouchJob(job.ID, job.Claim)
+				if errors.Is(err, ErrConflict) {
+					lost()
+					return
+				}
+				if err != nil {
+					log.Printf("job %s: %v", job.ID, err)
+				}
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
+
+// saveJobErrors stores the row errors of a job that failed validation as its
+// rows, jobProgressEvery at a time.
+func (h *Handler) saveJobErrors(job Job, rowErrors []RowError) error {
+	for first := 0; first < len(rowErrors); first += jobProgressEvery {
+		end := first + jobProgressEvery
+		if end > len(rowErrors) {
+			end = len(rowErrors)
+		}
+		rows := make([][]byte, 0, end-first)
+		for i := first; i < end; i++ {
+			data, err := json.Marshal(errorRow(nil, rowErrors[i]))
+			if err != nil {
+				return err
+			}
+			rows = append(rows, data)
+		}
+		if err := h.jobs.AppendJobRows(job.ID, job.Claim, first+1, rows); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *Handler) findJob(c echo.Context) (Job, int, Err) {
+	if h.jobs == nil {
+		return Job{}, http.StatusNotFound, Err{Message: "job not found"}
+	}
+
+	id := c.Param("jobId")
+	if !jobIDPattern.MatchString(id) {
+		return Job{}, http.StatusBadRequest, Err{Message: "job id must be a uuid"}
+	}
+
+	job, err := h.jobs.GetJob(id)
+	if errors.Is(err, ErrNotFound) {
+		return Job{}, http.StatusNotFound, Err{Message: "job not found"}
+	}
+	if err != nil {
+		return Job{}, http.StatusInternalServerError, Err{Message: "failed to get job", cause: err}
+	}
+
+	return job, http.StatusOK, Err{}
+}
+
+// batchResult is what a finished job keeps besides its rows, enough to render
+// the output again in any format.
+type batchResult struct {
+	Columns []string      `json:"columns"`
+	Summary *BatchSummary `json:"summary,omitempty"`
+}
+
+// batchRow is one output row of a job as it is stored. ErrorText is the
+// Text of Error, which its JSON leaves out.
+type batchRow struct {
+	Record    []string        `json:"record,omitempty"`
+	Result    *TaxResponseCSV `json:"result,omitempty"`
+	Levels    []TaxLevel      `json:"levels,omitempty"`
+	Error     *RowError       `json:"error,omitempty"`
+	ErrorText *Text           `json:"errorText,omitempty"`
+}
+
+func errorRow(record []string, rowErr RowError) batchRow {
+	row := batchRow{Record: record, Error: &rowErr}
+	if rowErr.Text.Key != "" {
+		row.ErrorText = &rowErr.Text
+	}
+	return row
+}
+
+func (r batchRow) output() rowOutput {
+	output := rowOutput{record: r.Record, levels: r.Levels}
+	if r.Result != nil {
+		output.result = *r.Result
+	}
+	if r.Error != nil {
+		output.err = *r.Error
+	}
+	if r.ErrorText != nil {
+		output.err.Text = *r.ErrorText
+	}
+	return output
+}
+
+// jobSink saves a job's rows and progress every jobProgressEvery rows, so a
+// job holds no more than that many rows in memory. live counts every row for
+// event streams.
+type jobSink struct {
+	h       *Handler
+	job     *Job
+	batch   batchResult
+	pending [][]byte
+	saved   int
+	live    *batchProgress
+}
+
+func (s *jobSink) columns(columns []string) error {
+	s.batch.Columns = append([]string(nil), columns...)
+	return nil
+}
+
+func (s *jobSink) result(row rowOutput) error {
+	result := row.result
+	s.job.Succeeded++
+	s.live.row(false)
+	return s.add(batchRow{Record: row.record, Result: &result, Levels: row.levels})
+}
+
+func (s *jobSink) rowError(row rowOutput) error {
+	s.job.Failed++
+	s.live.row(true)
+	return s.add(errorRow(row.record, row.err))
+}
+
+func (s *jobSink) summary(summary BatchSummary) error {
+	s.batch.Summary = &summary
+	return s.flush()
+}
+
+func (s *jobSink) add(row batchRow) error {
+	data, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	s.pending = append(s.pending, data)
+	s.job.Processed++
+	if len(s.pending) < jobProgressEvery {
+		return nil
+	}
+	if err := s.flush(); err != nil {
+		return err
+	}
+	return s.h.jobs.UpdateJob(*s.job)
+}
+
+func (s *jobSink) flush() error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+	if err := s.h.jobs.AppendJobRows(s.job.ID, s.job.Claim, s.saved+1, s.pending); err != nil {
+		return err
+	}
+	s.saved += len(s.pending)
+	s.pending = s.pending[:0]
+	return nil
+}
@@ -0,0 +1,468 @@
+// go:build unit
+
+package tax
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+type StubJobs struct {
+	mu      sync.Mutex
+	jobs    map[string]Job
+	inputs  map[string][]byte
+	rows    map[string][][]byte
+	results map[string][]byte
+	claims  int
+	updates int
+	appends int
+	err     error
+}
+
+func (s *StubJobs) CreateJob(job Job, input io.Reader) (Job, error) {
+	data, err := io.ReadAll(input)
+	if err != nil {
+		return Job{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.jobs == nil {
+		s.jobs = map[string]Job{}
+		s.inputs = map[string][]byte{}
+		s.rows = map[string][][]byte{}
+		s.results = map[string][]byte{}
+	}
+	job.ID = stubJobID(len(s.jobs) + 1)
+	s.jobs[job.ID] = job
+	s.inputs[job.ID] = data
+	return job, s.err
+}
+
+func (s *StubJobs) GetJob(id string) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	return job, s.err
+}
+
+func (s *StubJobs) ClaimJob(id string, lease time.Duration) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	stale := job.Status == JobStatusRunning && time.Since(job.UpdatedAt) > lease
+	if job.Status != JobStatusQueued && !stale {
+		return Job{}, ErrConflict
+	}
+	s.claims++
+	job.Status, job.Claim, job.UpdatedAt = JobStatusRunning, fmt.Sprint(s.claims), time.Now()
+	s.jobs[id] = job
+	delete(s.rows, id)
+	return job, s.err
+}
+
+// holds reports whether claim is still the claim on job id.
+func (s *StubJobs) holds(id, claim string) bool {
+	return s.jobs[id].Claim == claim
+}
+
+func (s *StubJobs) TouchJob(id, claim string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.holds(id, claim) {
+		return ErrConflict
+	}
+	return s.err
+}
+
+func (s *StubJobs) OpenJobInput(id string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return io.NopCloser(bytes.NewReader(s.inputs[id])), s.err
+}
+
+func (s *StubJobs) UpdateJob(job Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.updates++
+	if !s.holds(job.ID, job.Claim) {
+		return ErrConflict
+	}
+	s.jobs[job.ID] = job
+	return s.err
+}
+
+func (s *StubJobs) AppendJobRows(id, claim string, first int, rows [][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.appends++
+	if !s.holds(id, claim) {
+		return ErrConflict
+	}
+	for _, data := range rows {
+		s.rows[id] = append(s.rows[id], append([]byte(nil), data...))
+	}
+	return s.err
+}
+
+func (s *StubJobs) ListJobRows(id string, after int, limit int) ([][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rows := s.rows[id]
+	if after > len(rows) {
+		after = len(rows)
+	}
+	rows = rows[after:]
+	if len(rows) > limit {
+		rows = rows[:limit]
+	}
+	return rows, s.err
+}
+
+func (s *StubJobs) ListJobErrors(id string, offset int, limit int) ([]RowError, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var rowErrors []RowError
+	for _, data := range s.rows[id] {
+		var row batchRow
+		json.Unmarshal(data, &row)
+		if row.Error != nil {
+			rowErrors = append(rowErrors, row.output().err)
+		}
+	}
+	if offset > len(rowErrors) {
+		offset = len(rowErrors)
+	}
+	rowErrors = rowErrors[offset:]
+	if len(rowErrors) > limit {
+		rowErrors = rowErrors[:limit]
+	}
+	return rowErrors, s.err
+}
+
+func (s *StubJobs) CompleteJob(job Job, result []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.holds(job.ID, job.Claim) {
+		return ErrConflict
+	}
+	s.jobs[job.ID] = job
+	s.results[job.ID] = result
+	return s.err
+}
+
+func (s *StubJobs) GetJobResult(id string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result, ok := s.results[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return result, s.err
+}
+
+func (s *StubJobs) ListUnfinishedJobs() ([]Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var jobs []Job
+	for _, job := range s.jobs {
+		if job.Status == JobStatusQueued || job.Status == JobStatusRunning {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, s.err
+}
+
+// stubJobID is the id StubJobs gives its nth job.
+func stubJobID(n int) string {
+	return fmt.Sprintf("00000000-0000-4000-8000-%012d", n)
+}
+
+func jobRows(jobs *StubJobs, id string) []string {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	var rows []string
+	for _, data := range jobs.rows[id] {
+		rows = append(rows, string(data))
+	}
+	return rows
+}
+
+func uploadRequest(target string, content string) *http.Request {
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("taxFile", "taxes.csv")
+	part.Write([]byte(content))
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, target, body)
+	req.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+	return req
+}
+
+func waitForJob(t *testing.T, jobs *StubJobs, id string, status string) Job {
+	t.Helper()
+	var job Job
+	assert.Eventually(t, func() bool {
+		job, _ = jobs.GetJob(id)
+		return job.Status == status
+	}, time.Second, 5*time.Millisecond)
+	return job
+}
+
+func TestAsyncCSVUpload(t *testing.T) {
+	t.Run("given async upload should return status 202 and complete the job in the background", func(t *testing.T) {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		c := e.NewContext(uploadRequest("/tax/calculations/upload-csv?async=true&mode=lenient", "totalIncome,wht\n1000,0\ninvalid,0\n2000,0"), rec)
+
+		jobs := &StubJobs{}
+		p := New(&StubTax{}).WithJobs(jobs)
+
+		err := serve(c, p.CalculateTaxCSVHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusAccepted, rec.Code, "expected status code %d but got %d", http.StatusAccepted, rec.Code)
+		assert.Equal(t, "/tax/jobs/"+stubJobID(1), rec.Header().Get(echo.HeaderLocation))
+
+		job := waitForJob(t, jobs, stubJobID(1), JobStatusCompleted)
+		assert.Equal(t, 3, job.Processed)
+		assert.Equal(t, 2, job.Succeeded)
+		assert.Equal(t, 1, job.Failed)
+		assert.Equal(t, []string{
+			`{"record":["1000","0"],"result":{"totalIncome":1000,"tax":0}}`,
+			`{"record":["invalid","0"],"error":{"line":3,"column":"totalIncome","code":"INVALID_TYPE","message":"totalIncome must be a numeric value"},"errorText":{"key":"%s must be a numeric value","args":["totalIncome"]}}`,
+			`{"record":["2000","0"],"result":{"totalIncome":2000,"tax":0}}`,
+		}, jobRows(jobs, stubJobID(1)))
+		assert.Equal(t, `{"columns":["totalIncome","wht"],"summary":{"rows":3,"succeeded":2,"failed":1,"totalIncome":3000,"totalTax":0,"totalRefund":0,"brackets":[],"averageEffectiveRate":0,"tax":{"min":0,"max":0,"p50":0,"p90":0,"p99":0}}}`, string(jobs.results[stubJobID(1)]))
+	})
+	t.Run("given async upload with unknown column should return status 400 without creating a job", func(t *testing.T) {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		c := e.NewContext(uploadRequest("/tax/calculations/upload-csv?async=true", "totalIncome,wht,withholding\n1000,0,0"), rec)
+
+		jobs := &StubJobs{}
+		p := New(&StubTax{}).WithJobs(jobs)
+
+		err := serve(c, p.CalculateTaxCSVHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
+		assert.Empty(t, jobs.jobs)
+	})
+	t.Run("given async upload without job store should return status 400", func(t *testing.T) {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		c := e.NewContext(uploadRequest("/tax/calculations/upload-csv?async=true", "totalIncome,wht\n1000,0"), rec)
+
+		p := New(&StubTax{})
+
+		err := serve(c, p.CalculateTaxCSVHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
+		assert.Equal(t, `{"type":"about:blank","title":"Bad Request","status":400,"detail":"async mode is not enabled","instance":"/tax/calculations/upload-csv","message":"async mode is not enabled"}`, strings.TrimSuffix(rec.Body.String(), "\n"))
+	})
+}
+
+func TestListJobErrorsHandler(t *testing.T) {
+	jobs := &StubJobs{}
+	p := New(&StubTax{}).WithJobs(jobs)
+	job, _ := jobs.CreateJob(Job{Status: JobStatusCompleted, Mode: ModeLenient, Processed: 3, Succeeded: 1, Failed: 2}, strings.NewReader(""))
+	jobs.AppendJobRows(job.ID, "", 1, [][]byte{
+		[]byte(`{"record":["abc","0"],"error":{"line":2,"column":"totalIncome","code":"INVALID_TYPE","message":"totalIncome must be a numeric value"}}`),
+		[]byte(`{"record":["1000","0"],"result":{"totalIncome":1000,"tax":0}}`),
+		[]byte(`{"record":["1000","x"],"error":{"line":4,"column":"wht","code":"INVALID_TYPE","message":"wht must be a numeric value"}}`),
+	})
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+		body   string
+	}{
+		{"given no page should return the first page", "/", http.StatusOK, `{"errors":[{"line":2,"column":"totalIncome","code":"INVALID_TYPE","message":"totalIncome must be a numeric value"},{"line":4,"column":"wht","code":"INVALID_TYPE","message":"wht must be a numeric value"}],"page":1,"pageSize":20,"total":2}`},
+		{"given page 2 of size 1 should return the second error", "/?page=2&pageSize=1", http.StatusOK, `{"errors":[{"line":4,"column":"wht","code":"INVALID_TYPE","message":"wht must be a numeric value"}],"page":2,"pageSize":1,"total":2}`},
+		{"given page past the end should return no errors", "/?page=3&pageSize=1", http.StatusOK, `{"errors":[],"page":3,"pageSize":1,"total":2}`},
+		{"given invalid page size should return status 400", "/?pageSize=500", http.StatusBadRequest, `{"type":"about:blank","title":"Bad Request","status":400,"detail":"pageSize must be between 1 and 100","instance":"/","message":"pageSize must be between 1 and 100"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			rec := httptest.NewRecorder()
+			c := e.NewContext(httptest.NewRequest(http.MethodGet, tt.target, nil), rec)
+			c.SetParamNames("jobId")
+			c.SetParamValues(job.ID)
+
+			err := serve(c, p.ListJobErrorsHandler)
+
+			assert.NoError(t, err, "expected no error but got %v", err)
+			assert.Equal(t, tt.status, rec.Code, "expected status code %d but got %d", tt.status, rec.Code)
+			assert.Equal(t, tt.body, strings.TrimSuffix(rec.Body.String(), "\n"))
+		})
+	}
+}
+
+func TestRunJob(t *testing.T) {
+	t.Run("given strict job with invalid rows should fail with every row error", func(t *testing.T) {
+		jobs := &StubJobs{}
+		p := New(&StubTax{}).WithJobs(jobs)
+		job, _ := jobs.CreateJob(Job{Status: JobStatusQueued, Mode: ModeStrict}, strings.NewReader("totalIncome,wht\nabc,0\n1000,0\n-1,0\n"))
+
+		p.runJob(job)
+
+		got, _ := jobs.GetJob(job.ID)
+		got.Errors, _ = jobs.ListJobErrors(job.ID, 0, defaultPageSize)
+		assert.Equal(t, JobStatusFailed, got.Status)
+		assert.Equal(t, "totalIncome must be a numeric value", got.Message)
+		assert.Equal(t, 2, got.Failed)
+		assert.Len(t, got.Errors, 2)
+		assert.Empty(t, jobs.results)
+
+		b, err := marshalLocalized(LangThai, got)
+		assert.NoError(t, err)
+		assert.Contains(t, string(b), `"errors":[{"line":2,"column":"totalIncome","code":"INVALID_TYPE","message":"totalIncome ต้องเป็นตัวเลข"},{"line":4,"column":"totalIncome","code":"TOTAL_INCOME_NEGATIVE","message":"เงินได้ทั้งหมดต้องมากกว่า 0 บาท"}],"message":"totalIncome ต้องเป็นตัวเลข"`)
+	})
+	t.Run("given large job should save progress while running", func(t *testing.T) {
+		jobs := &StubJobs{}
+		p := New(&StubTax{}).WithJobs(jobs).WithWorkers(1)
+		job, _ := jobs.CreateJob(Job{Status: JobStatusQueued, Mode: ModeLenient}, strings.NewReader(csvContent(250)))
+
+		p.runJob(job)
+
+		got, _ := jobs.GetJob(job.ID)
+		assert.Equal(t, JobStatusCompleted, got.Status)
+		assert.Equal(t, 250, got.Succeeded)
+		assert.Equal(t, 2, jobs.updates)
+		assert.Equal(t, 3, jobs.appends)
+		assert.Len(t, jobRows(jobs, job.ID), 250)
+	})
+	t.Run("given unfinished job should run it again on resume", func(t *testing.T) {
+		jobs := &StubJobs{}
+		p := New(&StubTax{}).WithJobs(jobs)
+		job, _ := jobs.CreateJob(Job{Status: JobStatusRunning, Mode: ModeStrict}, strings.NewReader("totalIncome,wht\n1000,0\n"))
+
+		err := p.ResumeJobs()
+
+		assert.NoError(t, err)
+		got := waitForJob(t, jobs, job.ID, JobStatusCompleted)
+		assert.Equal(t, 1, got.Succeeded)
+	})
+	t.Run("given job claimed by a live run should not run it again on resume", func(t *testing.T) {
+		jobs := &StubJobs{}
+		p := New(&StubTax{}).WithJobs(jobs)
+		job, _ := jobs.CreateJob(Job{Status: JobStatusRunning, Mode: ModeStrict, UpdatedAt: time.Now()}, strings.NewReader("totalIncome,wht\n1000,0\n"))
+
+		err := p.ResumeJobs()
+
+		assert.NoError(t, err)
+		got, _ := jobs.GetJob(job.ID)
+		assert.Equal(t, JobStatusRunning, got.Status)
+		assert.Equal(t, 0, got.Processed)
+		_, running := p.running.Load(job.ID)
+		assert.False(t, running)
+	})
+	t.Run("given job taken over by another run should stop without writing", func(t *testing.T) {
+		jobs := &StubJobs{}
+		p := New(&StubTax{}).WithJobs(jobs).WithWorkers(1)
+		job, _ := jobs.CreateJob(Job{Status: JobStatusQueued, Mode: ModeLenient}, strings.NewReader(csvContent(250)))
+		claimed, _ := jobs.ClaimJob(job.ID, jobLease)
+		jobs.jobs[job.ID] = Job{ID: job.ID, Status: JobStatusRunning, Mode: ModeLenient, Claim: "other"}
+
+		p.runJob(claimed)
+
+		got, _ := jobs.GetJob(job.ID)
+		assert.Equal(t, JobStatusRunning, got.Status)
+		assert.Equal(t, 0, got.Processed)
+		assert.Equal(t, 0, jobs.updates)
+		assert.Empty(t, jobRows(jobs, job.ID))
+		assert.Empty(t, jobs.results)
+		_, running := p.running.Load(job.ID)
+		assert.False(t, running)
+	})
+	t.Run("given claim lost while running should stop the run", func(t *testing.T) {
+		lease := jobLease
+		jobLease = 3 * time.Millisecond
+		defer func() { jobLease = lease }()
+
+		jobs := &StubJobs{}
+		p := New(&StubTax{}).WithJobs(jobs)
+		job, _ := jobs.CreateJob(Job{Status: JobStatusQueued, Mode: ModeLenient}, strings.NewReader(""))
+		claimed, _ := jobs.ClaimJob(job.ID, jobLease)
+		jobs.ClaimJob(job.ID, 0)
+
+		lost := make(chan struct{})
+		stop := p.keepJobClaimed(claimed, func() { close(lost) })
+		defer stop()
+
+		select {
+		case <-lost:
+		case <-time.After(time.Second):
+			t.Fatal("expected the run to stop once its claim was lost")
+		}
+	})
+}
+
+func TestGetJob(t *testing.T) {
+	jobs := &StubJobs{}
+	p := New(&StubTax{}).WithJobs(jobs)
+	jobs.CreateJob(Job{Status: JobStatusRunning, Mode: ModeStrict}, strings.NewReader(""))
+	completed, _ := jobs.CreateJob(Job{Status: JobStatusCompleted, Mode: ModeStrict}, strings.NewReader(""))
+	jobs.results[completed.ID] = []byte(`{"columns":["totalIncome","wht"]}`)
+	jobs.AppendJobRows(completed.ID, "", 1, [][]byte{
+		[]byte(`{"record":["1000","0"],"result":{"totalIncome":1000,"tax":0},"levels":[{"level":"0-150,000","tax":0}]}`),
+		[]byte(`{"record":["abc","0"],"error":{"line":3,"column":"totalIncome","code":"INVALID_TYPE","message":"totalIncome must be a numeric value"}}`),
+	})
+
+	tests := []struct {
+		name    string
+		handler func(*Handler) echo.HandlerFunc
+		id      string
+		status  int
+		body    string
+	}{
+		{"given running job should return its status", func(h *Handler) echo.HandlerFunc { return h.GetJobHandler }, stubJobID(1), http.StatusOK, ""},
+		{"given unknown job should return status 404", func(h *Handler) echo.HandlerFunc { return h.GetJobHandler }, stubJobID(9), http.StatusNotFound, `{"type":"about:blank","title":"Not Found","status":404,"detail":"job not found","instance":"/","message":"job not found"}`},
+		{"given invalid job id should return status 400", func(h *Handler) echo.HandlerFunc { return h.GetJobHandler }, "1", http.StatusBadRequest, `{"type":"about:blank","title":"Bad Request","status":400,"detail":"job id must be a uuid","instance":"/","message":"job id must be a uuid"}`},
+		{"given failed rows should return the counts with the first page of errors", func(h *Handler) echo.HandlerFunc { return h.GetJobHandler }, stubJobID(2), http.StatusOK, `{"id":"` + stubJobID(2) + `","status":"completed","mode":"strict","processed":0,"succeeded":0,"failed":0,"errors":[{"line":3,"column":"totalIncome","code":"INVALID_TYPE","message":"totalIncome must be a numeric value"}],"createdAt":"0001-01-01T00:00:00Z","updatedAt":"0001-01-01T00:00:00Z"}`},
+		{"given running job result should return status 409", func(h *Handler) echo.HandlerFunc { return h.GetJobResultHandler }, stubJobID(1), http.StatusConflict, `{"type":"about:blank","title":"Conflict","status":409,"detail":"job is running","instance":"/","message":"job is running"}`},
+		{"given completed job result should return the output", func(h *Handler) echo.HandlerFunc { return h.GetJobResultHandler }, stubJobID(2), http.StatusOK, `{"taxes":[{"totalIncome":1000,"tax":0}],"errors":[{"line":3,"column":"totalIncome","code":"INVALID_TYPE","message":"totalIncome must be a numeric value"}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("jobId")
+			c.SetParamValues(tt.id)
+
+			err := serve(c, tt.handler(p))
+
+			assert.NoError(t, err, "expected no error but got %v", err)
+			assert.Equal(t, tt.status, rec.Code, "expected status code %d but got %d", tt.status, rec.Code)
+			if tt.body != "" {
+				assert.Equal(t, tt.body, strings.TrimSuffix(rec.Body.String(), "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package tax
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	maxInstalments       = 3
+	minInstalmentTaxDue  = 3000.0
+	buddhistEraYearShift = 543
+)
+
+func (h *Handler) GetLedgerHandler(c echo.Context) error {
+	calculation, status, errCalculation := h.findCalculation(c)
+	if errCalculation.Message != "" {
+		return problem(status, errCalculation)
+	}
+
+	ledger, err := h.loadLedger(calculation)
+	if err != nil {
+		return problem(http.StatusInternalServerError, Err{Message: "failed to get ledger", cause: err})
+	}
+
+	return c.JSON(http.StatusOK, ledger)
+}
+
+func (h *Handler) SetInstalmentPlanHandler(c echo.Context) error {
+	calculation, status, errCalculation := h.findCalculation(c)
+	if errCalculation.Message != "" {
+		return problem(status, errCalculation)
+	}
+
+	var request InstalmentPlanRequest
+	if status, err := bindJSON(c, &request); err.Message != "" {
+		return problem(status, err)
+	}
+	if request.Instalments < 1 || request.Instalments > maxInstalments {
+		return problem(http.StatusBadRequest, Err{Message: "instalments must be between 1 and 3"})
+	}
+	if calculation.Tax.Tax <= 0.0 {
+		return problem(http.StatusBadRequest, Err{Message: "calculation has no tax due"})
+	}
+	if request.Instalments > 1 && calculation.Tax.Tax < minInstalmentTaxDue {
+		return problem(http.StatusBadRequest, Err{Message: "instalments are only available when tax due is 3,000.0 or more"})
+	}
+
+	payments, err := h.ledger.ListPayments(calculation.ID)
+	if err != nil {
+		return problem(http.StatusInternalServerError, Err{Message: "failed to get ledger", cause: err})
+	}
+	if len(payments) > 0 {
+		return problem(http.StatusConflict, Err{Message: "instalment plan can not change after a payment is recorded"})
+	}
+
+	if err := h.ledger.SaveInstalmentPlan(calculation.ID, request.Instalments); err != nil {
+		return problem(http.StatusInternalServerError, Err{Message: "failed to save instalment plan", cause: err})
+	}
+
+	return c.JSON(http.StatusOK, buildLedger(calculation, request.Instalments, payments, time.Now()))
+}
+
+func (h *Handler) RecordPaymentHandler(c echo.Context) error {
+	calculation, status, errCalculation := h.findCalculation(c)
+	if errCalculation.Message != "" {
+		return problem(status, errCalculation)
+	}
+
+	var payment Payment
+	if status, err := bindJSON(c, &payment); err.Message != "" {
+		return problem(status, err)
+	}
+	if payment.Amount <= 0.0 {
+		return problem(http.StatusBadRequest, Err{Message: "payment amount must be greater than 0.0"})
+	}
+	if payment.PaidAt.IsZero() {
+		payment.PaidAt = time.Now()
+	}
+	payment.ID = 0
+	payment.CalculationID = calculation.ID
+
+	ledger, err := h.loadLedger(calculation)
+	if err != nil {
+		return problem(http.StatusInternalServerError, Err{Message: "failed to get ledger", cause: err})
+	}
+	if round2(payment.Amount) > ledger.Outstanding {
+		return problem(http.StatusBadRequest, Err{Message: "payment exceeds outstanding balance"})
+	}
+
+	payment, err = h.ledger.RecordPayment(payment, ledger.TaxDue)
+	if errors.Is(err, ErrConflict) {
+		return problem(http.StatusBadRequest, Err{Message: "payment exceeds outstanding balance"})
+	}
+	if err != nil {
+		return problem(http.StatusInternalServerError, Err{Message: "failed to record payment", cause: err})
+	}
+
+	payments := append(ledger.Payments, payment)
+	return c.JSON(http.StatusCreated, buildLedger(calculation, ledger.Instalments, payments, time.Now()))
+}
+
+func (h *Handler) findCalculation(c echo.Context) (Calculation, int, Err) {
+	taxpayerID := c.Param("taxpayerId")
+	if !isValidTaxpayerID(taxpayerID) {
+		return Calculation{}, http.StatusBadRequest, Err{Message: "taxpayer id must be a valid 13-digit national id"}
+	}
+
+	id, err := strconv.ParseInt(c.Param("calculationId"), 10, 64)
+	if err != nil || id <= 0 {
+		return Calculation{}, http.StatusBadRequest, Err{Message: "calculation id must be a positive integer"}
+	}
+
+	calculation, err := h.history.GetCalculation(taxpayerID, id)
+	if errors.Is(err, ErrNotFound) {
+		return Calculation{}, http.StatusNotFound, Err{Message: "calculation not found"}
+	}
+	if err != nil {
+		return Calculation{}, http.StatusInternalServerError, Err{Message: "failed to get calculation", cause: err}
+	}
+
+	return calculation, http.StatusOK, Err{}
+}
+
+func (h *Handler) loadLedger(calculation Calculation) (Ledger, error) {
+	instalments, err := h.ledger.GetInstalmentPlan(calculation.ID)
+	if err != nil {
+		return Ledger{}, err
+	}
+	payments, err := h.ledger.ListPayments(calculation.ID)
+	if err != nil {
+		return Ledger{}, err
+	}
+	return buildLedger(calculation, instalments, payments, time.Now()), nil
+}
+
+// buildLedger splits the tax due into the instalment plan, applies payments
+// oldest instalment first and flags instalments still unpaid after their due
+// date. The first instalment is due on 31 March after the tax year and each
+// following one a month later.
+func buildLedger(calculation Calculation, instalments int, payments []Payment, now time.Time) Ledger {
+	taxDue := round2(math.Max(calculation.Tax.Tax, 0.0))
+	if instalments < 1 || taxDue < minInstalmentTaxDue {
+		instalments = 1
+	}
+	if payments == nil {
+		payments = []Payment{}
+	}
+
+	var totalPaid float64
+	for _, payment := range payments {
+		totalPaid += payment.Amount
+	}
+	totalPaid = round2(totalPaid)
+
+	ledger := Ledger{
+		CalculationID: calculation.ID,
+		TaxDue:        taxDue,
+		Instalments:   instalments,
+		TotalPaid:     totalPaid,
+		Outstanding:   round2(math.Max(taxDue-totalPaid, 0.0)),
+		Payments:      payments,
+	}
+
+	year := calculation.TaxYear - buddhistEraYearShift + 1
+	share := math.Floor(taxDue/float64(instalments)*100) / 100
+	remainingPaid := totalPaid
+	for i := 0; i < instalments; i++ {
+		amount := share
+		if i == instalments-1 {
+			amount = round2(taxDue - share*float64(instalments-1))
+		}
+
+		paid := math.Min(amount, remainingPaid)
+		remainingPaid = round2(remainingPaid - paid)
+
+		instalment := Instalment{
+			Number:      i + 1,
+			DueDate:     time.Date(year, time.April+time.Month(i), 0, 0, 0, 0, 0, time.UTC),
+			Amount:      amount,
+			Paid:        round2(paid),
+			Outstanding: round2(amount - paid),
+		}
+		instalment.Overdue = instalment.Outstanding > 0.0 && now.After(instalment.DueDate.AddDate(0, 0, 1))
+		if instalment.Overdue {
+			ledger.Overdue = true
+		}
+		ledger.Schedule = append(ledger.Schedule, instalment)
+	}
+
+	return ledger
+}
+
+func round2(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
@@ -0,0 +1,201 @@
+// go:build unit
+
+package tax
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+type StubLedger struct {
+	instalments int
+	payments    []Payment
+	recorded    Payment
+	conflict    bool
+	err         error
+}
+
+func (s *StubLedger) GetInstalmentPlan(calculationID int64) (int, error) {
+	if s.instalments == 0 {
+		return 1, s.err
+	}
+	return s.instalments, s.err
+}
+
+func (s *StubLedger) SaveInstalmentPlan(calculationID int64, instalments int) error {
+	s.instalments = instalments
+	return s.err
+}
+
+func (s *StubLedger) RecordPayment(payment Payment, taxDue float64) (Payment, error) {
+	if s.conflict {
+		return Payment{}, ErrConflict
+	}
+	payment.ID = int64(len(s.payments) + 1)
+	s.recorded = payment
+	return payment, s.err
+}
+
+func (s *StubLedger) ListPayments(calculationID int64) ([]Payment, error) {
+	return s.payments, s.err
+}
+
+func TestBuildLedger(t *testing.T) {
+	calculation := Calculation{ID: 7, TaxYear: 2567, Tax: Tax{Tax: 10000.0}}
+
+	t.Run("given three instalments should split tax due and schedule monthly due dates", func(t *testing.T) {
+		got := buildLedger(calculation, 3, nil, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+
+		assert.Equal(t, []Instalment{
+			{Number: 1, DueDate: time.Date(2025, 3, 31, 0, 0, 0, 0, time.UTC), Amount: 3333.33, Outstanding: 3333.33},
+			{Number: 2, DueDate: time.Date(2025, 4, 30, 0, 0, 0, 0, time.UTC), Amount: 3333.33, Outstanding: 3333.33},
+			{Number: 3, DueDate: time.Date(2025, 5, 31, 0, 0, 0, 0, time.UTC), Amount: 3333.34, Outstanding: 3333.34},
+		}, got.Schedule)
+		assert.Equal(t, 10000.0, got.Outstanding)
+		assert.False(t, got.Overdue)
+	})
+	t.Run("given partial payment should apply to oldest instalment first and flag overdue", func(t *testing.T) {
+		payments := []Payment{{Amount: 5000.0}}
+
+		got := buildLedger(calculation, 3, payments, time.Date(2025, 5, 2, 0, 0, 0, 0, time.UTC))
+
+		assert.Equal(t, 0.0, got.Schedule[0].Outstanding)
+		assert.Equal(t, 1666.66, got.Schedule[1].Outstanding)
+		assert.True(t, got.Schedule[1].Overdue)
+		assert.False(t, got.Schedule[2].Overdue)
+		assert.Equal(t, 5000.0, got.TotalPaid)
+		assert.Equal(t, 5000.0, got.Outstanding)
+		assert.True(t, got.Overdue)
+	})
+	t.Run("given tax due below 3,000 should use a single instalment", func(t *testing.T) {
+		got := buildLedger(Calculation{TaxYear: 2567, Tax: Tax{Tax: 2999.0}}, 3, nil, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+
+		assert.Equal(t, 1, got.Instalments)
+		assert.Len(t, got.Schedule, 1)
+	})
+}
+
+func TestSetInstalmentPlanHandler(t *testing.T) {
+	t.Run("given tax due below 3,000 should return status 400 and error message", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPut, "/taxpayers/1101700230708/calculations/7/ledger", io.NopCloser(strings.NewReader(`{"instalments": 3}`)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("taxpayerId", "calculationId")
+		c.SetParamValues("1101700230708", "7")
+
+		history := StubHistory{calculations: []Calculation{{ID: 7, TaxpayerID: "1101700230708", TaxYear: 2567, Tax: Tax{Tax: 2000.0}}}}
+		p := New(&StubTax{}).WithHistory(&history).WithLedger(&StubLedger{})
+
+		err := serve(c, p.SetInstalmentPlanHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
+		assert.JSONEq(t, `{"type": "about:blank", "title": "Bad Request", "status": 400, "detail": "instalments are only available when tax due is 3,000.0 or more", "instance": "/taxpayers/1101700230708/calculations/7/ledger", "message": "instalments are only available when tax due is 3,000.0 or more"}`, rec.Body.String())
+	})
+	t.Run("given existing payments should return status 409 and error message", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPut, "/taxpayers/1101700230708/calculations/7/ledger", io.NopCloser(strings.NewReader(`{"instalments": 3}`)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("taxpayerId", "calculationId")
+		c.SetParamValues("1101700230708", "7")
+
+		history := StubHistory{calculations: []Calculation{{ID: 7, TaxpayerID: "1101700230708", TaxYear: 2567, Tax: Tax{Tax: 9000.0}}}}
+		p := New(&StubTax{}).WithHistory(&history).WithLedger(&StubLedger{payments: []Payment{{ID: 1, Amount: 100.0}}})
+
+		err := serve(c, p.SetInstalmentPlanHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusConflict, rec.Code, "expected status code %d but got %d", http.StatusConflict, rec.Code)
+	})
+}
+
+func TestRecordPaymentHandler(t *testing.T) {
+	t.Run("given payment within outstanding balance should return status 201 and ledger", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/taxpayers/1101700230708/calculations/7/payments", io.NopCloser(strings.NewReader(`{"amount": 3000.0, "reference": "KTB-001"}`)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("taxpayerId", "calculationId")
+		c.SetParamValues("1101700230708", "7")
+
+		stubLedger := StubLedger{instalments: 3}
+		history := StubHistory{calculations: []Calculation{{ID: 7, TaxpayerID: "1101700230708", TaxYear: 2567, Tax: Tax{Tax: 9000.0}}}}
+		p := New(&StubTax{}).WithHistory(&history).WithLedger(&stubLedger)
+
+		err := serve(c, p.RecordPaymentHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusCreated, rec.Code, "expected status code %d but got %d", http.StatusCreated, rec.Code)
+		assert.Equal(t, int64(7), stubLedger.recorded.CalculationID)
+		assert.Equal(t, "KTB-001", stubLedger.recorded.Reference)
+		assert.False(t, stubLedger.recorded.PaidAt.IsZero())
+	})
+	t.Run("given payment above outstanding balance should return status 400 and error message", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/taxpayers/1101700230708/calculations/7/payments", io.NopCloser(strings.NewReader(`{"amount": 9000.01}`)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("taxpayerId", "calculationId")
+		c.SetParamValues("1101700230708", "7")
+
+		history := StubHistory{calculations: []Calculation{{ID: 7, TaxpayerID: "1101700230708", TaxYear: 2567, Tax: Tax{Tax: 9000.0}}}}
+		p := New(&StubTax{}).WithHistory(&history).WithLedger(&StubLedger{})
+
+		err := serve(c, p.RecordPaymentHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
+		assert.JSONEq(t, `{"type": "about:blank", "title": "Bad Request", "status": 400, "detail": "payment exceeds outstanding balance", "instance": "/taxpayers/1101700230708/calculations/7/payments", "message": "payment exceeds outstanding balance"}`, rec.Body.String())
+	})
+	t.Run("given body over the limit should return status 413", func(t *testing.T) {
+		defer func(limit int64) { maxJSONBody = limit }(maxJSONBody)
+		maxJSONBody = 16
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/taxpayers/1101700230708/calculations/7/payments", io.NopCloser(strings.NewReader(`{"amount": 3000.0, "reference": "KTB-001"}`)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("taxpayerId", "calculationId")
+		c.SetParamValues("1101700230708", "7")
+
+		history := StubHistory{calculations: []Calculation{{ID: 7, TaxpayerID: "1101700230708", TaxYear: 2567, Tax: Tax{Tax: 9000.0}}}}
+		p := New(&StubTax{}).WithHistory(&history).WithLedger(&StubLedger{})
+
+		err := serve(c, p.RecordPaymentHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code, "expected status code %d but got %d", http.StatusRequestEntityTooLarge, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"message":"request body is too large"`)
+	})
+	t.Run("given balance settled by a concurrent payment should return status 400 and error message", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/taxpayers/1101700230708/calculations/7/payments", io.NopCloser(strings.NewReader(`{"amount": 9000.0}`)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("taxpayerId", "calculationId")
+		c.SetParamValues("1101700230708", "7")
+
+		history := StubHistory{calculations: []Calculation{{ID: 7, TaxpayerID: "1101700230708", TaxYear: 2567, Tax: Tax{Tax: 9000.0}}}}
+		p := New(&StubTax{}).WithHistory(&history).WithLedger(&StubLedger{conflict: true})
+
+		err := serve(c, p.RecordPaymentHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
+		assert.JSONEq(t, `{"type": "about:blank", "title": "Bad Request", "status": 400, "detail": "payment exceeds outstanding balance", "instance": "/taxpayers/1101700230708/calculations/7/payments", "message": "payment exceeds outstanding balance"}`, rec.Body.String())
+	})
+}
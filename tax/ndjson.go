@@ -0,0 +1,121 @@
+package tax
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// maxNDJSONLine bounds a single input document.
+const maxNDJSONLine = 64 * 1024
+
+type NDJSONCalculationResult struct {
+	Line   int  `json:"line"`
+	Status int  `json:"status"`
+	Tax    *Tax `json:"tax,omitempty"`
+	Error  *Err `json:"error,omitempty"`
+}
+
+// CalculateTaxNDJSONHandler reads one UserInfo per line and writes one result
+// per line as soon as it is calculated. The next line is only read once the
+// previous result has been written, so a slow reader slows the calculation
+// rather than piling results up in memory. A bad line gets an error line and
+// the stream carries on.
+func (h *Handler) CalculateTaxNDJSONHandler(c echo.Context) error {
+	if !strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), MIMEApplicationNDJSON) {
+		return problem(http.StatusUnsupportedMediaType, errorf("content type must be %s", MIMEApplicationNDJSON))
+	}
+
+	decode, errDecode := decodeParam(c)
+	if errDecode.Message != "" {
+		return problem(http.StatusBadRequest, errDecode)
+	}
+	taxYear, errYear := taxYearParam(c)
+	if errYear.Message != "" {
+		return problem(http.StatusBadRequest, errYear)
+	}
+
+	settings, err := h.store.Settings()
+	if err != nil {
+		return problem(http.StatusInternalServerError, Err{Message: "failed to get settings", cause: err})
+	}
+
+	out := &stream{res: c.Response(), contentType: MIMEApplicationNDJSON, lang: languageParam(c)}
+	reader := bufio.NewReaderSize(c.Request().Body, maxNDJSONLine)
+	ctx := c.Request().Context()
+	for line := 1; ; line++ {
+		if ctx.Err() != nil {
+			return nil
+		}
+		data, tooLong, err := readNDJSONLine(reader)
+		if err == io.EOF && len(data) == 0 {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		if len(bytes.TrimSpace(data)) == 0 && !tooLong {
+			continue
+		}
+		result := h.calculateNDJSONLine(data, tooLong, decode, taxYear, settings)
+		result.Line = line
+		if result.Status >= http.StatusInternalServerError {
+			logCause(c, *result.Error)
+		}
+		b, errJSON := out.marshal(result)
+		if errJSON != nil {
+			return errJSON
+		}
+		if err := out.write(append(b, '\n')); err != nil {
+			return err
+		}
+		out.res.Flush()
+	}
+
+	if !out.started() {
+		c.Response().Header().Set(echo.HeaderContentType, MIMEApplicationNDJSON)
+		c.Response().WriteHeader(http.StatusOK)
+	}
+	return nil
+}
+
+func (h *Handler) calculateNDJSONLine(data []byte, tooLong bool, decode string, taxYear int, settings Settings) NDJSONCalculationResult {
+	if tooLong {
+		return NDJSONCalculationResult{Status: http.StatusRequestEntityTooLarge, Error: &Err{Message: "line is too long"}}
+	}
+
+	var userInfo UserInfo
+	if errJSON := decodeJSON(decode, data, &userInfo); errJSON.Message != "" {
+		return NDJSONCalculationResult{Status: http.StatusBadRequest, Error: &errJSON}
+	}
+	if errCalc := h.validationPublicUserInfo(userInfo); errCalc.Message != "" {
+		return NDJSONCalculationResult{Status: http.StatusBadRequest, Error: &errCalc}
+	}
+
+	calculation, status, errCalc := h.calculateUserInfo(userInfo, taxYear, &settings)
+	if errCalc.Message != "" {
+		return NDJSONCalculationResult{Status: status, Error: &errCalc}
+	}
+	return NDJSONCalculationResult{Status: http.StatusOK, Tax: &calculation.Tax}
+}
+
+// readNDJSONLine returns the next line without its newline. A line longer
+// than the reader's buffer is skipped to its end and reported as too long.
+func readNDJSONLine(r *bufio.Reader) ([]byte, bool, error) {
+	data, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		for err == bufio.ErrBufferFull {
+			_, err = r.ReadSlice('\n')
+		}
+		if err == io.EOF {
+			err = nil
+		}
+		return []byte{}, true, err
+	}
+	return bytes.TrimRight(data, "\r\n"), false, err
+}
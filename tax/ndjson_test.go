@@ -0,0 +1,78 @@
+// go:build unit
+
+package tax
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCalculateTaxNDJSONHandler(t *testing.T) {
+	t.Run("given ndjson lines should write one result per line and carry on after bad lines", func(t *testing.T) {
+		e := echo.New()
+		body := `{"totalIncome":500000,"wht":0,"allowances":[]}` + "\n" +
+			`{"totalIncome":` + "\n" +
+			"\n" +
+			`{"totalIncome":500000,"wht":600000}` + "\r\n" +
+			`{"totalIncome":500000,"wht":0}`
+		req := httptest.NewRequest(http.MethodPost, "/tax/calculations/ndjson", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, MIMEApplicationNDJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		stubTax := &StubTax{calculateTax: Tax{Tax: 29000, TaxLevel: []TaxLevel{}}}
+		p := New(stubTax)
+
+		err := serve(c, p.CalculateTaxNDJSONHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusOK, rec.Code, "expected status code %d but got %d", http.StatusOK, rec.Code)
+		assert.Equal(t, MIMEApplicationNDJSON, rec.Header().Get(echo.HeaderContentType))
+		assert.Equal(t, `{"line":1,"status":200,"tax":{"tax":29000,"taxLevel":[]}}`+"\n"+
+			`{"line":2,"status":400,"error":{"message":"invalid request body"}}`+"\n"+
+			`{"line":4,"status":400,"error":{"message":"wht must be less than or equal to total income","errors":[{"code":"WHT_EXCEEDS_INCOME","path":"wht","value":600000,"message":"wht must be less than or equal to total income"}]}}`+"\n"+
+			`{"line":5,"status":200,"tax":{"tax":29000,"taxLevel":[]}}`+"\n", rec.Body.String())
+		assert.Equal(t, 1, stubTax.settingsCalls)
+	})
+	t.Run("given a line over the limit should report it and read the next one", func(t *testing.T) {
+		e := echo.New()
+		body := `{"totalIncome":500000,"wht":0,"note":"` + strings.Repeat("x", maxNDJSONLine) + `"}` + "\n" +
+			`{"totalIncome":500000,"wht":0}` + "\n"
+		req := httptest.NewRequest(http.MethodPost, "/tax/calculations/ndjson", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, MIMEApplicationNDJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		p := New(&StubTax{})
+
+		err := serve(c, p.CalculateTaxNDJSONHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		scanner := bufio.NewScanner(strings.NewReader(rec.Body.String()))
+		var lines []string
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		assert.Equal(t, []string{
+			`{"line":1,"status":413,"error":{"message":"line is too long"}}`,
+			`{"line":2,"status":200,"tax":{"tax":0,"taxLevel":null}}`,
+		}, lines)
+	})
+	t.Run("given a json content type should return status 415", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/tax/calculations/ndjson", strings.NewReader(`{"totalIncome":500000,"wht":0}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		p := New(&StubTax{})
+
+		err := serve(c, p.CalculateTaxNDJSONHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusUnsupportedMediaType, rec.Code, "expected status code %d but got %d", http.StatusUnsupportedMediaType, rec.Code)
+	})
+}
@@ -0,0 +1,110 @@
+package tax
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+const MIMEApplicationProblemJSON = "application/problem+json"
+
+// Problem is a failed request. Handlers return it and ErrorHandler writes it
+// as an RFC 7807 problem details document.
+type Problem struct {
+	Status int
+	Err    Err
+	// Rows are the row errors of a rejected file, sent in place of Err.Errors.
+	Rows []RowError
+}
+
+func problem(status int, err Err) error {
+	return &Problem{Status: status, Err: err}
+}
+
+func (p *Problem) Error() string {
+	return p.Err.Message
+}
+
+func (p *Problem) Unwrap() error {
+	return p.Err.cause
+}
+
+// ProblemDetails is the body of an error response. Message repeats Detail for
+// clients that still read the old {"message": ...} body.
+type ProblemDetails struct {
+	Type      string      `json:"type"`
+	Title     string      `json:"title"`
+	Status    int         `json:"status"`
+	Detail    string      `json:"detail"`
+	Instance  string      `json:"instance"`
+	RequestID string      `json:"requestId,omitempty"`
+	Message   string      `json:"message"`
+	Errors    interface{} `json:"errors,omitempty"`
+	text      Text
+}
+
+// ErrorHandler is the Echo HTTPErrorHandler. It answers every error with
+// application/problem+json and logs the cause of server errors, which the
+// client only sees as a generic message.
+func ErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	var p *Problem
+	var he *echo.HTTPError
+	switch {
+	case errors.As(err, &p):
+	case errors.As(err, &he):
+		p = &Problem{Status: he.Code, Err: Err{Message: fmt.Sprint(he.Message), cause: he.Internal}}
+	default:
+		p = &Problem{Status: http.StatusInternalServerError, Err: Err{Message: "internal server error", cause: err}}
+	}
+
+	requestID := requestIDOf(c)
+	if p.Status >= http.StatusInternalServerError {
+		logCause(c, p.Err)
+	}
+
+	details := ProblemDetails{
+		Type:      "about:blank",
+		Title:     http.StatusText(p.Status),
+		Status:    p.Status,
+		Detail:    p.Err.Message,
+		Instance:  c.Request().URL.Path,
+		RequestID: requestID,
+		Message:   p.Err.Message,
+		text:      p.Err.text,
+	}
+	switch {
+	case len(p.Rows) > 0:
+		details.Errors = p.Rows
+	case len(p.Err.Errors) > 0:
+		details.Errors = p.Err.Errors
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, MIMEApplicationProblemJSON)
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(p.Status)
+	} else {
+		err = c.JSON(p.Status, details)
+	}
+	if err != nil {
+		logCause(c, Err{Message: "failed to write error response", cause: err})
+	}
+}
+
+func requestIDOf(c echo.Context) string {
+	if id := c.Response().Header().Get(echo.HeaderXRequestID); id != "" {
+		return id
+	}
+	return c.Request().Header.Get(echo.HeaderXRequestID)
+}
+
+// logCause logs a server side failure with the request it belongs to.
+func logCause(c echo.Context, err Err) {
+	log.Printf("%s %s request %s: %s: %v", c.Request().Method, c.Request().URL.Path, requestIDOf(c), err.Message, err.cause)
+}
@@ -0,0 +1,97 @@
+// go:build unit
+
+package tax
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// serve runs handler the way the router does, answering a returned Problem
+// with ErrorHandler. Any other error is returned.
+func serve(c echo.Context, handler echo.HandlerFunc) error {
+	err := handler(c)
+	var p *Problem
+	if errors.As(err, &p) {
+		ErrorHandler(err, c)
+		return nil
+	}
+	return err
+}
+
+func TestErrorHandler(t *testing.T) {
+	t.Run("given store error should return status 500 as problem json and log the cause", func(t *testing.T) {
+		logs := new(bytes.Buffer)
+		log.SetOutput(logs)
+		defer log.SetOutput(os.Stderr)
+
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/tax/calculations", nil)
+		req.Header.Set(echo.HeaderXRequestID, "req-1")
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		ErrorHandler(problem(http.StatusInternalServerError, Err{Message: "failed to calculate tax", cause: errors.New("connection refused")}), c)
+
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.Equal(t, MIMEApplicationProblemJSON, rec.Header().Get(echo.HeaderContentType))
+		assert.Equal(t, `{"type":"about:blank","title":"Internal Server Error","status":500,"detail":"failed to calculate tax","instance":"/tax/calculations","requestId":"req-1","message":"failed to calculate tax"}`, strings.TrimSuffix(rec.Body.String(), "\n"))
+		assert.Contains(t, logs.String(), "POST /tax/calculations request req-1: failed to calculate tax: connection refused")
+	})
+	t.Run("given rejected file should return its row errors", func(t *testing.T) {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(http.MethodPost, "/tax/calculations/upload-csv", nil), rec)
+
+		ErrorHandler(&Problem{Status: http.StatusBadRequest, Err: Err{Message: "wht must be a numeric value"}, Rows: []RowError{{Line: 2, Column: "wht", Message: "wht must be a numeric value"}}}, c)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, `{"type":"about:blank","title":"Bad Request","status":400,"detail":"wht must be a numeric value","instance":"/tax/calculations/upload-csv","message":"wht must be a numeric value","errors":[{"line":2,"column":"wht","message":"wht must be a numeric value"}]}`, strings.TrimSuffix(rec.Body.String(), "\n"))
+	})
+	t.Run("given unknown route should return status 404 as problem json", func(t *testing.T) {
+		e := echo.New()
+		e.HTTPErrorHandler = ErrorHandler
+		rec := httptest.NewRecorder()
+
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/tax/unknown", nil))
+
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.Equal(t, MIMEApplicationProblemJSON, rec.Header().Get(echo.HeaderContentType))
+		assert.Equal(t, `{"type":"about:blank","title":"Not Found","status":404,"detail":"Not Found","instance":"/tax/unknown","message":"Not Found"}`, strings.TrimSuffix(rec.Body.String(), "\n"))
+	})
+	t.Run("given unexpected error should return status 500 without its text", func(t *testing.T) {
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(os.Stderr)
+
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(http.MethodGet, "/tax/jobs/1", nil), rec)
+
+		ErrorHandler(errors.New("pq: password authentication failed"), c)
+
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.NotContains(t, rec.Body.String(), "password")
+		assert.Contains(t, rec.Body.String(), `"message":"internal server error"`)
+	})
+	t.Run("given thai language should localize the detail", func(t *testing.T) {
+		e := echo.New()
+		e.JSONSerializer = JSONSerializer{}
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(http.MethodPost, "/tax/calculations?lang=th", nil), rec)
+
+		ErrorHandler(problem(http.StatusBadRequest, Err{Message: "invalid request body"}), c)
+
+		assert.Contains(t, rec.Body.String(), `"detail":"รูปแบบคำขอไม่ถูกต้อง"`)
+		assert.Contains(t, rec.Body.String(), `"message":"รูปแบบคำขอไม่ถูกต้อง"`)
+	})
+}
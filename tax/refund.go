@@ -0,0 +1,159 @@
+package tax
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+var refundStatuses = map[string]bool{
+	RefundStatusSubmitted:   true,
+	RefundStatusUnderReview: true,
+	RefundStatusApproved:    true,
+	RefundStatusPaid:        true,
+	RefundStatusRejected:    true,
+}
+
+var refundTransitions = map[string][]string{
+	RefundStatusSubmitted:   {RefundStatusUnderReview, RefundStatusRejected},
+	RefundStatusUnderReview: {RefundStatusApproved, RefundStatusRejected},
+	RefundStatusApproved:    {RefundStatusPaid},
+}
+
+func canTransitionRefund(from, to string) bool {
+	for _, next := range refundTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) CreateRefundClaimHandler(c echo.Context) error {
+	taxpayerID := c.Param("taxpayerId")
+	if !isValidTaxpayerID(taxpayerID) {
+		return problem(http.StatusBadRequest, Err{Message: "taxpayer id must be a valid 13-digit national id"})
+	}
+
+	calculationID, err := strconv.ParseInt(c.Param("calculationId"), 10, 64)
+	if err != nil || calculationID <= 0 {
+		return problem(http.StatusBadRequest, Err{Message: "calculation id must be a positive integer"})
+	}
+
+	var request RefundClaimRequest
+	if status, err := bindJSON(c, &request); err.Message != "" {
+		return problem(status, err)
+	}
+	if err := h.validationBankAccount(request.BankAccount); err.Message != "" {
+		return problem(http.StatusBadRequest, err)
+	}
+
+	calculation, err := h.history.GetCalculation(taxpayerID, calculationID)
+	if errors.Is(err, ErrNotFound) {
+		return problem(http.StatusNotFound, Err{Message: "calculation not found"})
+	}
+	if err != nil {
+		return problem(http.StatusInternalServerError, Err{Message: "failed to get calculation", cause: err})
+	}
+	if calculation.Tax.TaxRefund <= 0.0 {
+		return problem(http.StatusBadRequest, Err{Message: "calculation has no tax refund"})
+	}
+
+	// The route sits behind basic auth, so the claim is filed by whoever
+	// signed in, not by the taxpayer it is for.
+	actor, _, _ := c.Request().BasicAuth()
+	claim, err := h.refunds.CreateRefundClaim(RefundClaim{
+		CalculationID: calculation.ID,
+		TaxpayerID:    taxpayerID,
+		Amount:        calculation.Tax.TaxRefund,
+		BankAccount:   request.BankAccount,
+		Status:        RefundStatusSubmitted,
+		Transitions:   []RefundTransition{{To: RefundStatusSubmitted, Actor: actor}},
+	})
+	if errors.Is(err, ErrAlreadyExists) {
+		return problem(http.StatusConflict, Err{Message: "refund claim already exists for this calculation"})
+	}
+	if err != nil {
+		return problem(http.StatusInternalServerError, Err{Message: "failed to create refund claim", cause: err})
+	}
+
+	return c.JSON(http.StatusCreated, claim)
+}
+
+func (h *Handler) GetRefundClaimHandler(c echo.Context) error {
+	id, errID := refundClaimIDParam(c)
+	if errID.Message != "" {
+		return problem(http.StatusBadRequest, errID)
+	}
+
+	claim, err := h.refunds.GetRefundClaim(id)
+	if errors.Is(err, ErrNotFound) {
+		return problem(http.StatusNotFound, Err{Message: "refund claim not found"})
+	}
+	if err != nil {
+		return problem(http.StatusInternalServerError, Err{Message: "failed to get refund claim", cause: err})
+	}
+
+	taxpayerID := c.Param("taxpayerId")
+	if taxpayerID != "" && claim.TaxpayerID != taxpayerID {
+		return problem(http.StatusNotFound, Err{Message: "refund claim not found"})
+	}
+
+	return c.JSON(http.StatusOK, claim)
+}
+
+func (h *Handler) TransitionRefundClaimHandler(c echo.Context) error {
+	id, errID := refundClaimIDParam(c)
+	if errID.Message != "" {
+		return problem(http.StatusBadRequest, errID)
+	}
+
+	var request RefundTransitionRequest
+	if status, err := bindJSON(c, &request); err.Message != "" {
+		return problem(status, err)
+	}
+	if request.Status == "" {
+		return problem(http.StatusBadRequest, Err{Message: "status is required"})
+	}
+	if !refundStatuses[request.Status] {
+		return problem(http.StatusBadRequest, Err{Message: "status must be one of submitted, under_review, approved, paid or rejected"})
+	}
+
+	claim, err := h.refunds.GetRefundClaim(id)
+	if errors.Is(err, ErrNotFound) {
+		return problem(http.StatusNotFound, Err{Message: "refund claim not found"})
+	}
+	if err != nil {
+		return problem(http.StatusInternalServerError, Err{Message: "failed to get refund claim", cause: err})
+	}
+
+	if !canTransitionRefund(claim.Status, request.Status) {
+		return problem(http.StatusConflict, errorf("refund claim can not move from %s to %s", claim.Status, request.Status))
+	}
+
+	actor, _, _ := c.Request().BasicAuth()
+	updated, err := h.refunds.TransitionRefundClaim(claim.ID, RefundTransition{
+		From:  claim.Status,
+		To:    request.Status,
+		Actor: actor,
+		Note:  request.Note,
+	})
+	if errors.Is(err, ErrConflict) {
+		return problem(http.StatusConflict, Err{Message: "refund claim was modified concurrently"})
+	}
+	if err != nil {
+		return problem(http.StatusInternalServerError, Err{Message: "failed to update refund claim", cause: err})
+	}
+
+	return c.JSON(http.StatusOK, updated)
+}
+
+func refundClaimIDParam(c echo.Context) (int64, Err) {
+	id, err := strconv.ParseInt(c.Param("claimId"), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, Err{Message: "refund claim id must be a positive integer"}
+	}
+	return id, Err{}
+}
@@ -0,0 +1,197 @@
+// go:build unit
+
+package tax
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+type StubRefunds struct {
+	claim      RefundClaim
+	created    RefundClaim
+	transition RefundTransition
+	err        error
+}
+
+func (s *StubRefunds) CreateRefundClaim(claim RefundClaim) (RefundClaim, error) {
+	s.created = claim
+	claim.ID = 1
+	return claim, s.err
+}
+
+func (s *StubRefunds) GetRefundClaim(id int64) (RefundClaim, error) {
+	if s.claim.ID != id {
+		return RefundClaim{}, ErrNotFound
+	}
+	return s.claim, nil
+}
+
+func (s *StubRefunds) TransitionRefundClaim(id int64, transition RefundTransition) (RefundClaim, error) {
+	s.transition = transition
+	claim := s.claim
+	claim.Status = transition.To
+	claim.Transitions = append(claim.Transitions, transition)
+	return claim, s.err
+}
+
+func TestCanTransitionRefund(t *testing.T) {
+	tests := []struct {
+		from string
+		to   string
+		want bool
+	}{
+		{from: RefundStatusSubmitted, to: RefundStatusUnderReview, want: true},
+		{from: RefundStatusSubmitted, to: RefundStatusRejected, want: true},
+		{from: RefundStatusSubmitted, to: RefundStatusApproved, want: false},
+		{from: RefundStatusUnderReview, to: RefundStatusApproved, want: true},
+		{from: RefundStatusApproved, to: RefundStatusPaid, want: true},
+		{from: RefundStatusApproved, to: RefundStatusRejected, want: false},
+		{from: RefundStatusPaid, to: RefundStatusSubmitted, want: false},
+		{from: RefundStatusRejected, to: RefundStatusUnderReview, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from+" to "+tt.to, func(t *testing.T) {
+			assert.Equal(t, tt.want, canTransitionRefund(tt.from, tt.to))
+		})
+	}
+}
+
+func TestCreateRefundClaimHandler(t *testing.T) {
+	t.Run("given calculation with refund should create submitted claim", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/taxpayers/1101700230708/calculations/7/refund-claims", io.NopCloser(strings.NewReader(`{"bankAccount": {"bankCode": "004", "accountNumber": "1234567890", "accountName": "Somchai"}}`)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.SetBasicAuth("adminTax", "admin!")
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("taxpayerId", "calculationId")
+		c.SetParamValues("1101700230708", "7")
+
+		stubRefunds := StubRefunds{}
+		history := StubHistory{calculations: []Calculation{{ID: 7, TaxpayerID: "1101700230708", Tax: Tax{TaxRefund: 2500.0}}}}
+		p := New(&StubTax{}).WithHistory(&history).WithRefunds(&stubRefunds)
+
+		err := serve(c, p.CreateRefundClaimHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusCreated, rec.Code, "expected status code %d but got %d", http.StatusCreated, rec.Code)
+		assert.Equal(t, 2500.0, stubRefunds.created.Amount)
+		assert.Equal(t, RefundStatusSubmitted, stubRefunds.created.Status)
+		assert.Equal(t, []RefundTransition{{To: RefundStatusSubmitted, Actor: "adminTax"}}, stubRefunds.created.Transitions)
+	})
+	t.Run("given calculation without refund should return status 400 and error message", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/taxpayers/1101700230708/calculations/7/refund-claims", io.NopCloser(strings.NewReader(`{"bankAccount": {"bankCode": "004", "accountNumber": "1234567890", "accountName": "Somchai"}}`)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("taxpayerId", "calculationId")
+		c.SetParamValues("1101700230708", "7")
+
+		history := StubHistory{calculations: []Calculation{{ID: 7, TaxpayerID: "1101700230708", Tax: Tax{Tax: 2500.0}}}}
+		p := New(&StubTax{}).WithHistory(&history).WithRefunds(&StubRefunds{})
+
+		err := serve(c, p.CreateRefundClaimHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
+		assert.JSONEq(t, `{"type": "about:blank", "title": "Bad Request", "status": 400, "detail": "calculation has no tax refund", "instance": "/taxpayers/1101700230708/calculations/7/refund-claims", "message": "calculation has no tax refund"}`, rec.Body.String())
+	})
+	t.Run("given invalid bank account should return status 400 and error message", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/taxpayers/1101700230708/calculations/7/refund-claims", io.NopCloser(strings.NewReader(`{"bankAccount": {"bankCode": "004", "accountNumber": "12-34", "accountName": "Somchai"}}`)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("taxpayerId", "calculationId")
+		c.SetParamValues("1101700230708", "7")
+
+		p := New(&StubTax{}).WithHistory(&StubHistory{}).WithRefunds(&StubRefunds{})
+
+		err := serve(c, p.CreateRefundClaimHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
+		assert.JSONEq(t, `{"type": "about:blank", "title": "Bad Request", "status": 400, "detail": "accountNumber must be 10 to 15 digits", "instance": "/taxpayers/1101700230708/calculations/7/refund-claims", "message": "accountNumber must be 10 to 15 digits", "errors": [{"code": "ACCOUNT_NUMBER_INVALID", "path": "bankAccount.accountNumber", "value": "12-34", "message": "accountNumber must be 10 to 15 digits"}]}`, rec.Body.String())
+	})
+	t.Run("given unknown field should return status 400 with the field", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/taxpayers/1101700230708/calculations/7/refund-claims", io.NopCloser(strings.NewReader(`{"bankAccount": {"bankCode": "004", "accountNumber": "1234567890", "accountName": "Somchai"}, "amount": 1000000}`)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("taxpayerId", "calculationId")
+		c.SetParamValues("1101700230708", "7")
+
+		p := New(&StubTax{}).WithHistory(&StubHistory{}).WithRefunds(&StubRefunds{})
+
+		err := serve(c, p.CreateRefundClaimHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"path":"amount"`)
+	})
+}
+
+func TestTransitionRefundClaimHandler(t *testing.T) {
+	t.Run("given allowed transition should record actor and return status 200", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/admin/refund-claims/1/transitions", io.NopCloser(strings.NewReader(`{"status": "under_review", "note": "documents received"}`)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.SetBasicAuth("adminTax", "admin!")
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("claimId")
+		c.SetParamValues("1")
+
+		stubRefunds := StubRefunds{claim: RefundClaim{ID: 1, Status: RefundStatusSubmitted}}
+		p := New(&StubTax{}).WithRefunds(&stubRefunds)
+
+		err := serve(c, p.TransitionRefundClaimHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusOK, rec.Code, "expected status code %d but got %d", http.StatusOK, rec.Code)
+		assert.Equal(t, RefundTransition{From: RefundStatusSubmitted, To: RefundStatusUnderReview, Actor: "adminTax", Note: "documents received"}, stubRefunds.transition)
+	})
+	t.Run("given disallowed transition should return status 409 and error message", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/admin/refund-claims/1/transitions", io.NopCloser(strings.NewReader(`{"status": "paid"}`)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("claimId")
+		c.SetParamValues("1")
+
+		p := New(&StubTax{}).WithRefunds(&StubRefunds{claim: RefundClaim{ID: 1, Status: RefundStatusSubmitted}})
+
+		err := serve(c, p.TransitionRefundClaimHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusConflict, rec.Code, "expected status code %d but got %d", http.StatusConflict, rec.Code)
+		assert.JSONEq(t, `{"type": "about:blank", "title": "Conflict", "status": 409, "detail": "refund claim can not move from submitted to paid", "instance": "/admin/refund-claims/1/transitions", "message": "refund claim can not move from submitted to paid"}`, rec.Body.String())
+	})
+	t.Run("given unknown status should return status 400 and error message", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/admin/refund-claims/1/transitions", io.NopCloser(strings.NewReader(`{"status": "cancelled"}`)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("claimId")
+		c.SetParamValues("1")
+
+		p := New(&StubTax{}).WithRefunds(&StubRefunds{claim: RefundClaim{ID: 1, Status: RefundStatusSubmitted}})
+
+		err := serve(c, p.TransitionRefundClaimHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
+	})
+}
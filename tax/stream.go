@@ -0,0 +1,259 @@
+package tax
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	MIMEApplicationNDJSON = "application/x-ndjson"
+	MIMETextCSV           = "text/csv"
+	MIMEApplicationXLSX   = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+	FormatCSV    = "csv"
+	FormatXLSX   = "xlsx"
+
+	flushEvery = 100
+)
+
+type csvStreamSink interface {
+	rowSink
+	started() bool
+	close() error
+	fail(errFile Err, rowErrors []RowError) error
+}
+
+// formatParam picks the output format from ?format=, falling back to the
+// Accept header and then to JSON.
+func formatParam(c echo.Context) (string, Err) {
+	switch format := c.QueryParam("format"); format {
+	case FormatJSON, FormatNDJSON, FormatCSV, FormatXLSX:
+		return format, Err{}
+	case "":
+	default:
+		return "", Err{Message: "format must be json, ndjson, csv or xlsx"}
+	}
+
+	accept := c.Request().Header.Get(echo.HeaderAccept)
+	switch {
+	case strings.Contains(accept, MIMEApplicationXLSX):
+		return FormatXLSX, Err{}
+	case strings.Contains(accept, MIMETextCSV):
+		return FormatCSV, Err{}
+	case strings.Contains(accept, MIMEApplicationNDJSON):
+		return FormatNDJSON, Err{}
+	default:
+		return FormatJSON, Err{}
+	}
+}
+
+func newCSVStreamSink(c echo.Context, format string) csvStreamSink {
+	lang := languageParam(c)
+	switch format {
+	case FormatNDJSON:
+		return &ndjsonSink{stream: stream{res: c.Response(), contentType: MIMEApplicationNDJSON, lang: lang}}
+	case FormatCSV:
+		return &tableSink{
+			stream:   stream{res: c.Response(), contentType: MIMETextCSV + "; charset=utf-8", filename: "taxes.csv", lang: lang},
+			newTable: newCSVTable,
+		}
+	case FormatXLSX:
+		return &tableSink{
+			stream:   stream{res: c.Response(), contentType: MIMEApplicationXLSX, filename: "taxes.xlsx", lang: lang},
+			newTable: newXLSXTable,
+		}
+	default:
+		return &jsonSink{stream: stream{res: c.Response(), contentType: echo.MIMEApplicationJSON, lang: lang}}
+	}
+}
+
+// stream writes a 200 response in pieces as rows are calculated. Nothing is
+// sent until the first write, so a file rejected up front can still be
+// answered with a plain 400. Messages and levels are written in lang.
+type stream struct {
+	res         *echo.Response
+	contentType string
+	filename    string
+	lang        string
+	begun       bool
+	writes      int
+}
+
+func (s *stream) started() bool {
+	return s.begun
+}
+
+func (s *stream) Write(b []byte) (int, error) {
+	if err := s.write(b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (s *stream) marshal(v interface{}) ([]byte, error) {
+	return marshalLocalized(s.lang, v)
+}
+
+func (s *stream) write(b []byte) error {
+	if !s.begun {
+		s.begun = true
+		s.res.Header().Set(echo.HeaderContentType, s.contentType)
+		if s.filename != "" {
+			s.res.Header().Set(echo.HeaderContentDisposition, `attachment; filename="`+s.filename+`"`)
+		}
+		s.res.WriteHeader(http.StatusOK)
+	}
+	if _, err := s.res.Write(b); err != nil {
+		return err
+	}
+	s.writes++
+	if s.writes%flushEvery == 0 {
+		s.res.Flush()
+	}
+	return nil
+}
+
+// jsonSink writes the same document as TaxCSVResponse, streaming the taxes
+// array. Row errors are held back until the array is closed.
+type jsonSink struct {
+	stream
+	count  int
+	errors []RowError
+	totals *BatchSummary
+}
+
+func (s *jsonSink) columns(columns []string) error {
+	return nil
+}
+
+func (s *jsonSink) result(row rowOutput) error {
+	b, err := s.marshal(row.result)
+	if err != nil {
+		return err
+	}
+	prefix := ","
+	if s.count == 0 {
+		prefix = `{"taxes":[`
+	}
+	s.count++
+	return s.write(append([]byte(prefix), b...))
+}
+
+func (s *jsonSink) rowError(row rowOutput) error {
+	s.errors = append(s.errors, row.err)
+	return nil
+}
+
+func (s *jsonSink) summary(summary BatchSummary) error {
+	s.totals = &summary
+	return nil
+}
+
+func (s *jsonSink) close() error {
+	return s.end(Err{})
+}
+
+func (s *jsonSink) fail(errFile Err, rowErrors []RowError) error {
+	s.errors = append(s.errors, rowErrors...)
+	return s.end(errFile)
+}
+
+func (s *jsonSink) end(errFile Err) error {
+	trailer := "]"
+	if s.count == 0 {
+		trailer = `{"taxes":[]`
+	}
+	if len(s.errors) > 0 {
+		b, err := s.marshal(s.errors)
+		if err != nil {
+			return err
+		}
+		trailer += `,"errors":` + string(b)
+	}
+	if s.totals != nil {
+		b, err := s.marshal(s.totals)
+		if err != nil {
+			return err
+		}
+		trailer += `,"summary":` + string(b)
+	}
+	if errFile.Message != "" {
+		b, err := json.Marshal(errFile.messageIn(s.lang))
+		if err != nil {
+			return err
+		}
+		trailer += `,"message":` + string(b)
+	}
+	if err := s.write([]byte(trailer + "}\n")); err != nil {
+		return err
+	}
+	s.res.Flush()
+	return nil
+}
+
+// ndjsonSink writes one JSON document per line: a result, or an object with
+// an error key for a row that could not be calculated, and finally an object
+// with a summary key.
+type ndjsonSink struct {
+	stream
+}
+
+type ndjsonError struct {
+	Error RowError `json:"error"`
+}
+
+type ndjsonSummary struct {
+	Summary BatchSummary `json:"summary"`
+}
+
+func (s *ndjsonSink) columns(columns []string) error {
+	return nil
+}
+
+func (s *ndjsonSink) result(row rowOutput) error {
+	return s.line(row.result)
+}
+
+func (s *ndjsonSink) rowError(row rowOutput) error {
+	return s.line(ndjsonError{Error: row.err})
+}
+
+// summary is the last line of a complete stream.
+func (s *ndjsonSink) summary(summary BatchSummary) error {
+	return s.line(ndjsonSummary{Summary: summary})
+}
+
+func (s *ndjsonSink) close() error {
+	if !s.begun {
+		if err := s.write(nil); err != nil {
+			return err
+		}
+	}
+	s.res.Flush()
+	return nil
+}
+
+func (s *ndjsonSink) fail(errFile Err, rowErrors []RowError) error {
+	if len(rowErrors) == 0 {
+		rowErrors = []RowError{{Message: errFile.Message, Text: errFile.text}}
+	}
+	for _, rowErr := range rowErrors {
+		if err := s.rowError(rowOutput{err: rowErr}); err != nil {
+			return err
+		}
+	}
+	return s.close()
+}
+
+func (s *ndjsonSink) line(v interface{}) error {
+	b, err := s.marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.write(append(b, '\n'))
+}
@@ -0,0 +1,187 @@
+package tax
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// summarySample bounds the memory kept for percentiles. Past it the taxes are
+// a uniform sample of the batch, so percentiles of very large files are
+// estimates while min and max stay exact.
+const summarySample = 10000
+
+type BatchSummary struct {
+	Rows                 int            `json:"rows"`
+	Succeeded            int            `json:"succeeded"`
+	Failed               int            `json:"failed"`
+	TotalIncome          float64        `json:"totalIncome"`
+	TotalTax             float64        `json:"totalTax"`
+	TotalRefund          float64        `json:"totalRefund"`
+	Brackets             []BracketCount `json:"brackets"`
+	AverageEffectiveRate float64        `json:"averageEffectiveRate"`
+	Tax                  TaxStats       `json:"tax"`
+}
+
+type BracketCount struct {
+	Level  string `json:"level"`
+	People int    `json:"people"`
+}
+
+type TaxStats struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+}
+
+// summaryBuilder accumulates a BatchSummary from rows in the order they are
+// emitted.
+type summaryBuilder struct {
+	summary  BatchSummary
+	brackets map[string]int
+	rates    float64
+	rated    int
+	sample   []float64
+	random   *rand.Rand
+}
+
+func newSummaryBuilder() *summaryBuilder {
+	return &summaryBuilder{
+		summary:  BatchSummary{Brackets: []BracketCount{}},
+		brackets: map[string]int{},
+		random:   rand.New(rand.NewSource(1)),
+	}
+}
+
+func (b *summaryBuilder) add(row rowOutput) {
+	s := &b.summary
+	s.Rows++
+	if row.err.Message != "" {
+		s.Failed++
+		return
+	}
+	s.Succeeded++
+
+	result := row.result
+	s.TotalIncome += result.TotalIncome
+	s.TotalTax += result.Tax
+	s.TotalRefund += result.TaxRefund
+
+	if level, ok := bracket(row.levels); ok {
+		if _, seen := b.brackets[level]; !seen {
+			b.brackets[level] = len(s.Brackets)
+			s.Brackets = append(s.Brackets, BracketCount{Level: level})
+		}
+		s.Brackets[b.brackets[level]].People++
+	}
+
+	// The effective rate is the tax owed on the income before withholding is
+	// credited, so a refund does not make it negative.
+	if result.TotalIncome > 0 {
+		var owed float64
+		for _, level := range row.levels {
+			owed += level.Tax
+		}
+		b.rates += owed / result.TotalIncome
+		b.rated++
+	}
+
+	if s.Succeeded == 1 || result.Tax < s.Tax.Min {
+		s.Tax.Min = result.Tax
+	}
+	if s.Succeeded == 1 || result.Tax > s.Tax.Max {
+		s.Tax.Max = result.Tax
+	}
+	if len(b.sample) < summarySample {
+		b.sample = append(b.sample, result.Tax)
+	} else if i := b.random.Intn(s.Succeeded); i < summarySample {
+		b.sample[i] = result.Tax
+	}
+}
+
+// merge adds the rows other has seen. Its sampled taxes join the sample as
+// rows would, so percentiles stay exact until the rows of both pass
+// summarySample.
+func (b *summaryBuilder) merge(other *summaryBuilder) {
+	s, o := &b.summary, other.summary
+	succeeded := s.Succeeded
+	s.Rows += o.Rows
+	s.Succeeded += o.Succeeded
+	s.Failed += o.Failed
+	s.TotalIncome += o.TotalIncome
+	s.TotalTax += o.TotalTax
+	s.TotalRefund += o.TotalRefund
+
+	for _, count := range o.Brackets {
+		if _, seen := b.brackets[count.Level]; !seen {
+			b.brackets[count.Level] = len(s.Brackets)
+			s.Brackets = append(s.Brackets, BracketCount{Level: count.Level})
+		}
+		s.Brackets[b.brackets[count.Level]].People += count.People
+	}
+	b.rates += other.rates
+	b.rated += other.rated
+
+	if o.Succeeded == 0 {
+		return
+	}
+	if succeeded == 0 || o.Tax.Min < s.Tax.Min {
+		s.Tax.Min = o.Tax.Min
+	}
+	if succeeded == 0 || o.Tax.Max > s.Tax.Max {
+		s.Tax.Max = o.Tax.Max
+	}
+	for i, tax := range other.sample {
+		if len(b.sample) < summarySample {
+			b.sample = append(b.sample, tax)
+		} else if j := b.random.Intn(succeeded + i + 1); j < summarySample {
+			b.sample[j] = tax
+		}
+	}
+}
+
+func (b *summaryBuilder) build() BatchSummary {
+	s := b.summary
+	s.TotalIncome = round2(s.TotalIncome)
+	s.TotalTax = round2(s.TotalTax)
+	s.TotalRefund = round2(s.TotalRefund)
+	if b.rated > 0 {
+		s.AverageEffectiveRate = math.Round(b.rates/float64(b.rated)*10000) / 10000
+	}
+
+	sorted := append([]float64(nil), b.sample...)
+	sort.Float64s(sorted)
+	s.Tax.P50 = percentile(sorted, 50)
+	s.Tax.P90 = percentile(sorted, 90)
+	s.Tax.P99 = percentile(sorted, 99)
+	return s
+}
+
+// bracket is the highest tier a person pays tax in, or the first tier when
+// they pay none.
+func bracket(levels []TaxLevel) (string, bool) {
+	if len(levels) == 0 {
+		return "", false
+	}
+	level := levels[0].Level
+	for _, l := range levels {
+		if l.Tax > 0 {
+			level = l.Level
+		}
+	}
+	return level, true
+}
+
+// percentile uses the nearest-rank method on sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
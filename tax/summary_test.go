@@ -0,0 +1,96 @@
+// go:build unit
+
+package tax
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func tierLevels(taxes ...float64) []TaxLevel {
+	labels := []string{"0-150,000", "150,001-500,000", "500,001-1,000,000"}
+	result := make([]TaxLevel, len(taxes))
+	for i, tax := range taxes {
+		result[i] = TaxLevel{Level: labels[i], Tax: tax}
+	}
+	return result
+}
+
+func TestSummaryBuilder(t *testing.T) {
+	t.Run("given results and row errors should total them and count people per bracket", func(t *testing.T) {
+		builder := newSummaryBuilder()
+		builder.add(rowOutput{result: TaxResponseCSV{TotalIncome: 100000}, levels: tierLevels(0, 0, 0)})
+		builder.add(rowOutput{result: TaxResponseCSV{TotalIncome: 500000, Tax: 29000}, levels: tierLevels(0, 35000, 0)})
+		builder.add(rowOutput{err: RowError{Line: 4, Message: "totalIncome must be a numeric value"}})
+		builder.add(rowOutput{result: TaxResponseCSV{TotalIncome: 600000, TaxRefund: 5000}, levels: tierLevels(0, 35000, 15000)})
+
+		summary := builder.build()
+
+		assert.Equal(t, BatchSummary{
+			Rows:        4,
+			Succeeded:   3,
+			Failed:      1,
+			TotalIncome: 1200000,
+			TotalTax:    29000,
+			TotalRefund: 5000,
+			Brackets: []BracketCount{
+				{Level: "0-150,000", People: 1},
+				{Level: "150,001-500,000", People: 1},
+				{Level: "500,001-1,000,000", People: 1},
+			},
+			AverageEffectiveRate: 0.0511,
+			Tax:                  TaxStats{Min: 0, Max: 29000, P50: 0, P90: 29000, P99: 29000},
+		}, summary)
+	})
+	t.Run("given no rows should return zeros", func(t *testing.T) {
+		summary := newSummaryBuilder().build()
+
+		assert.Equal(t, BatchSummary{Brackets: []BracketCount{}}, summary)
+	})
+	t.Run("given more rows than the sample should keep min and max exact", func(t *testing.T) {
+		builder := newSummaryBuilder()
+		for i := 1; i <= summarySample*3; i++ {
+			builder.add(rowOutput{result: TaxResponseCSV{TotalIncome: 1000, Tax: float64(i)}})
+		}
+
+		summary := builder.build()
+
+		assert.Equal(t, float64(1), summary.Tax.Min)
+		assert.Equal(t, float64(summarySample*3), summary.Tax.Max)
+		assert.InDelta(t, summarySample*1.5, summary.Tax.P50, summarySample*0.1)
+		assert.Len(t, builder.sample, summarySample)
+	})
+	t.Run("given builders merged should match one builder that saw every row", func(t *testing.T) {
+		rows := []rowOutput{
+			{result: TaxResponseCSV{TotalIncome: 100000}, levels: tierLevels(0, 0, 0)},
+			{result: TaxResponseCSV{TotalIncome: 500000, Tax: 29000}, levels: tierLevels(0, 35000, 0)},
+			{err: RowError{Line: 4, Message: "totalIncome must be a numeric value"}},
+			{result: TaxResponseCSV{TotalIncome: 600000, TaxRefund: 5000}, levels: tierLevels(0, 35000, 15000)},
+		}
+		whole, first, second := newSummaryBuilder(), newSummaryBuilder(), newSummaryBuilder()
+		for i, row := range rows {
+			whole.add(row)
+			if i < 2 {
+				first.add(row)
+			} else {
+				second.add(row)
+			}
+		}
+
+		merged := newSummaryBuilder()
+		merged.merge(first)
+		merged.merge(second)
+
+		assert.Equal(t, whole.build(), merged.build())
+	})
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := []float64{10, 20, 30, 40, 50, 60, 70, 80, 90, 100}
+
+	assert.Equal(t, float64(50), percentile(sorted, 50))
+	assert.Equal(t, float64(90), percentile(sorted, 90))
+	assert.Equal(t, float64(100), percentile(sorted, 99))
+	assert.Equal(t, float64(0), percentile(nil, 50))
+}
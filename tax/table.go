@@ -0,0 +1,195 @@
+package tax
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+)
+
+type tableCell struct {
+	text    string
+	number  float64
+	numeric bool
+}
+
+func textCell(text string) tableCell {
+	return tableCell{text: text}
+}
+
+func numberCell(number float64) tableCell {
+	return tableCell{number: number, numeric: true}
+}
+
+type tableWriter interface {
+	writeRow(cells []tableCell) error
+	close() error
+}
+
+// tableSink writes results as a spreadsheet: the input columns followed by
+// tax, taxRefund, one column per tax tier and the row error, if any. The
+// tier columns are named after the levels of the first result, so rows that
+// fail before it are held back until the header is known.
+type tableSink struct {
+	stream
+	newTable func(w io.Writer) (tableWriter, error)
+	table    tableWriter
+	input    []string
+	levels   []string
+	pending  []rowOutput
+}
+
+func (s *tableSink) columns(columns []string) error {
+	s.input = append([]string(nil), columns...)
+	return nil
+}
+
+func (s *tableSink) result(row rowOutput) error {
+	if s.table == nil {
+		for _, level := range row.levels {
+			s.levels = append(s.levels, level.Level)
+		}
+		if err := s.begin(); err != nil {
+			return err
+		}
+	}
+	return s.table.writeRow(s.cells(row))
+}
+
+func (s *tableSink) rowError(row rowOutput) error {
+	if s.table == nil {
+		s.pending = append(s.pending, row)
+		return nil
+	}
+	return s.table.writeRow(s.cells(row))
+}
+
+// summary is left out of tables so every row below the header stays a row of
+// the input.
+func (s *tableSink) summary(summary BatchSummary) error {
+	return nil
+}
+
+func (s *tableSink) close() error {
+	if s.table == nil {
+		if err := s.begin(); err != nil {
+			return err
+		}
+	}
+	if err := s.table.close(); err != nil {
+		return err
+	}
+	s.res.Flush()
+	return nil
+}
+
+func (s *tableSink) fail(errFile Err, rowErrors []RowError) error {
+	if len(rowErrors) == 0 {
+		rowErrors = []RowError{{Message: errFile.Message, Text: errFile.text}}
+	}
+	for _, rowErr := range rowErrors {
+		if err := s.rowError(rowOutput{err: rowErr}); err != nil {
+			return err
+		}
+	}
+	return s.close()
+}
+
+func (s *tableSink) begin() error {
+	table, err := s.newTable(&s.stream)
+	if err != nil {
+		return err
+	}
+	s.table = table
+
+	header := make([]tableCell, 0, len(s.input)+len(s.levels)+3)
+	for _, column := range s.input {
+		header = append(header, textCell(column))
+	}
+	header = append(header, textCell("tax"), textCell("taxRefund"))
+	for _, level := range s.levels {
+		header = append(header, textCell(levelLabel(s.lang, level)))
+	}
+	header = append(header, textCell("error"))
+	if err := s.table.writeRow(header); err != nil {
+		return err
+	}
+
+	for _, row := range s.pending {
+		if err := s.table.writeRow(s.cells(row)); err != nil {
+			return err
+		}
+	}
+	s.pending = nil
+	return nil
+}
+
+func (s *tableSink) cells(row rowOutput) []tableCell {
+	cells := make([]tableCell, 0, len(s.input)+len(s.levels)+3)
+	for i, column := range s.input {
+		var value string
+		if i < len(row.record) {
+			value = row.record[i]
+		}
+		cells = append(cells, inputCell(column, value))
+	}
+
+	if row.err.Message != "" {
+		for i := 0; i < len(s.levels)+2; i++ {
+			cells = append(cells, textCell(""))
+		}
+		return append(cells, textCell(row.err.messageIn(s.lang)))
+	}
+
+	cells = append(cells, numberCell(row.result.Tax), numberCell(row.result.TaxRefund))
+	for i := range s.levels {
+		if i < len(row.levels) {
+			cells = append(cells, numberCell(row.levels[i].Tax))
+		} else {
+			cells = append(cells, textCell(""))
+		}
+	}
+	return append(cells, textCell(""))
+}
+
+// inputCell keeps amounts numeric in the spreadsheet. Identifiers and
+// passthrough columns stay text so leading zeros survive.
+func inputCell(column, value string) tableCell {
+	if column != columnTotalIncome && column != columnWHT && !allowanceTypes[column] {
+		return textCell(value)
+	}
+	if amount, err := parseAmount(value); err == nil {
+		return numberCell(amount)
+	}
+	return textCell(value)
+}
+
+type csvTable struct {
+	w *csv.Writer
+}
+
+// utf8BOM lets Excel open the file as UTF-8 so Thai tier labels display.
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+func newCSVTable(w io.Writer) (tableWriter, error) {
+	if _, err := w.Write(utf8BOM); err != nil {
+		return nil, err
+	}
+	return &csvTable{w: csv.NewWriter(w)}, nil
+}
+
+func (t *csvTable) writeRow(cells []tableCell) error {
+	record := make([]string, len(cells))
+	for i, cell := range cells {
+		if cell.numeric {
+			record[i] = strconv.FormatFloat(cell.number, 'f', -1, 64)
+		} else {
+			record[i] = cell.text
+		}
+	}
+	return t.w.Write(record)
+}
+
+func (t *csvTable) close() error {
+	t.w.Flush()
+	return t.w.Error()
+}
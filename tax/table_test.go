@@ -0,0 +1,157 @@
+// go:build unit
+
+package tax
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+var tableStubTax = Tax{Tax: 100.0, TaxLevel: []TaxLevel{{Level: "0-150,000", Tax: 0.0}, {Level: "150,001-500,000", Tax: 100.0}}}
+
+func TestCalculateTaxCSVHandlerFormats(t *testing.T) {
+	t.Run("given format csv should return input columns with tax and tier columns", func(t *testing.T) {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		c := e.NewContext(uploadRequest("/tax/calculations/upload-csv?format=csv&mode=lenient", "totalIncome,wht,employeeId\ninvalid,0,\n160000,0,0105556012341"), rec)
+
+		p := New(&StubTax{calculateTax: tableStubTax})
+
+		err := serve(c, p.CalculateTaxCSVHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusOK, rec.Code, "expected status code %d but got %d", http.StatusOK, rec.Code)
+		assert.Equal(t, "text/csv; charset=utf-8", rec.Header().Get(echo.HeaderContentType))
+		assert.Equal(t, "\xef\xbb\xbf"+
+			"totalIncome,wht,employeeId,tax,taxRefund,\"0-150,000\",\"150,001-500,000\",error\n"+
+			"invalid,0,,,,,,totalIncome must be a numeric value\n"+
+			"160000,0,0105556012341,100,0,0,100,\n", rec.Body.String())
+	})
+	t.Run("given an id column should keep its leading zeros", func(t *testing.T) {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		req := uploadRequest("/tax/calculations/upload-csv?format=xlsx", "id,totalIncome,wht\n007,160000,0")
+		c := e.NewContext(req, rec)
+
+		p := New(&StubTax{calculateTax: tableStubTax})
+
+		err := serve(c, p.CalculateTaxCSVHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		sheet := readZipEntry(t, rec.Body.Bytes(), "xl/worksheets/sheet1.xml")
+		assert.Contains(t, sheet, `<row r="2"><c r="A2" t="inlineStr"><is><t xml:space="preserve">007</t></is></c><c r="B2"><v>160000</v></c>`)
+	})
+	t.Run("given accept xlsx should return a workbook", func(t *testing.T) {
+		e := echo.New()
+		req := uploadRequest("/tax/calculations/upload-csv", "totalIncome,wht\n160000,0")
+		req.Header.Set(echo.HeaderAccept, MIMEApplicationXLSX)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		p := New(&StubTax{calculateTax: tableStubTax})
+
+		err := serve(c, p.CalculateTaxCSVHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusOK, rec.Code, "expected status code %d but got %d", http.StatusOK, rec.Code)
+		assert.Equal(t, MIMEApplicationXLSX, rec.Header().Get(echo.HeaderContentType))
+		assert.Equal(t, `attachment; filename="taxes.xlsx"`, rec.Header().Get(echo.HeaderContentDisposition))
+
+		sheet := readZipEntry(t, rec.Body.Bytes(), "xl/worksheets/sheet1.xml")
+		assert.Contains(t, sheet, `<row r="1"><c r="A1" t="inlineStr"><is><t xml:space="preserve">totalIncome</t></is></c>`)
+		assert.Contains(t, sheet, `<row r="2"><c r="A2"><v>160000</v></c><c r="B2"><v>0</v></c><c r="C2"><v>100</v></c><c r="D2"><v>0</v></c><c r="E2"><v>0</v></c><c r="F2"><v>100</v></c></row>`)
+	})
+	t.Run("given invalid file with format csv should still return status 400", func(t *testing.T) {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		c := e.NewContext(uploadRequest("/tax/calculations/upload-csv?format=csv", "totalIncome,wht\ninvalid,0"), rec)
+
+		p := New(&StubTax{})
+
+		err := serve(c, p.CalculateTaxCSVHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
+	})
+	t.Run("given unknown format should return status 400 and error message", func(t *testing.T) {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		c := e.NewContext(uploadRequest("/tax/calculations/upload-csv?format=pdf", "totalIncome,wht\n1000,0"), rec)
+
+		p := New(&StubTax{})
+
+		err := serve(c, p.CalculateTaxCSVHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
+		assert.Equal(t, `{"type":"about:blank","title":"Bad Request","status":400,"detail":"format must be json, ndjson, csv or xlsx","instance":"/tax/calculations/upload-csv","message":"format must be json, ndjson, csv or xlsx"}`, strings.TrimSuffix(rec.Body.String(), "\n"))
+	})
+}
+
+func TestGetJobResultFormats(t *testing.T) {
+	t.Run("given completed job and format csv should return the result as csv", func(t *testing.T) {
+		jobs := &StubJobs{}
+		p := New(&StubTax{calculateTax: tableStubTax}).WithJobs(jobs).WithWorkers(1)
+		job, _ := jobs.CreateJob(Job{Status: JobStatusQueued, Mode: ModeStrict}, strings.NewReader("totalIncome,wht\n160000,0\n"))
+		p.runJob(job)
+
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodGet, "/tax/jobs/"+job.ID+"/result", nil)
+		req.Header.Set(echo.HeaderAccept, MIMETextCSV)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("jobId")
+		c.SetParamValues(job.ID)
+
+		err := serve(c, p.GetJobResultHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusOK, rec.Code, "expected status code %d but got %d", http.StatusOK, rec.Code)
+		assert.Equal(t, "\xef\xbb\xbf"+
+			"totalIncome,wht,tax,taxRefund,\"0-150,000\",\"150,001-500,000\",error\n"+
+			"160000,0,100,0,0,100,\n", rec.Body.String())
+	})
+}
+
+func TestColumnName(t *testing.T) {
+	assert.Equal(t, "A", columnName(0))
+	assert.Equal(t, "Z", columnName(25))
+	assert.Equal(t, "AA", columnName(26))
+	assert.Equal(t, "AZ", columnName(51))
+	assert.Equal(t, "BA", columnName(52))
+}
+
+func TestInputCell(t *testing.T) {
+	assert.Equal(t, numberCell(1500000), inputCell(columnTotalIncome, "1,500,000.00"))
+	assert.Equal(t, textCell("NaN"), inputCell(columnTotalIncome, "NaN"))
+	assert.Equal(t, textCell("Inf"), inputCell(columnWHT, "Inf"))
+	assert.Equal(t, textCell("0x1p19"), inputCell("donation", "0x1p19"))
+	assert.Equal(t, textCell("0012"), inputCell(columnEmployeeID, "0012"))
+}
+
+func readZipEntry(t *testing.T, data []byte, name string) string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	assert.NoError(t, err)
+	for _, f := range zr.File {
+		if f.Name != name {
+			continue
+		}
+		rc, err := f.Open()
+		assert.NoError(t, err)
+		defer rc.Close()
+		b, err := io.ReadAll(rc)
+		assert.NoError(t, err)
+		return string(b)
+	}
+	t.Fatalf("zip entry %s not found", name)
+	return ""
+}
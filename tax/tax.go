@@ -1,6 +1,9 @@
 package tax
 
+import "time"
+
 type UserInfo struct {
+	TaxpayerID  string       `json:"taxpayerId,omitempty"`
 	TotalIncome float64      `json:"totalIncome"`
 	WHT         float64      `json:"wht"`
 	Allowances  []Allowances `json:"allowances"`
@@ -34,8 +37,260 @@ type KReceiptResponse struct {
 	KReceipt float64 `json:"kReceipt"`
 }
 
+const (
+	ModeStrict  = "strict"
+	ModeLenient = "lenient"
+)
+
+type RowError struct {
+	Line    int    `json:"line"`
+	Column  string `json:"column,omitempty"`
+	Cell    string `json:"cell,omitempty"`
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message"`
+	RowLabel
+	// Text is the catalog entry Message was rendered from. It is kept with
+	// a job's rows so stored errors can be written in another language.
+	Text Text `json:"-"`
+}
+
+// RowLabel echoes the identifier and passthrough columns of an uploaded row
+// so results and errors can be joined back to the source system.
+type RowLabel struct {
+	ID          string            `json:"id,omitempty"`
+	EmployeeID  string            `json:"employeeId,omitempty"`
+	Passthrough map[string]string `json:"passthrough,omitempty"`
+}
+
+type BatchCalculationResponse struct {
+	Succeeded int                      `json:"succeeded"`
+	Failed    int                      `json:"failed"`
+	Results   []BatchCalculationResult `json:"results"`
+}
+
+type BatchCalculationResult struct {
+	Index  int  `json:"index"`
+	Status int  `json:"status"`
+	Tax    *Tax `json:"tax,omitempty"`
+	Error  *Err `json:"error,omitempty"`
+}
+
+type TaxCSVResponse struct {
+	Taxes   []TaxResponseCSV `json:"taxes"`
+	Errors  []RowError       `json:"errors,omitempty"`
+	Summary *BatchSummary    `json:"summary,omitempty"`
+}
+
+type FileResult struct {
+	File    string           `json:"file"`
+	Taxes   []TaxResponseCSV `json:"taxes"`
+	Errors  []RowError       `json:"errors,omitempty"`
+	Summary *BatchSummary    `json:"summary,omitempty"`
+	Message string           `json:"message,omitempty"`
+}
+
+type MultiFileResponse struct {
+	Files   []FileResult `json:"files"`
+	Summary BatchSummary `json:"summary"`
+}
+
+type ValidationReport struct {
+	Valid     bool       `json:"valid"`
+	Rows      int        `json:"rows"`
+	ValidRows int        `json:"validRows"`
+	Columns   []string   `json:"columns"`
+	Errors    []RowError `json:"errors"`
+	Warnings  []RowError `json:"warnings"`
+}
+
 type TaxResponseCSV struct {
 	TotalIncome float64 `json:"totalIncome"`
 	Tax         float64 `json:"tax"`
 	TaxRefund   float64 `json:"taxRefund,omitempty"`
+	RowLabel
+}
+
+const DefaultTaxYear = 2567
+
+type Settings struct {
+	PersonalDeduction float64 `json:"personalDeduction"`
+	KReceipt          float64 `json:"kReceipt"`
+}
+
+type Calculation struct {
+	ID         int64     `json:"id"`
+	TaxpayerID string    `json:"taxpayerId"`
+	TaxYear    int       `json:"taxYear"`
+	UserInfo   UserInfo  `json:"userInfo"`
+	Tax        Tax       `json:"result"`
+	Settings   Settings  `json:"settings"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+type CalculationFilter struct {
+	TaxpayerID string
+	TaxYear    int
+	Page       int
+	PageSize   int
+}
+
+type CalculationPage struct {
+	Calculations []Calculation `json:"calculations"`
+	Page         int           `json:"page"`
+	PageSize     int           `json:"pageSize"`
+	Total        int           `json:"total"`
+}
+
+const (
+	FilingStatusSingle          = "single"
+	FilingStatusMarriedJoint    = "married-joint"
+	FilingStatusMarriedSeparate = "married-separate"
+)
+
+type Taxpayer struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	Address      string    `json:"address"`
+	FilingStatus string    `json:"filingStatus"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+const (
+	RefundStatusSubmitted   = "submitted"
+	RefundStatusUnderReview = "under_review"
+	RefundStatusApproved    = "approved"
+	RefundStatusPaid        = "paid"
+	RefundStatusRejected    = "rejected"
+)
+
+type BankAccount struct {
+	BankCode      string `json:"bankCode"`
+	AccountNumber string `json:"accountNumber"`
+	AccountName   string `json:"accountName"`
+}
+
+type RefundClaim struct {
+	ID            int64              `json:"id"`
+	CalculationID int64              `json:"calculationId"`
+	TaxpayerID    string             `json:"taxpayerId"`
+	Amount        float64            `json:"amount"`
+	BankAccount   BankAccount        `json:"bankAccount"`
+	Status        string             `json:"status"`
+	Transitions   []RefundTransition `json:"transitions"`
+	CreatedAt     time.Time          `json:"createdAt"`
+}
+
+type RefundTransition struct {
+	From      string    `json:"from,omitempty"`
+	To        string    `json:"to"`
+	Actor     string    `json:"actor"`
+	Note      string    `json:"note,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type RefundClaimRequest struct {
+	BankAccount BankAccount `json:"bankAccount"`
+}
+
+type RefundTransitionRequest struct {
+	Status string `json:"status"`
+	Note   string `json:"note"`
+}
+
+type Payment struct {
+	ID            int64     `json:"id"`
+	CalculationID int64     `json:"calculationId"`
+	Amount        float64   `json:"amount"`
+	Reference     string    `json:"reference,omitempty"`
+	PaidAt        time.Time `json:"paidAt"`
+}
+
+type Instalment struct {
+	Number      int       `json:"number"`
+	DueDate     time.Time `json:"dueDate"`
+	Amount      float64   `json:"amount"`
+	Paid        float64   `json:"paid"`
+	Outstanding float64   `json:"outstanding"`
+	Overdue     bool      `json:"overdue"`
+}
+
+type Ledger struct {
+	CalculationID int64        `json:"calculationId"`
+	TaxDue        float64      `json:"taxDue"`
+	Instalments   int          `json:"instalments"`
+	Schedule      []Instalment `json:"schedule"`
+	TotalPaid     float64      `json:"totalPaid"`
+	Outstanding   float64      `json:"outstanding"`
+	Overdue       bool         `json:"overdue"`
+	Payments      []Payment    `json:"payments"`
+}
+
+type InstalmentPlanRequest struct {
+	Instalments int `json:"instalments"`
+}
+
+type WHTCertificate struct {
+	ID          int64   `json:"id"`
+	TaxpayerID  string  `json:"taxpayerId"`
+	TaxYear     int     `json:"taxYear"`
+	PayerTaxID  string  `json:"payerTaxId"`
+	IncomeType  string  `json:"incomeType"`
+	AmountPaid  float64 `json:"amountPaid"`
+	TaxWithheld float64 `json:"taxWithheld"`
+	Date        string  `json:"date"`
+}
+
+type CertificateImportResponse struct {
+	Imported   []WHTCertificate `json:"imported"`
+	Duplicates []WHTCertificate `json:"duplicates"`
+}
+
+const (
+	JobStatusQueued    = "queued"
+	JobStatusRunning   = "running"
+	JobStatusCompleted = "completed"
+	JobStatusFailed    = "failed"
+)
+
+// IdempotentResponse is a response kept under an Idempotency-Key. Status is
+// zero while the first request is still being handled. Scope is the route the
+// key was sent to. Oversized is set, and Body left empty, when the response
+// was too large to keep.
+type IdempotentResponse struct {
+	Scope     string
+	Key       string
+	Hash      string
+	Status    int
+	Header    map[string]string
+	Body      []byte
+	Oversized bool
+	CreatedAt time.Time
+}
+
+type Job struct {
+	ID          string     `json:"id"`
+	Status      string     `json:"status"`
+	Mode        string     `json:"mode"`
+	Sheet       string     `json:"sheet,omitempty"`
+	Encoding    string     `json:"encoding,omitempty"`
+	Delimiter   string     `json:"delimiter,omitempty"`
+	Passthrough []string   `json:"passthrough,omitempty"`
+	Processed   int        `json:"processed"`
+	Succeeded   int        `json:"succeeded"`
+	Failed      int        `json:"failed"`
+	Errors      []RowError `json:"errors"`
+	Message     string     `json:"message,omitempty"`
+	MessageText Text       `json:"-"`
+	Claim       string     `json:"-"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+}
+
+// JobErrorPage is one page of the row errors of a job. Total is the number of
+// rows that failed.
+type JobErrorPage struct {
+	Errors   []RowError `json:"errors"`
+	Page     int        `json:"page"`
+	PageSize int        `json:"pageSize"`
+	Total    int        `json:"total"`
 }
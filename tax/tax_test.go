@@ -18,16 +18,30 @@ import (
 )
 
 type StubTax struct {
+	userInfo                 UserInfo
 	calculateTax             Tax
+	settings                 Settings
+	settingsCalls            int
 	settingPersonalDeduction float64
 	settingMaxKReceipt       float64
 	err                      error
 }
 
 func (s *StubTax) CalculateTax(userInfo UserInfo) (Tax, error) {
+	s.userInfo = userInfo
 	return s.calculateTax, s.err
 }
 
+func (s *StubTax) CalculateTaxWithSettings(userInfo UserInfo, settings Settings) (Tax, error) {
+	s.userInfo = userInfo
+	return s.calculateTax, s.err
+}
+
+func (s *StubTax) Settings() (Settings, error) {
+	s.settingsCalls++
+	return s.settings, nil
+}
+
 func (s *StubTax) SettingPersonalDeduction(setting Setting) (float64, error) {
 	return s.settingPersonalDeduction, s.err
 }
@@ -45,12 +59,12 @@ func TestCalculateTax(t *testing.T) {
 		c := e.NewContext(req, rec)
 		c.SetPath("/tax/calculations")
 
-		want := `{ "message": "failed to calculate tax" }`
+		want := `{ "type": "about:blank", "title": "Internal Server Error", "status": 500, "detail": "failed to calculate tax", "instance": "/tax/calculations", "message": "failed to calculate tax" }`
 
 		stubTax := StubTax{err: errors.New("failed to calculate tax")}
 		p := New(&stubTax)
 
-		err := p.CalculateTaxHandler(c)
+		err := serve(c, p.CalculateTaxHandler)
 
 		assert.NoError(t, err, "expected no error but got %v", err)
 		assert.Equal(t, http.StatusInternalServerError, rec.Code, "expected status code %d but got %d", http.StatusInternalServerError, rec.Code)
@@ -69,7 +83,7 @@ func TestCalculateTax(t *testing.T) {
 		stubTax := StubTax{calculateTax: want}
 		p := New(&stubTax)
 
-		err := p.CalculateTaxHandler(c)
+		err := serve(c, p.CalculateTaxHandler)
 
 		assert.NoError(t, err, "expected no error but got %v", err)
 		assert.Equal(t, http.StatusOK, rec.Code, "expected status code %d but got %d", http.StatusOK, rec.Code)
@@ -87,12 +101,12 @@ func TestCalculateTax(t *testing.T) {
 		c := e.NewContext(req, rec)
 		c.SetPath("/tax/calculations")
 
-		want := `{ "message": "invalid request body" }`
+		want := `{ "type": "about:blank", "title": "Bad Request", "status": 400, "detail": "invalid request body", "instance": "/tax/calculations", "message": "invalid request body" }`
 
 		stubTax := StubTax{}
 		p := New(&stubTax)
 
-		err := p.CalculateTaxHandler(c)
+		err := serve(c, p.CalculateTaxHandler)
 
 		assert.NoError(t, err, "expected no error but got %v", err)
 		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
@@ -106,12 +120,12 @@ func TestCalculateTax(t *testing.T) {
 		c := e.NewContext(req, rec)
 		c.SetPath("/tax/calculations")
 
-		want := `{ "message": "invalid allowance type" }`
+		want := `{ "type": "about:blank", "title": "Bad Request", "status": 400, "detail": "invalid allowance type", "instance": "/tax/calculations", "message": "invalid allowance type", "errors": [{"code": "ALLOWANCE_TYPE_INVALID", "path": "allowances[0].allowanceType", "value": "invalid", "message": "invalid allowance type"}] }`
 
 		stubTax := StubTax{}
 		p := New(&stubTax)
 
-		err := p.CalculateTaxHandler(c)
+		err := serve(c, p.CalculateTaxHandler)
 
 		assert.NoError(t, err, "expected no error but got %v", err)
 		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
@@ -125,12 +139,12 @@ func TestCalculateTax(t *testing.T) {
 		c := e.NewContext(req, rec)
 		c.SetPath("/tax/calculations")
 
-		want := `{ "message": "total income is required" }`
+		want := `{ "type": "about:blank", "title": "Bad Request", "status": 400, "detail": "total income is required", "instance": "/tax/calculations", "message": "total income is required", "errors": [{"code": "TOTAL_INCOME_REQUIRED", "path": "totalIncome", "value": 0, "message": "total income is required"}] }`
 
 		stubTax := StubTax{}
 		p := New(&stubTax)
 
-		err := p.CalculateTaxHandler(c)
+		err := serve(c, p.CalculateTaxHandler)
 
 		assert.NoError(t, err, "expected no error but got %v", err)
 		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
@@ -144,12 +158,12 @@ func TestCalculateTax(t *testing.T) {
 		c := e.NewContext(req, rec)
 		c.SetPath("/tax/calculations")
 
-		want := `{ "message": "total income must be greater than 0.0" }`
+		want := `{ "type": "about:blank", "title": "Bad Request", "status": 400, "detail": "total income must be greater than 0.0", "instance": "/tax/calculations", "message": "total income must be greater than 0.0", "errors": [{"code": "TOTAL_INCOME_NEGATIVE", "path": "totalIncome", "value": -1000, "message": "total income must be greater than 0.0"}] }`
 
 		stubTax := StubTax{}
 		p := New(&stubTax)
 
-		err := p.CalculateTaxHandler(c)
+		err := serve(c, p.CalculateTaxHandler)
 
 		assert.NoError(t, err, "expected no error but got %v", err)
 		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
@@ -163,12 +177,12 @@ func TestCalculateTax(t *testing.T) {
 		c := e.NewContext(req, rec)
 		c.SetPath("/tax/calculations")
 
-		want := `{ "message": "wht must be greater than or equal to 0.0" }`
+		want := `{ "type": "about:blank", "title": "Bad Request", "status": 400, "detail": "wht must be greater than or equal to 0.0", "instance": "/tax/calculations", "message": "wht must be greater than or equal to 0.0", "errors": [{"code": "WHT_NEGATIVE", "path": "wht", "value": -1000, "message": "wht must be greater than or equal to 0.0"}] }`
 
 		stubTax := StubTax{}
 		p := New(&stubTax)
 
-		err := p.CalculateTaxHandler(c)
+		err := serve(c, p.CalculateTaxHandler)
 
 		assert.NoError(t, err, "expected no error but got %v", err)
 		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
@@ -182,12 +196,12 @@ func TestCalculateTax(t *testing.T) {
 		c := e.NewContext(req, rec)
 		c.SetPath("/tax/calculations")
 
-		want := `{ "message": "wht must be less than or equal to total income" }`
+		want := `{ "type": "about:blank", "title": "Bad Request", "status": 400, "detail": "wht must be less than or equal to total income", "instance": "/tax/calculations", "message": "wht must be less than or equal to total income", "errors": [{"code": "WHT_EXCEEDS_INCOME", "path": "wht", "value": 6000000, "message": "wht must be less than or equal to total income"}] }`
 
 		stubTax := StubTax{}
 		p := New(&stubTax)
 
-		err := p.CalculateTaxHandler(c)
+		err := serve(c, p.CalculateTaxHandler)
 
 		assert.NoError(t, err, "expected no error but got %v", err)
 		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
@@ -201,12 +215,12 @@ func TestCalculateTax(t *testing.T) {
 		c := e.NewContext(req, rec)
 		c.SetPath("/tax/calculations")
 
-		want := `{ "message": "missing allowanceType key" }`
+		want := `{ "type": "about:blank", "title": "Bad Request", "status": 400, "detail": "missing allowanceType key", "instance": "/tax/calculations", "message": "missing allowanceType key", "errors": [{"code": "ALLOWANCE_TYPE_MISSING", "path": "allowances[0].allowanceType", "value": "", "message": "missing allowanceType key"}] }`
 
 		stubTax := StubTax{}
 		p := New(&stubTax)
 
-		err := p.CalculateTaxHandler(c)
+		err := serve(c, p.CalculateTaxHandler)
 
 		assert.NoError(t, err, "expected no error but got %v", err)
 		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
@@ -220,12 +234,12 @@ func TestCalculateTax(t *testing.T) {
 		c := e.NewContext(req, rec)
 		c.SetPath("/tax/calculations")
 
-		want := `{ "message": "allowance amount must be greater than or equal to 0.0" }`
+		want := `{ "type": "about:blank", "title": "Bad Request", "status": 400, "detail": "allowance amount must be greater than or equal to 0.0", "instance": "/tax/calculations", "message": "allowance amount must be greater than or equal to 0.0", "errors": [{"code": "ALLOWANCE_AMOUNT_NEGATIVE", "path": "allowances[0].amount", "value": -1000, "message": "allowance amount must be greater than or equal to 0.0"}] }`
 
 		stubTax := StubTax{}
 		p := New(&stubTax)
 
-		err := p.CalculateTaxHandler(c)
+		err := serve(c, p.CalculateTaxHandler)
 
 		assert.NoError(t, err, "expected no error but got %v", err)
 		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
@@ -244,7 +258,7 @@ func TestCalculateTax(t *testing.T) {
 		stubTax := StubTax{calculateTax: Tax{Tax: -1000.0}}
 		p := New(&stubTax)
 
-		err := p.CalculateTaxHandler(c)
+		err := serve(c, p.CalculateTaxHandler)
 
 		assert.NoError(t, err, "expected no error but got %v", err)
 		assert.Equal(t, http.StatusOK, rec.Code, "expected status code %d but got %d", http.StatusOK, rec.Code)
@@ -262,12 +276,12 @@ func TestCalculateTax(t *testing.T) {
 		c := e.NewContext(req, rec)
 		c.SetPath("/tax/calculations")
 
-		want := `{ "message": "user can not fill personal allowance" }`
+		want := `{ "type": "about:blank", "title": "Bad Request", "status": 400, "detail": "user can not fill personal allowance", "instance": "/tax/calculations", "message": "user can not fill personal allowance", "errors": [{"code": "PERSONAL_ALLOWANCE_FORBIDDEN", "path": "allowances[0].allowanceType", "value": "personal", "message": "user can not fill personal allowance"}] }`
 
 		stubTax := StubTax{}
 		p := New(&stubTax)
 
-		err := p.CalculateTaxHandler(c)
+		err := serve(c, p.CalculateTaxHandler)
 
 		assert.NoError(t, err, "expected no error but got %v", err)
 		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
@@ -281,12 +295,35 @@ func TestCalculateTax(t *testing.T) {
 		c := e.NewContext(req, rec)
 		c.SetPath("/tax/calculations")
 
-		want := `{ "message": "k-receipt amount must be greater than or equal to 0.0" }`
+		want := `{ "type": "about:blank", "title": "Bad Request", "status": 400, "detail": "k-receipt amount must be greater than or equal to 0.0", "instance": "/tax/calculations", "message": "k-receipt amount must be greater than or equal to 0.0", "errors": [{"code": "K_RECEIPT_AMOUNT_NEGATIVE", "path": "allowances[0].amount", "value": -1000, "message": "k-receipt amount must be greater than or equal to 0.0"}] }`
+
+		stubTax := StubTax{}
+		p := New(&stubTax)
+
+		err := serve(c, p.CalculateTaxHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
+		assert.JSONEq(t, want, rec.Body.String(), "expected response body %s but got %s", want, rec.Body.String())
+	})
+	t.Run("given several invalid fields should return status 400 and every error with its code and path", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/tax/calculations", io.NopCloser(strings.NewReader(`{"totalIncome": 500000.0, "wht": -1.0, "allowances": [{"allowanceType": "donation", "amount": 100.0}, {"allowanceType": "bonus", "amount": 0.0}, {"allowanceType": "k-receipt", "amount": -5.0}]}`)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/tax/calculations")
+
+		want := `{"type": "about:blank", "title": "Bad Request", "status": 400, "detail": "wht must be greater than or equal to 0.0", "instance": "/tax/calculations", "message": "wht must be greater than or equal to 0.0", "errors": [
+			{"code": "WHT_NEGATIVE", "path": "wht", "value": -1, "message": "wht must be greater than or equal to 0.0"},
+			{"code": "ALLOWANCE_TYPE_INVALID", "path": "allowances[1].allowanceType", "value": "bonus", "message": "invalid allowance type"},
+			{"code": "K_RECEIPT_AMOUNT_NEGATIVE", "path": "allowances[2].amount", "value": -5, "message": "k-receipt amount must be greater than or equal to 0.0"}
+		] }`
 
 		stubTax := StubTax{}
 		p := New(&stubTax)
 
-		err := p.CalculateTaxHandler(c)
+		err := serve(c, p.CalculateTaxHandler)
 
 		assert.NoError(t, err, "expected no error but got %v", err)
 		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
@@ -302,12 +339,12 @@ func TestSettingPersonalDeduction(t *testing.T) {
 		c := e.NewContext(req, rec)
 		c.SetPath("/admin/deductions/personal")
 
-		want := `{ "message": "failed to set personal deduction" }`
+		want := `{ "type": "about:blank", "title": "Internal Server Error", "status": 500, "detail": "failed to set personal deduction", "instance": "/admin/deductions/personal", "message": "failed to set personal deduction" }`
 
 		stubTax := StubTax{err: errors.New("failed to set personal deduction")}
 		p := New(&stubTax)
 
-		err := p.SettingPersonalDeductionHandler(c)
+		err := serve(c, p.SettingPersonalDeductionHandler)
 
 		assert.NoError(t, err, "expected no error but got %v", err)
 		assert.Equal(t, http.StatusInternalServerError, rec.Code, "expected status code %d but got %d", http.StatusInternalServerError, rec.Code)
@@ -326,7 +363,7 @@ func TestSettingPersonalDeduction(t *testing.T) {
 		stubTax := StubTax{settingPersonalDeduction: 70000.0}
 		p := New(&stubTax)
 
-		err := p.SettingPersonalDeductionHandler(c)
+		err := serve(c, p.SettingPersonalDeductionHandler)
 
 		assert.NoError(t, err, "expected no error but got %v", err)
 		assert.Equal(t, http.StatusOK, rec.Code, "expected status code %d but got %d", http.StatusOK, rec.Code)
@@ -344,12 +381,12 @@ func TestSettingPersonalDeduction(t *testing.T) {
 		c := e.NewContext(req, rec)
 		c.SetPath("/admin/deductions/personal")
 
-		want := `{ "message": "amount is required" }`
+		want := `{ "type": "about:blank", "title": "Bad Request", "status": 400, "detail": "amount is required", "instance": "/admin/deductions/personal", "message": "amount is required", "errors": [{"code": "AMOUNT_REQUIRED", "path": "amount", "value": 0, "message": "amount is required"}] }`
 
 		stubTax := StubTax{}
 		p := New(&stubTax)
 
-		err := p.SettingPersonalDeductionHandler(c)
+		err := serve(c, p.SettingPersonalDeductionHandler)
 
 		assert.NoError(t, err, "expected no error but got %v", err)
 		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
@@ -363,12 +400,12 @@ func TestSettingPersonalDeduction(t *testing.T) {
 		c := e.NewContext(req, rec)
 		c.SetPath("/admin/deductions/personal")
 
-		want := `{ "message": "personal deduction amount must be greater than or equal to 10,000.0" }`
+		want := `{ "type": "about:blank", "title": "Bad Request", "status": 400, "detail": "personal deduction amount must be greater than or equal to 10,000.0", "instance": "/admin/deductions/personal", "message": "personal deduction amount must be greater than or equal to 10,000.0", "errors": [{"code": "AMOUNT_BELOW_MINIMUM", "path": "amount", "value": 5000, "message": "personal deduction amount must be greater than or equal to 10,000.0"}] }`
 
 		stubTax := StubTax{}
 		p := New(&stubTax)
 
-		err := p.SettingPersonalDeductionHandler(c)
+		err := serve(c, p.SettingPersonalDeductionHandler)
 
 		assert.NoError(t, err, "expected no error but got %v", err)
 		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
@@ -382,12 +419,12 @@ func TestSettingPersonalDeduction(t *testing.T) {
 		c := e.NewContext(req, rec)
 		c.SetPath("/admin/deductions/personal")
 
-		want := `{ "message": "personal deduction amount must be less than or equal to 100,000.0" }`
+		want := `{ "type": "about:blank", "title": "Bad Request", "status": 400, "detail": "personal deduction amount must be less than or equal to 100,000.0", "instance": "/admin/deductions/personal", "message": "personal deduction amount must be less than or equal to 100,000.0", "errors": [{"code": "AMOUNT_ABOVE_MAXIMUM", "path": "amount", "value": 150000, "message": "personal deduction amount must be less than or equal to 100,000.0"}] }`
 
 		stubTax := StubTax{}
 		p := New(&stubTax)
 
-		err := p.SettingPersonalDeductionHandler(c)
+		err := serve(c, p.SettingPersonalDeductionHandler)
 
 		assert.NoError(t, err, "expected no error but got %v", err)
 		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
@@ -401,12 +438,12 @@ func TestSettingPersonalDeduction(t *testing.T) {
 		c := e.NewContext(req, rec)
 		c.SetPath("/admin/deductions/personal")
 
-		want := `{ "message": "invalid request body" }`
+		want := `{ "type": "about:blank", "title": "Bad Request", "status": 400, "detail": "amount must be a number", "instance": "/admin/deductions/personal", "message": "amount must be a number", "errors": [{"code": "INVALID_TYPE", "path": "amount", "value": "invalid", "message": "amount must be a number"}] }`
 
 		stubTax := StubTax{}
 		p := New(&stubTax)
 
-		err := p.SettingPersonalDeductionHandler(c)
+		err := serve(c, p.SettingPersonalDeductionHandler)
 
 		assert.NoError(t, err, "expected no error but got %v", err)
 		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
@@ -430,11 +467,11 @@ func TestCalculateTaxCSVHandler(t *testing.T) {
 		stubTax := StubTax{}
 		p := New(&stubTax)
 
-		err := p.CalculateTaxCSVHandler(c)
+		err := serve(c, p.CalculateTaxCSVHandler)
 
 		assert.NoError(t, err, "expected no error but got %v", err)
 		assert.Equal(t, http.StatusOK, rec.Code, "expected status code %d but got %d", http.StatusOK, rec.Code)
-		assert.Equal(t, `{"taxes":[{"totalIncome":1000,"tax":0},{"totalIncome":2000,"tax":0}]}`, strings.TrimSuffix(rec.Body.String(), "\n"))
+		assert.Equal(t, `{"taxes":[{"totalIncome":1000,"tax":0},{"totalIncome":2000,"tax":0}],"summary":{"rows":2,"succeeded":2,"failed":0,"totalIncome":3000,"totalTax":0,"totalRefund":0,"brackets":[],"averageEffectiveRate":0,"tax":{"min":0,"max":0,"p50":0,"p90":0,"p99":0}}}`, strings.TrimSuffix(rec.Body.String(), "\n"))
 	})
 	t.Run("given invalid file format should return status 400 and error message", func(t *testing.T) {
 		e := echo.New()
@@ -452,11 +489,11 @@ func TestCalculateTaxCSVHandler(t *testing.T) {
 		stubTax := StubTax{}
 		p := New(&stubTax)
 
-		err := p.CalculateTaxCSVHandler(c)
+		err := serve(c, p.CalculateTaxCSVHandler)
 
 		assert.NoError(t, err, "expected no error but got %v", err)
 		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
-		assert.Equal(t, `{"message":"error reading file: invalid format"}`, strings.TrimSuffix(rec.Body.String(), "\n"))
+		assert.Equal(t, `{"type":"about:blank","title":"Bad Request","status":400,"detail":"error reading file: invalid format","instance":"/tax/calculations/upload-csv","message":"error reading file: invalid format","errors":[{"line":3,"message":"error reading file: invalid format"}]}`, strings.TrimSuffix(rec.Body.String(), "\n"))
 	})
 	t.Run("given invalid file key should return status 400 and error message", func(t *testing.T) {
 		e := echo.New()
@@ -474,11 +511,11 @@ func TestCalculateTaxCSVHandler(t *testing.T) {
 		stubTax := StubTax{}
 		p := New(&stubTax)
 
-		err := p.CalculateTaxCSVHandler(c)
+		err := serve(c, p.CalculateTaxCSVHandler)
 
 		assert.NoError(t, err, "expected no error but got %v", err)
 		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
-		assert.Equal(t, `{"message":"invalid file : key must be taxFile"}`, strings.TrimSuffix(rec.Body.String(), "\n"))
+		assert.Equal(t, `{"type":"about:blank","title":"Bad Request","status":400,"detail":"invalid file : key must be taxFile","instance":"/tax/calculations/upload-csv","message":"invalid file : key must be taxFile"}`, strings.TrimSuffix(rec.Body.String(), "\n"))
 	})
 	t.Run("given totalIncome not numeric should return status 400 and error message", func(t *testing.T) {
 		e := echo.New()
@@ -496,11 +533,11 @@ func TestCalculateTaxCSVHandler(t *testing.T) {
 		stubTax := StubTax{}
 		p := New(&stubTax)
 
-		err := p.CalculateTaxCSVHandler(c)
+		err := serve(c, p.CalculateTaxCSVHandler)
 
 		assert.NoError(t, err, "expected no error but got %v", err)
 		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
-		assert.Equal(t, `{"message":"totalIncome must be a numeric value"}`, strings.TrimSuffix(rec.Body.String(), "\n"))
+		assert.Equal(t, `{"type":"about:blank","title":"Bad Request","status":400,"detail":"totalIncome must be a numeric value","instance":"/tax/calculations/upload-csv","message":"totalIncome must be a numeric value","errors":[{"line":4,"column":"totalIncome","code":"INVALID_TYPE","message":"totalIncome must be a numeric value"}]}`, strings.TrimSuffix(rec.Body.String(), "\n"))
 	})
 	t.Run("given wht not numeric should return status 400 and error message", func(t *testing.T) {
 		e := echo.New()
@@ -518,11 +555,11 @@ func TestCalculateTaxCSVHandler(t *testing.T) {
 		stubTax := StubTax{}
 		p := New(&stubTax)
 
-		err := p.CalculateTaxCSVHandler(c)
+		err := serve(c, p.CalculateTaxCSVHandler)
 
 		assert.NoError(t, err, "expected no error but got %v", err)
 		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
-		assert.Equal(t, `{"message":"wht must be a numeric value"}`, strings.TrimSuffix(rec.Body.String(), "\n"))
+		assert.Equal(t, `{"type":"about:blank","title":"Bad Request","status":400,"detail":"wht must be a numeric value","instance":"/tax/calculations/upload-csv","message":"wht must be a numeric value","errors":[{"line":4,"column":"wht","code":"INVALID_TYPE","message":"wht must be a numeric value"}]}`, strings.TrimSuffix(rec.Body.String(), "\n"))
 	})
 	t.Run("given totalIncome negative should return status 400 and error message", func(t *testing.T) {
 		e := echo.New()
@@ -540,11 +577,171 @@ func TestCalculateTaxCSVHandler(t *testing.T) {
 		stubTax := StubTax{}
 		p := New(&stubTax)
 
-		err := p.CalculateTaxCSVHandler(c)
+		err := serve(c, p.CalculateTaxCSVHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
+		assert.Equal(t, `{"type":"about:blank","title":"Bad Request","status":400,"detail":"total income must be greater than 0.0","instance":"/tax/calculations/upload-csv","message":"total income must be greater than 0.0","errors":[{"line":2,"column":"totalIncome","code":"TOTAL_INCOME_NEGATIVE","message":"total income must be greater than 0.0"}]}`, strings.TrimSuffix(rec.Body.String(), "\n"))
+	})
+	t.Run("given lenient mode should return status 200 with valid rows and row errors", func(t *testing.T) {
+		e := echo.New()
+		body := new(bytes.Buffer)
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("taxFile", "taxes.csv")
+		part.Write([]byte("totalIncome,wht,donation\n1000,200,300\ninvalid,200,300\n2000,400,600"))
+		writer.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/tax/calculations/upload-csv?mode=lenient", body)
+		req.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		stubTax := StubTax{}
+		p := New(&stubTax)
+
+		err := serve(c, p.CalculateTaxCSVHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusOK, rec.Code, "expected status code %d but got %d", http.StatusOK, rec.Code)
+		assert.Equal(t, `{"taxes":[{"totalIncome":1000,"tax":0},{"totalIncome":2000,"tax":0}],"errors":[{"line":3,"column":"totalIncome","code":"INVALID_TYPE","message":"totalIncome must be a numeric value"}],"summary":{"rows":3,"succeeded":2,"failed":1,"totalIncome":3000,"totalTax":0,"totalRefund":0,"brackets":[],"averageEffectiveRate":0,"tax":{"min":0,"max":0,"p50":0,"p90":0,"p99":0}}}`, strings.TrimSuffix(rec.Body.String(), "\n"))
+	})
+	t.Run("given k-receipt column in any order should return status 200 and tax result", func(t *testing.T) {
+		e := echo.New()
+		body := new(bytes.Buffer)
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("taxFile", "taxes.csv")
+		part.Write([]byte("wht,k-receipt,totalIncome\n200,5000,1000"))
+		writer.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/tax/calculations/upload-csv", body)
+		req.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		stubTax := StubTax{}
+		p := New(&stubTax)
+
+		err := serve(c, p.CalculateTaxCSVHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusOK, rec.Code, "expected status code %d but got %d", http.StatusOK, rec.Code)
+		assert.Equal(t, UserInfo{TotalIncome: 1000, WHT: 200, Allowances: []Allowances{{AllowanceType: "k-receipt", Amount: 5000}}}, stubTax.userInfo)
+	})
+	t.Run("given unknown column should return status 400 and error message", func(t *testing.T) {
+		e := echo.New()
+		body := new(bytes.Buffer)
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("taxFile", "taxes.csv")
+		part.Write([]byte("totalIncome,wht,withholding\n1000,200,300"))
+		writer.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/tax/calculations/upload-csv", body)
+		req.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		p := New(&StubTax{})
+
+		err := serve(c, p.CalculateTaxCSVHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
+		assert.Equal(t, `{"type":"about:blank","title":"Bad Request","status":400,"detail":"unknown column \"withholding\"","instance":"/tax/calculations/upload-csv","message":"unknown column \"withholding\""}`, strings.TrimSuffix(rec.Body.String(), "\n"))
+	})
+	t.Run("given unknown mode should return status 400 and error message", func(t *testing.T) {
+		e := echo.New()
+		body := new(bytes.Buffer)
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("taxFile", "taxes.csv")
+		part.Write([]byte("totalIncome,wht,donation\n1000,200,300"))
+		writer.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/tax/calculations/upload-csv?mode=partial", body)
+		req.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		p := New(&StubTax{})
+
+		err := serve(c, p.CalculateTaxCSVHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
+		assert.Equal(t, `{"type":"about:blank","title":"Bad Request","status":400,"detail":"mode must be strict or lenient","instance":"/tax/calculations/upload-csv","message":"mode must be strict or lenient"}`, strings.TrimSuffix(rec.Body.String(), "\n"))
+	})
+	t.Run("given ndjson accept header should stream one result per line", func(t *testing.T) {
+		e := echo.New()
+		body := new(bytes.Buffer)
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("taxFile", "taxes.csv")
+		part.Write([]byte("totalIncome,wht,donation\n1000,200,300\ninvalid,200,300\n2000,400,600"))
+		writer.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/tax/calculations/upload-csv?mode=lenient", body)
+		req.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+		req.Header.Set(echo.HeaderAccept, MIMEApplicationNDJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		p := New(&StubTax{})
+
+		err := serve(c, p.CalculateTaxCSVHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusOK, rec.Code, "expected status code %d but got %d", http.StatusOK, rec.Code)
+		assert.Equal(t, MIMEApplicationNDJSON, rec.Header().Get(echo.HeaderContentType))
+		assert.Equal(t, "{\"totalIncome\":1000,\"tax\":0}\n{\"error\":{\"line\":3,\"column\":\"totalIncome\",\"code\":\"INVALID_TYPE\",\"message\":\"totalIncome must be a numeric value\"}}\n{\"totalIncome\":2000,\"tax\":0}\n"+`{"summary":{"rows":3,"succeeded":2,"failed":1,"totalIncome":3000,"totalTax":0,"totalRefund":0,"brackets":[],"averageEffectiveRate":0,"tax":{"min":0,"max":0,"p50":0,"p90":0,"p99":0}}}`+"\n", rec.Body.String())
+	})
+	t.Run("given empty file should return status 200 and no taxes", func(t *testing.T) {
+		e := echo.New()
+		body := new(bytes.Buffer)
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("taxFile", "taxes.csv")
+		part.Write([]byte("totalIncome,wht\n"))
+		writer.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/tax/calculations/upload-csv", body)
+		req.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		p := New(&StubTax{})
+
+		err := serve(c, p.CalculateTaxCSVHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusOK, rec.Code, "expected status code %d but got %d", http.StatusOK, rec.Code)
+		assert.Equal(t, `{"taxes":[],"summary":{"rows":0,"succeeded":0,"failed":0,"totalIncome":0,"totalTax":0,"totalRefund":0,"brackets":[],"averageEffectiveRate":0,"tax":{"min":0,"max":0,"p50":0,"p90":0,"p99":0}}}`, strings.TrimSuffix(rec.Body.String(), "\n"))
+	})
+	t.Run("given id and passthrough columns should echo them in results and errors", func(t *testing.T) {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		req := uploadRequest("/tax/calculations/upload-csv?mode=lenient&passthrough=department", "employeeId,totalIncome,wht,department\nE-001,1000,0,Sales\nE-002,invalid,0,Finance")
+		req.Header.Set(echo.HeaderAccept, MIMEApplicationNDJSON)
+		c := e.NewContext(req, rec)
+
+		p := New(&StubTax{})
+
+		err := serve(c, p.CalculateTaxCSVHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusOK, rec.Code, "expected status code %d but got %d", http.StatusOK, rec.Code)
+		lines := strings.Split(rec.Body.String(), "\n")
+		assert.Equal(t, `{"totalIncome":1000,"tax":0,"employeeId":"E-001","passthrough":{"department":"Sales"}}`, lines[0])
+		assert.Equal(t, `{"error":{"line":3,"column":"totalIncome","code":"INVALID_TYPE","message":"totalIncome must be a numeric value","employeeId":"E-002","passthrough":{"department":"Finance"}}}`, lines[1])
+	})
+	t.Run("given a column not named as passthrough should return status 400", func(t *testing.T) {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		c := e.NewContext(uploadRequest("/tax/calculations/upload-csv", "id,totalIncome,wht,department\n1,1000,0,Sales"), rec)
+
+		p := New(&StubTax{})
+
+		err := serve(c, p.CalculateTaxCSVHandler)
 
 		assert.NoError(t, err, "expected no error but got %v", err)
 		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
-		assert.Equal(t, `{"message":"total income must be greater than 0.0"}`, strings.TrimSuffix(rec.Body.String(), "\n"))
+		assert.Equal(t, `{"type":"about:blank","title":"Bad Request","status":400,"detail":"unknown column \"department\"","instance":"/tax/calculations/upload-csv","message":"unknown column \"department\""}`, strings.TrimSuffix(rec.Body.String(), "\n"))
 	})
 }
 
@@ -557,12 +754,12 @@ func TestSettingMaxKReceipt(t *testing.T) {
 		c := e.NewContext(req, rec)
 		c.SetPath("/admin/deductions/k-receipt")
 
-		want := `{ "message": "failed to set max k-receipt" }`
+		want := `{ "type": "about:blank", "title": "Internal Server Error", "status": 500, "detail": "failed to set max k-receipt", "instance": "/admin/deductions/k-receipt", "message": "failed to set max k-receipt" }`
 
 		stubTax := StubTax{err: errors.New("failed to set max k-receipt")}
 		p := New(&stubTax)
 
-		err := p.SettingMaxKReceiptHandler(c)
+		err := serve(c, p.SettingMaxKReceiptHandler)
 
 		assert.NoError(t, err, "expected no error but got %v", err)
 		assert.Equal(t, http.StatusInternalServerError, rec.Code, "expected status code %d but got %d", http.StatusInternalServerError, rec.Code)
@@ -581,7 +778,7 @@ func TestSettingMaxKReceipt(t *testing.T) {
 		stubTax := StubTax{settingMaxKReceipt: 70000.0}
 		p := New(&stubTax)
 
-		err := p.SettingMaxKReceiptHandler(c)
+		err := serve(c, p.SettingMaxKReceiptHandler)
 
 		assert.NoError(t, err, "expected no error but got %v", err)
 		assert.Equal(t, http.StatusOK, rec.Code, "expected status code %d but got %d", http.StatusOK, rec.Code)
@@ -599,12 +796,12 @@ func TestSettingMaxKReceipt(t *testing.T) {
 		c := e.NewContext(req, rec)
 		c.SetPath("/admin/deductions/k-receipt")
 
-		want := `{ "message": "amount is required" }`
+		want := `{ "type": "about:blank", "title": "Bad Request", "status": 400, "detail": "amount is required", "instance": "/admin/deductions/k-receipt", "message": "amount is required", "errors": [{"code": "AMOUNT_REQUIRED", "path": "amount", "value": 0, "message": "amount is required"}] }`
 
 		stubTax := StubTax{}
 		p := New(&stubTax)
 
-		err := p.SettingMaxKReceiptHandler(c)
+		err := serve(c, p.SettingMaxKReceiptHandler)
 
 		assert.NoError(t, err, "expected no error but got %v", err)
 		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
@@ -618,12 +815,12 @@ func TestSettingMaxKReceipt(t *testing.T) {
 		c := e.NewContext(req, rec)
 		c.SetPath("/admin/deductions/k-receipt")
 
-		want := `{ "message": "max k-receipt amount must be greater than 0.0" }`
+		want := `{ "type": "about:blank", "title": "Bad Request", "status": 400, "detail": "max k-receipt amount must be greater than 0.0", "instance": "/admin/deductions/k-receipt", "message": "max k-receipt amount must be greater than 0.0", "errors": [{"code": "AMOUNT_BELOW_MINIMUM", "path": "amount", "value": -1000, "message": "max k-receipt amount must be greater than 0.0"}] }`
 
 		stubTax := StubTax{}
 		p := New(&stubTax)
 
-		err := p.SettingMaxKReceiptHandler(c)
+		err := serve(c, p.SettingMaxKReceiptHandler)
 
 		assert.NoError(t, err, "expected no error but got %v", err)
 		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
@@ -637,12 +834,12 @@ func TestSettingMaxKReceipt(t *testing.T) {
 		c := e.NewContext(req, rec)
 		c.SetPath("/admin/deductions/k-receipt")
 
-		want := `{ "message": "max k-receipt amount must be less than or equal to 100,000.0" }`
+		want := `{ "type": "about:blank", "title": "Bad Request", "status": 400, "detail": "max k-receipt amount must be less than or equal to 100,000.0", "instance": "/admin/deductions/k-receipt", "message": "max k-receipt amount must be less than or equal to 100,000.0", "errors": [{"code": "AMOUNT_ABOVE_MAXIMUM", "path": "amount", "value": 150000, "message": "max k-receipt amount must be less than or equal to 100,000.0"}] }`
 
 		stubTax := StubTax{}
 		p := New(&stubTax)
 
-		err := p.SettingMaxKReceiptHandler(c)
+		err := serve(c, p.SettingMaxKReceiptHandler)
 
 		assert.NoError(t, err, "expected no error but got %v", err)
 		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
@@ -656,12 +853,12 @@ func TestSettingMaxKReceipt(t *testing.T) {
 		c := e.NewContext(req, rec)
 		c.SetPath("/admin/deductions/k-receipt")
 
-		want := `{ "message": "invalid request body" }`
+		want := `{ "type": "about:blank", "title": "Bad Request", "status": 400, "detail": "amount must be a number", "instance": "/admin/deductions/k-receipt", "message": "amount must be a number", "errors": [{"code": "INVALID_TYPE", "path": "amount", "value": "abc", "message": "amount must be a number"}] }`
 
 		stubTax := StubTax{}
 		p := New(&stubTax)
 
-		err := p.SettingMaxKReceiptHandler(c)
+		err := serve(c, p.SettingMaxKReceiptHandler)
 
 		assert.NoError(t, err, "expected no error but got %v", err)
 		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
@@ -0,0 +1,94 @@
+package tax
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+func (h *Handler) CreateTaxpayerHandler(c echo.Context) error {
+	var taxpayer Taxpayer
+	if status, err := bindJSON(c, &taxpayer); err.Message != "" {
+		return problem(status, err)
+	}
+	if err := h.validationTaxpayer(taxpayer); err.Message != "" {
+		return problem(http.StatusBadRequest, err)
+	}
+
+	created, err := h.taxpayers.CreateTaxpayer(taxpayer)
+	if errors.Is(err, ErrAlreadyExists) {
+		return problem(http.StatusConflict, Err{Message: "taxpayer already exists"})
+	}
+	if err != nil {
+		return problem(http.StatusInternalServerError, Err{Message: "failed to create taxpayer", cause: err})
+	}
+
+	return c.JSON(http.StatusCreated, created)
+}
+
+func (h *Handler) GetTaxpayerHandler(c echo.Context) error {
+	id := c.Param("taxpayerId")
+	if !isValidTaxpayerID(id) {
+		return problem(http.StatusBadRequest, Err{Message: "taxpayer id must be a valid 13-digit national id"})
+	}
+
+	taxpayer, err := h.taxpayers.GetTaxpayer(id)
+	if errors.Is(err, ErrNotFound) {
+		return problem(http.StatusNotFound, Err{Message: "taxpayer not found"})
+	}
+	if err != nil {
+		return problem(http.StatusInternalServerError, Err{Message: "failed to get taxpayer", cause: err})
+	}
+
+	return c.JSON(http.StatusOK, taxpayer)
+}
+
+func (h *Handler) UpdateTaxpayerHandler(c echo.Context) error {
+	var taxpayer Taxpayer
+	if status, err := bindJSON(c, &taxpayer); err.Message != "" {
+		return problem(status, err)
+	}
+	taxpayer.ID = c.Param("taxpayerId")
+	if err := h.validationTaxpayer(taxpayer); err.Message != "" {
+		return problem(http.StatusBadRequest, err)
+	}
+
+	updated, err := h.taxpayers.UpdateTaxpayer(taxpayer)
+	if errors.Is(err, ErrNotFound) {
+		return problem(http.StatusNotFound, Err{Message: "taxpayer not found"})
+	}
+	if err != nil {
+		return problem(http.StatusInternalServerError, Err{Message: "failed to update taxpayer", cause: err})
+	}
+
+	return c.JSON(http.StatusOK, updated)
+}
+
+func (h *Handler) checkTaxpayerProfile(taxpayerID string) error {
+	if taxpayerID == "" || h.taxpayers == nil {
+		return nil
+	}
+	_, err := h.taxpayers.GetTaxpayer(taxpayerID)
+	return err
+}
+
+// isValidTaxpayerID checks a 13-digit Thai national id / tax id against its
+// mod-11 check digit.
+func isValidTaxpayerID(id string) bool {
+	if len(id) != 13 {
+		return false
+	}
+
+	sum := 0
+	for i := 0; i < 13; i++ {
+		if id[i] < '0' || id[i] > '9' {
+			return false
+		}
+		if i < 12 {
+			sum += int(id[i]-'0') * (13 - i)
+		}
+	}
+
+	return (11-sum%11)%10 == int(id[12]-'0')
+}
@@ -0,0 +1,205 @@
+// go:build unit
+
+package tax
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+type StubTaxpayers struct {
+	taxpayers map[string]Taxpayer
+	err       error
+}
+
+func (s *StubTaxpayers) CreateTaxpayer(taxpayer Taxpayer) (Taxpayer, error) {
+	if s.err != nil {
+		return Taxpayer{}, s.err
+	}
+	if _, ok := s.taxpayers[taxpayer.ID]; ok {
+		return Taxpayer{}, ErrAlreadyExists
+	}
+	return taxpayer, nil
+}
+
+func (s *StubTaxpayers) GetTaxpayer(id string) (Taxpayer, error) {
+	if s.err != nil {
+		return Taxpayer{}, s.err
+	}
+	taxpayer, ok := s.taxpayers[id]
+	if !ok {
+		return Taxpayer{}, ErrNotFound
+	}
+	return taxpayer, nil
+}
+
+func (s *StubTaxpayers) UpdateTaxpayer(taxpayer Taxpayer) (Taxpayer, error) {
+	if _, err := s.GetTaxpayer(taxpayer.ID); err != nil {
+		return Taxpayer{}, err
+	}
+	return taxpayer, nil
+}
+
+func TestIsValidTaxpayerID(t *testing.T) {
+	tests := []struct {
+		name string
+		id   string
+		want bool
+	}{
+		{name: "valid id", id: "1101700230708", want: true},
+		{name: "valid id with check digit 0", id: "3100600445040", want: true},
+		{name: "wrong check digit", id: "1101700230709", want: false},
+		{name: "too short", id: "110170023070", want: false},
+		{name: "non digit", id: "110170023070x", want: false},
+		{name: "empty", id: "", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isValidTaxpayerID(tt.id))
+		})
+	}
+}
+
+func TestCreateTaxpayerHandler(t *testing.T) {
+	t.Run("given valid profile should return status 201 and taxpayer", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/taxpayers", io.NopCloser(strings.NewReader(`{"id": "1101700230708", "name": "Somchai", "address": "Bangkok", "filingStatus": "single"}`)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		p := New(&StubTax{}).WithTaxpayers(&StubTaxpayers{})
+
+		err := serve(c, p.CreateTaxpayerHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusCreated, rec.Code, "expected status code %d but got %d", http.StatusCreated, rec.Code)
+	})
+	t.Run("given id with invalid checksum should return status 400 and error message", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/taxpayers", io.NopCloser(strings.NewReader(`{"id": "1101700230709", "name": "Somchai", "address": "Bangkok", "filingStatus": "single"}`)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		p := New(&StubTax{}).WithTaxpayers(&StubTaxpayers{})
+
+		err := serve(c, p.CreateTaxpayerHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
+		assert.JSONEq(t, `{"type": "about:blank", "title": "Bad Request", "status": 400, "detail": "id must be a valid 13-digit national id", "instance": "/taxpayers", "message": "id must be a valid 13-digit national id", "errors": [{"code": "ID_INVALID", "path": "id", "value": "1101700230709", "message": "id must be a valid 13-digit national id"}]}`, rec.Body.String())
+	})
+	t.Run("given invalid filing status should return status 400 and error message", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/taxpayers", io.NopCloser(strings.NewReader(`{"id": "1101700230708", "name": "Somchai", "address": "Bangkok", "filingStatus": "married"}`)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		p := New(&StubTax{}).WithTaxpayers(&StubTaxpayers{})
+
+		err := serve(c, p.CreateTaxpayerHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
+		assert.JSONEq(t, `{"type": "about:blank", "title": "Bad Request", "status": 400, "detail": "filingStatus must be one of single, married-joint or married-separate", "instance": "/taxpayers", "message": "filingStatus must be one of single, married-joint or married-separate", "errors": [{"code": "FILING_STATUS_INVALID", "path": "filingStatus", "value": "married", "message": "filingStatus must be one of single, married-joint or married-separate"}]}`, rec.Body.String())
+	})
+	t.Run("given several invalid fields should return status 400 and every error with its code and path", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/taxpayers", io.NopCloser(strings.NewReader(`{"id": "123", "name": " ", "address": "", "filingStatus": "married"}`)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		p := New(&StubTax{}).WithTaxpayers(&StubTaxpayers{})
+
+		err := serve(c, p.CreateTaxpayerHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
+		assert.JSONEq(t, `{"type": "about:blank", "title": "Bad Request", "status": 400, "detail": "id must be a valid 13-digit national id", "instance": "/taxpayers", "message": "id must be a valid 13-digit national id", "errors": [
+			{"code": "ID_INVALID", "path": "id", "value": "123", "message": "id must be a valid 13-digit national id"},
+			{"code": "NAME_REQUIRED", "path": "name", "value": " ", "message": "name is required"},
+			{"code": "ADDRESS_REQUIRED", "path": "address", "value": "", "message": "address is required"},
+			{"code": "FILING_STATUS_INVALID", "path": "filingStatus", "value": "married", "message": "filingStatus must be one of single, married-joint or married-separate"}
+		]}`, rec.Body.String())
+	})
+	t.Run("given unknown field should return status 400 with the field", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/taxpayers", io.NopCloser(strings.NewReader(`{"id": "1101700230708", "name": "Somchai", "address": "Bangkok", "filingStatus": "single", "nickname": "Chai"}`)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		p := New(&StubTax{}).WithTaxpayers(&StubTaxpayers{})
+
+		err := serve(c, p.CreateTaxpayerHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"message":"unknown field \"nickname\""`)
+	})
+	t.Run("given existing taxpayer should return status 409 and error message", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/taxpayers", io.NopCloser(strings.NewReader(`{"id": "1101700230708", "name": "Somchai", "address": "Bangkok", "filingStatus": "single"}`)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		p := New(&StubTax{}).WithTaxpayers(&StubTaxpayers{taxpayers: map[string]Taxpayer{"1101700230708": {ID: "1101700230708"}}})
+
+		err := serve(c, p.CreateTaxpayerHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusConflict, rec.Code, "expected status code %d but got %d", http.StatusConflict, rec.Code)
+		assert.JSONEq(t, `{"type": "about:blank", "title": "Conflict", "status": 409, "detail": "taxpayer already exists", "instance": "/taxpayers", "message": "taxpayer already exists"}`, rec.Body.String())
+	})
+}
+
+func TestCalculateTaxWithTaxpayerID(t *testing.T) {
+	t.Run("given taxpayerId should return status 400 and not store calculation", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/tax/calculations", io.NopCloser(strings.NewReader(`{"taxpayerId": "1101700230708", "totalIncome": 500000.0, "wht": 0.0}`)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		stubTax := StubTax{}
+		stubHistory := StubHistory{}
+		taxpayers := StubTaxpayers{taxpayers: map[string]Taxpayer{"1101700230708": {ID: "1101700230708"}}}
+		p := New(&stubTax).WithHistory(&stubHistory).WithTaxpayers(&taxpayers)
+
+		err := serve(c, p.CalculateTaxHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "expected status code %d but got %d", http.StatusBadRequest, rec.Code)
+		assert.JSONEq(t, `{"type": "about:blank", "title": "Bad Request", "status": 400, "detail": "taxpayerId is only accepted by POST /taxpayers/:taxpayerId/calculations", "instance": "/tax/calculations", "message": "taxpayerId is only accepted by POST /taxpayers/:taxpayerId/calculations", "errors": [{"code": "TAXPAYER_ID_NOT_ALLOWED", "path": "taxpayerId", "value": "1101700230708", "message": "taxpayerId is only accepted by POST /taxpayers/:taxpayerId/calculations"}]}`, rec.Body.String())
+		assert.Equal(t, UserInfo{}, stubTax.userInfo)
+		assert.Equal(t, Calculation{}, stubHistory.saved)
+	})
+	t.Run("given taxpayer store error on the taxpayer route should return status 500", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/taxpayers/1101700230708/calculations", io.NopCloser(strings.NewReader(`{"totalIncome": 500000.0, "wht": 0.0}`)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("taxpayerId")
+		c.SetParamValues("1101700230708")
+
+		p := New(&StubTax{}).WithHistory(&StubHistory{}).WithTaxpayers(&StubTaxpayers{err: errors.New("db down")})
+
+		err := serve(c, p.CreateCalculationHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusInternalServerError, rec.Code, "expected status code %d but got %d", http.StatusInternalServerError, rec.Code)
+	})
+}
@@ -1,71 +1,246 @@
 package tax
 
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Validation error codes are part of the API: clients match on them, so they
+// must not change once published.
+const (
+	CodeTaxpayerIDInvalid          = "TAXPAYER_ID_INVALID"
+	CodeTaxpayerIDNotAllowed       = "TAXPAYER_ID_NOT_ALLOWED"
+	CodeTotalIncomeRequired        = "TOTAL_INCOME_REQUIRED"
+	CodeTotalIncomeNegative        = "TOTAL_INCOME_NEGATIVE"
+	CodeWHTNegative                = "WHT_NEGATIVE"
+	CodeWHTExceedsIncome           = "WHT_EXCEEDS_INCOME"
+	CodeAllowanceTypeMissing       = "ALLOWANCE_TYPE_MISSING"
+	CodeAllowanceTypeInvalid       = "ALLOWANCE_TYPE_INVALID"
+	CodeAllowanceAmountNegative    = "ALLOWANCE_AMOUNT_NEGATIVE"
+	CodeKReceiptAmountNegative     = "K_RECEIPT_AMOUNT_NEGATIVE"
+	CodePersonalAllowanceForbidden = "PERSONAL_ALLOWANCE_FORBIDDEN"
+	CodeUnknownField               = "UNKNOWN_FIELD"
+	CodeDuplicateField             = "DUPLICATE_FIELD"
+	CodeInvalidType                = "INVALID_TYPE"
+	CodeNumberNotFinite            = "NUMBER_NOT_FINITE"
+	CodeWHTCertificateMismatch     = "WHT_CERTIFICATE_MISMATCH"
+	CodePayerTaxIDInvalid          = "PAYER_TAX_ID_INVALID"
+	CodeIncomeTypeInvalid          = "INCOME_TYPE_INVALID"
+	CodeAmountPaidNotPositive      = "AMOUNT_PAID_NOT_POSITIVE"
+	CodeTaxWithheldNegative        = "TAX_WITHHELD_NEGATIVE"
+	CodeTaxWithheldExceedsAmount   = "TAX_WITHHELD_EXCEEDS_AMOUNT"
+	CodeDateInvalid                = "DATE_INVALID"
+	CodeDateOutsideTaxYear         = "DATE_OUTSIDE_TAX_YEAR"
+	CodeAmountRequired             = "AMOUNT_REQUIRED"
+	CodeAmountBelowMinimum         = "AMOUNT_BELOW_MINIMUM"
+	CodeAmountAboveMaximum         = "AMOUNT_ABOVE_MAXIMUM"
+	CodeIDInvalid                  = "ID_INVALID"
+	CodeNameRequired               = "NAME_REQUIRED"
+	CodeAddressRequired            = "ADDRESS_REQUIRED"
+	CodeFilingStatusInvalid        = "FILING_STATUS_INVALID"
+	CodeBankCodeRequired           = "BANK_CODE_REQUIRED"
+	CodeAccountNameRequired        = "ACCOUNT_NAME_REQUIRED"
+	CodeAccountNumberInvalid       = "ACCOUNT_NUMBER_INVALID"
+)
+
+// validationErrors collects every failure of a request. column is the CSV
+// column a failure belongs to, if any.
+type validationErrors struct {
+	errors  []FieldError
+	columns []string
+}
+
+func (v *validationErrors) add(code, path string, value interface{}, message, column string) {
+	v.errors = append(v.errors, FieldError{Code: code, Path: path, Value: value, Message: message})
+	v.columns = append(v.columns, column)
+}
+
+// addf adds a failure whose message is the catalog entry key filled with args.
+func (v *validationErrors) addf(code, path string, value interface{}, column, key string, args ...interface{}) {
+	text := newText(key, args...)
+	v.errors = append(v.errors, FieldError{Code: code, Path: path, Value: value, Message: text.String(), text: text})
+	v.columns = append(v.columns, column)
+}
+
+// err reports the first failure as the message, as before every failure was
+// listed, and all of them in Errors.
+func (v *validationErrors) err() Err {
+	if len(v.errors) == 0 {
+		return Err{}
+	}
+	return Err{Message: v.errors[0].Message, Errors: v.errors, text: v.errors[0].text, field: v.columns[0]}
+}
+
 func (h *Handler) validationUserInfo(userInfo UserInfo) Err {
+	var v validationErrors
+	h.collectUserInfoErrors(&v, userInfo)
+	return v.err()
+}
+
+// validationPublicUserInfo checks a request to the public calculation
+// endpoints. They take no taxpayerId: a calculation is only linked to a
+// taxpayer through POST /taxpayers/:taxpayerId/calculations, which sits behind
+// credentials.
+func (h *Handler) validationPublicUserInfo(userInfo UserInfo) Err {
+	var v validationErrors
+	if userInfo.TaxpayerID != "" {
+		v.add(CodeTaxpayerIDNotAllowed, "taxpayerId", userInfo.TaxpayerID, "taxpayerId is only accepted by POST /taxpayers/:taxpayerId/calculations", "taxpayerId")
+		userInfo.TaxpayerID = ""
+	}
+	h.collectUserInfoErrors(&v, userInfo)
+	return v.err()
+}
+
+func (h *Handler) collectUserInfoErrors(v *validationErrors, userInfo UserInfo) {
+	if userInfo.TaxpayerID != "" && !isValidTaxpayerID(userInfo.TaxpayerID) {
+		v.add(CodeTaxpayerIDInvalid, "taxpayerId", userInfo.TaxpayerID, "taxpayerId must be a valid 13-digit national id", "taxpayerId")
+	}
 	if userInfo.TotalIncome == 0.0 {
-		return Err{Message: "total income is required"}
+		v.add(CodeTotalIncomeRequired, "totalIncome", userInfo.TotalIncome, "total income is required", "totalIncome")
 	}
 	if userInfo.TotalIncome < 0.0 {
-		return Err{Message: "total income must be greater than 0.0"}
+		v.add(CodeTotalIncomeNegative, "totalIncome", userInfo.TotalIncome, "total income must be greater than 0.0", "totalIncome")
 	}
 	if userInfo.WHT < 0.0 {
-		return Err{Message: "wht must be greater than or equal to 0.0"}
-	}
-	if userInfo.WHT > userInfo.TotalIncome {
-		return Err{Message: "wht must be less than or equal to total income"}
+		v.add(CodeWHTNegative, "wht", userInfo.WHT, "wht must be greater than or equal to 0.0", "wht")
+	} else if userInfo.TotalIncome > 0.0 && userInfo.WHT > userInfo.TotalIncome {
+		v.add(CodeWHTExceedsIncome, "wht", userInfo.WHT, "wht must be less than or equal to total income", "wht")
 	}
-	for _, allowance := range userInfo.Allowances {
-		if allowance.AllowanceType == "" {
-			return Err{Message: "missing allowanceType key"}
-		}
-		if allowance.AllowanceType == "k-receipt" && allowance.Amount < 0.0 {
-			return Err{Message: "k-receipt amount must be greater than or equal to 0.0"}
-		}
-		if allowance.Amount < 0.0 {
-			return Err{Message: "allowance amount must be greater than or equal to 0.0"}
-		}
-		if allowance.AllowanceType == "personal" {
-			return Err{Message: "user can not fill personal allowance"}
+	for i, allowance := range userInfo.Allowances {
+		path := "allowances[" + strconv.Itoa(i) + "]"
+		switch {
+		case allowance.AllowanceType == "":
+			v.add(CodeAllowanceTypeMissing, path+".allowanceType", allowance.AllowanceType, "missing allowanceType key", "")
+		case !h.isValidAllowanceType(allowance.AllowanceType):
+			v.add(CodeAllowanceTypeInvalid, path+".allowanceType", allowance.AllowanceType, "invalid allowance type", "")
+		case allowance.AllowanceType == "k-receipt" && allowance.Amount < 0.0:
+			v.add(CodeKReceiptAmountNegative, path+".amount", allowance.Amount, "k-receipt amount must be greater than or equal to 0.0", allowance.AllowanceType)
+		case allowance.Amount < 0.0:
+			v.add(CodeAllowanceAmountNegative, path+".amount", allowance.Amount, "allowance amount must be greater than or equal to 0.0", allowance.AllowanceType)
+		case allowance.AllowanceType == "personal":
+			v.add(CodePersonalAllowanceForbidden, path+".allowanceType", allowance.AllowanceType, "user can not fill personal allowance", allowance.AllowanceType)
 		}
 	}
+}
 
-	return Err{}
+var allowanceTypes = map[string]bool{
+	"donation":  true,
+	"k-receipt": true,
+	"personal":  true,
 }
 
 func (h *Handler) isValidAllowanceType(allowanceType string) bool {
-	validAllowanceTypes := map[string]bool{
-		"donation":  true,
-		"k-receipt": true,
-		"personal":  true,
-	}
-
-	_, ok := validAllowanceTypes[allowanceType]
+	_, ok := allowanceTypes[allowanceType]
 	return ok
 }
 
 func (h *Handler) validationPersonalDeductionSetting(setting Setting) Err {
-	if setting.Amount == 0.0 {
-		return Err{Message: "amount is required"}
+	var v validationErrors
+	switch {
+	case setting.Amount == 0.0:
+		v.add(CodeAmountRequired, "amount", setting.Amount, "amount is required", "")
+	case setting.Amount < 10000.0:
+		v.add(CodeAmountBelowMinimum, "amount", setting.Amount, "personal deduction amount must be greater than or equal to 10,000.0", "")
+	case setting.Amount > 100000.0:
+		v.add(CodeAmountAboveMaximum, "amount", setting.Amount, "personal deduction amount must be less than or equal to 100,000.0", "")
 	}
-	if setting.Amount < 10000.0 {
-		return Err{Message: "personal deduction amount must be greater than or equal to 10,000.0"}
+
+	return v.err()
+}
+
+func (h *Handler) validationMaxKReceiptSetting(setting Setting) Err {
+	var v validationErrors
+	switch {
+	case setting.Amount == 0.0:
+		v.add(CodeAmountRequired, "amount", setting.Amount, "amount is required", "")
+	case setting.Amount < 0.0:
+		v.add(CodeAmountBelowMinimum, "amount", setting.Amount, "max k-receipt amount must be greater than 0.0", "")
+	case setting.Amount > 100000.0:
+		v.add(CodeAmountAboveMaximum, "amount", setting.Amount, "max k-receipt amount must be less than or equal to 100,000.0", "")
+	}
+
+	return v.err()
+}
+
+func (h *Handler) validationTaxpayer(taxpayer Taxpayer) Err {
+	var v validationErrors
+	if !isValidTaxpayerID(taxpayer.ID) {
+		v.add(CodeIDInvalid, "id", taxpayer.ID, "id must be a valid 13-digit national id", "")
+	}
+	if strings.TrimSpace(taxpayer.Name) == "" {
+		v.add(CodeNameRequired, "name", taxpayer.Name, "name is required", "")
+	}
+	if strings.TrimSpace(taxpayer.Address) == "" {
+		v.add(CodeAddressRequired, "address", taxpayer.Address, "address is required", "")
 	}
-	if setting.Amount > 100000.0 {
-		return Err{Message: "personal deduction amount must be less than or equal to 100,000.0"}
+	switch taxpayer.FilingStatus {
+	case FilingStatusSingle, FilingStatusMarriedJoint, FilingStatusMarriedSeparate:
+	default:
+		v.add(CodeFilingStatusInvalid, "filingStatus", taxpayer.FilingStatus, "filingStatus must be one of single, married-joint or married-separate", "")
 	}
 
-	return Err{}
+	return v.err()
 }
 
-func (h *Handler) validationMaxKReceiptSetting(setting Setting) Err {
-	if setting.Amount == 0.0 {
-		return Err{Message: "amount is required"}
+func (h *Handler) validationBankAccount(account BankAccount) Err {
+	var v validationErrors
+	if strings.TrimSpace(account.BankCode) == "" {
+		v.add(CodeBankCodeRequired, "bankAccount.bankCode", account.BankCode, "bankCode is required", "")
+	}
+	if strings.TrimSpace(account.AccountName) == "" {
+		v.add(CodeAccountNameRequired, "bankAccount.accountName", account.AccountName, "accountName is required", "")
 	}
-	if setting.Amount < 0.0 {
-		return Err{Message: "max k-receipt amount must be greater than 0.0"}
+	if !isDigits(account.AccountNumber) || len(account.AccountNumber) < 10 || len(account.AccountNumber) > 15 {
+		v.add(CodeAccountNumberInvalid, "bankAccount.accountNumber", account.AccountNumber, "accountNumber must be 10 to 15 digits", "")
 	}
-	if setting.Amount > 100000.0 {
-		return Err{Message: "max k-receipt amount must be less than or equal to 100,000.0"}
+
+	return v.err()
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
 	}
+	return true
+}
+
+var incomeTypes = map[string]bool{
+	"40(1)": true,
+	"40(2)": true,
+	"40(3)": true,
+	"40(4)": true,
+	"40(5)": true,
+	"40(6)": true,
+	"40(7)": true,
+	"40(8)": true,
+}
 
-	return Err{}
+// collectCertificateErrors checks the certificate at index i of an import.
+// Paths and messages carry its position so a whole file can be reported.
+func (h *Handler) collectCertificateErrors(v *validationErrors, i int, certificate WHTCertificate) {
+	path := "[" + strconv.Itoa(i) + "]."
+
+	if !isValidTaxpayerID(certificate.PayerTaxID) {
+		v.addf(CodePayerTaxIDInvalid, path+"payerTaxId", certificate.PayerTaxID, "payerTaxId", "certificate %s: payerTaxId must be a valid 13-digit tax id", i+1)
+	}
+	if !incomeTypes[certificate.IncomeType] {
+		v.addf(CodeIncomeTypeInvalid, path+"incomeType", certificate.IncomeType, "incomeType", "certificate %s: incomeType must be one of 40(1) to 40(8)", i+1)
+	}
+	if certificate.AmountPaid <= 0.0 {
+		v.addf(CodeAmountPaidNotPositive, path+"amountPaid", certificate.AmountPaid, "amountPaid", "certificate %s: amountPaid must be greater than 0.0", i+1)
+	}
+	if certificate.TaxWithheld < 0.0 {
+		v.addf(CodeTaxWithheldNegative, path+"taxWithheld", certificate.TaxWithheld, "taxWithheld", "certificate %s: taxWithheld must be greater than or equal to 0.0", i+1)
+	} else if certificate.TaxWithheld > certificate.AmountPaid {
+		v.addf(CodeTaxWithheldExceedsAmount, path+"taxWithheld", certificate.TaxWithheld, "taxWithheld", "certificate %s: taxWithheld must be less than or equal to amountPaid", i+1)
+	}
+	date, err := time.Parse("2006-01-02", certificate.Date)
+	if err != nil {
+		v.addf(CodeDateInvalid, path+"date", certificate.Date, "date", "certificate %s: date must be in YYYY-MM-DD format", i+1)
+	} else if date.Year()+buddhistEraYearShift != certificate.TaxYear {
+		v.addf(CodeDateOutsideTaxYear, path+"date", certificate.Date, "date", "certificate %s: date must be within tax year %s", i+1, certificate.TaxYear)
+	}
 }
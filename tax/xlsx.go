@@ -0,0 +1,394 @@
+package tax
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+</Types>`
+	xlsxRootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`
+	xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="taxes" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`
+	xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+</Relationships>`
+	xlsxSheetStart = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+	xlsxSheetEnd = `</sheetData></worksheet>`
+)
+
+// xlsxTable writes a single sheet workbook. The fixed parts go first so the
+// sheet itself can be streamed row by row as the last entry of the archive.
+type xlsxTable struct {
+	zw    *zip.Writer
+	sheet io.Writer
+	row   int
+}
+
+func newXLSXTable(w io.Writer) (tableWriter, error) {
+	zw := zip.NewWriter(w)
+	parts := []struct{ name, body string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRootRels},
+		{"xl/workbook.xml", xlsxWorkbook},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+	}
+	for _, part := range parts {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, part.body); err != nil {
+			return nil, err
+		}
+	}
+
+	sheet, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(sheet, xlsxSheetStart); err != nil {
+		return nil, err
+	}
+	return &xlsxTable{zw: zw, sheet: sheet}, nil
+}
+
+func (t *xlsxTable) writeRow(cells []tableCell) error {
+	t.row++
+	line := strconv.Itoa(t.row)
+	if _, err := io.WriteString(t.sheet, `<row r="`+line+`">`); err != nil {
+		return err
+	}
+	for i, cell := range cells {
+		ref := cellName(i, t.row)
+		if cell.numeric {
+			if _, err := io.WriteString(t.sheet, `<c r="`+ref+`"><v>`+strconv.FormatFloat(cell.number, 'f', -1, 64)+`</v></c>`); err != nil {
+				return err
+			}
+			continue
+		}
+		if cell.text == "" {
+			continue
+		}
+		if _, err := io.WriteString(t.sheet, `<c r="`+ref+`" t="inlineStr"><is><t xml:space="preserve">`); err != nil {
+			return err
+		}
+		if err := xml.EscapeText(t.sheet, []byte(cell.text)); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(t.sheet, `</t></is></c>`); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(t.sheet, `</row>`)
+	return err
+}
+
+func (t *xlsxTable) close() error {
+	if _, err := io.WriteString(t.sheet, xlsxSheetEnd); err != nil {
+		return err
+	}
+	return t.zw.Close()
+}
+
+// cellName returns the A1-style reference of a zero-based column and a
+// one-based row.
+func cellName(column, row int) string {
+	return columnName(column) + strconv.Itoa(row)
+}
+
+func columnName(column int) string {
+	name := ""
+	for column++; column > 0; column = (column - 1) / 26 {
+		name = string(rune('A'+(column-1)%26)) + name
+	}
+	return name
+}
+
+var (
+	xlsxMagic        = []byte("PK\x03\x04")
+	errSheetNotFound = errors.New("sheet not found")
+)
+
+type xlsxWorkbookFile struct {
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		ID   string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+// xlsxRecords reads the rows of one worksheet as records, streaming the sheet
+// XML. Blank rows are skipped and line is the spreadsheet row number.
+type xlsxRecords struct {
+	sheet   io.ReadCloser
+	decoder *xml.Decoder
+	shared  []string
+	line    int
+}
+
+func openXLSXSheet(r io.ReaderAt, size int64, sheet string) (*xlsxRecords, string, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, "", err
+	}
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	var workbook xlsxWorkbookFile
+	if err := decodeZipXML(files["xl/workbook.xml"], &workbook); err != nil {
+		return nil, "", err
+	}
+	var rels xlsxRelationships
+	if err := decodeZipXML(files["xl/_rels/workbook.xml.rels"], &rels); err != nil {
+		return nil, "", err
+	}
+
+	index := -1
+	if sheet == "" {
+		index = 0
+	} else if n, err := strconv.Atoi(sheet); err == nil {
+		index = n - 1
+	} else {
+		for i, s := range workbook.Sheets {
+			if s.Name == sheet {
+				index = i
+			}
+		}
+	}
+	if index < 0 || index >= len(workbook.Sheets) {
+		return nil, "", errSheetNotFound
+	}
+	name := workbook.Sheets[index].Name
+
+	var target string
+	for _, rel := range rels.Relationships {
+		if rel.ID == workbook.Sheets[index].ID {
+			target = rel.Target
+		}
+	}
+	if strings.HasPrefix(target, "/") {
+		target = strings.TrimPrefix(target, "/")
+	} else {
+		target = "xl/" + target
+	}
+	if files[target] == nil {
+		return nil, "", errSheetNotFound
+	}
+
+	var shared []string
+	if f := files["xl/sharedStrings.xml"]; f != nil {
+		if shared, err = readSharedStrings(f); err != nil {
+			return nil, "", err
+		}
+	}
+
+	src, err := files[target].Open()
+	if err != nil {
+		return nil, "", err
+	}
+	return &xlsxRecords{sheet: src, decoder: xml.NewDecoder(src), shared: shared}, name, nil
+}
+
+func (x *xlsxRecords) read() ([]string, int, error) {
+	for {
+		token, err := x.decoder.Token()
+		if err != nil {
+			return nil, 0, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "row" {
+			continue
+		}
+
+		x.line++
+		if r := xmlAttr(start, "r"); r != "" {
+			if line, err := strconv.Atoi(r); err == nil {
+				x.line = line
+			}
+		}
+
+		record, err := x.readRow()
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, value := range record {
+			if strings.TrimSpace(value) != "" {
+				return record, x.line, nil
+			}
+		}
+	}
+}
+
+func (x *xlsxRecords) readRow() ([]string, error) {
+	var record []string
+	for {
+		token, err := x.decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if t.Name.Local != "c" {
+				continue
+			}
+			column := len(record)
+			if ref := xmlAttr(t, "r"); ref != "" && columnIndex(ref) >= 0 {
+				column = columnIndex(ref)
+			}
+			value, err := x.readCell(xmlAttr(t, "t"))
+			if err != nil {
+				return nil, err
+			}
+			for len(record) <= column {
+				record = append(record, "")
+			}
+			record[column] = value
+		case xml.EndElement:
+			if t.Name.Local == "row" {
+				return record, nil
+			}
+		}
+	}
+}
+
+func (x *xlsxRecords) readCell(cellType string) (string, error) {
+	var value strings.Builder
+	var inText bool
+	for {
+		token, err := x.decoder.Token()
+		if err != nil {
+			return "", err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			inText = t.Name.Local == "v" || t.Name.Local == "t"
+		case xml.CharData:
+			if inText {
+				value.Write(t)
+			}
+		case xml.EndElement:
+			inText = false
+			if t.Name.Local != "c" {
+				continue
+			}
+			if cellType == "s" {
+				i, err := strconv.Atoi(value.String())
+				if err != nil || i < 0 || i >= len(x.shared) {
+					return "", fmt.Errorf("invalid shared string %q", value.String())
+				}
+				return x.shared[i], nil
+			}
+			return value.String(), nil
+		}
+	}
+}
+
+func (x *xlsxRecords) Close() error {
+	return x.sheet.Close()
+}
+
+func readSharedStrings(f *zip.File) ([]string, error) {
+	src, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	var shared []string
+	var item strings.Builder
+	var inText, inPhonetic bool
+	decoder := xml.NewDecoder(src)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return shared, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "si":
+				item.Reset()
+			case "rPh":
+				inPhonetic = true
+			case "t":
+				inText = !inPhonetic
+			}
+		case xml.CharData:
+			if inText {
+				item.Write(t)
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "si":
+				shared = append(shared, item.String())
+			case "rPh":
+				inPhonetic = false
+			case "t":
+				inText = false
+			}
+		}
+	}
+}
+
+func decodeZipXML(f *zip.File, v interface{}) error {
+	if f == nil {
+		return fmt.Errorf("invalid xlsx file")
+	}
+	src, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	return xml.NewDecoder(src).Decode(v)
+}
+
+func xmlAttr(start xml.StartElement, name string) string {
+	for _, attr := range start.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// columnIndex returns the zero-based column of an A1-style reference.
+func columnIndex(ref string) int {
+	column := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		column = column*26 + int(r-'A'+1)
+	}
+	return column - 1
+}
@@ -0,0 +1,138 @@
+// go:build unit
+
+package tax
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+type testSheet struct {
+	name string
+	rows string
+}
+
+func buildXLSX(t *testing.T, shared []string, sheets ...testSheet) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	write := func(name, body string) {
+		f, err := zw.Create(name)
+		assert.NoError(t, err)
+		f.Write([]byte(body))
+	}
+
+	var workbook, rels strings.Builder
+	for i, sheet := range sheets {
+		fmt.Fprintf(&workbook, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, sheet.name, i+1, i+1)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="worksheet" Target="worksheets/sheet%d.xml"/>`, i+1, i+1)
+		write(fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1), `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`+sheet.rows+`</sheetData></worksheet>`)
+	}
+	write("xl/workbook.xml", `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`+workbook.String()+`</sheets></workbook>`)
+	write("xl/_rels/workbook.xml.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`+rels.String()+`</Relationships>`)
+
+	var sst strings.Builder
+	for _, s := range shared {
+		fmt.Fprintf(&sst, `<si><t>%s</t></si>`, s)
+	}
+	write("xl/sharedStrings.xml", `<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`+sst.String()+`</sst>`)
+
+	assert.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+// payrollWorkbook has a cover sheet and a payroll sheet whose header uses
+// shared strings, skips an empty row and leaves a trailing cell out.
+func payrollWorkbook(t *testing.T) []byte {
+	return buildXLSX(t, []string{"totalIncome", "wht", "donation", "หมายเหตุ"},
+		testSheet{name: "Cover", rows: `<row r="1"><c r="A1" t="inlineStr"><is><t>สรุปเงินเดือน</t></is></c></row>`},
+		testSheet{name: "Payroll", rows: `<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c><c r="C1" t="s"><v>2</v></c></row>` +
+			`<row r="2"><c r="A2"><v>500000</v></c><c r="B2"><v>0</v></c><c r="C2"><v>1000</v></c></row>` +
+			`<row r="3"/>` +
+			`<row r="14"><c r="A14"><v>600000</v></c><c r="B14" t="s"><v>3</v></c></row>` +
+			`<row r="15"><c r="A15"><v>700000</v></c><c r="B15"><v>0</v></c></row>`},
+	)
+}
+
+func TestProcessTaxFileXLSX(t *testing.T) {
+	t.Run("given sheet name should read rows and point errors at cells", func(t *testing.T) {
+		p := New(&StubTax{})
+
+		sink := &collectSink{}
+		_, err := p.processTaxFile(context.Background(), bytesFile(payrollWorkbook(t)), fileOptions{mode: ModeLenient, sheet: "Payroll"}, sink)
+
+		assert.Equal(t, "", err.Message)
+		assert.Equal(t, []TaxResponseCSV{{TotalIncome: 500000}, {TotalIncome: 700000}}, sink.taxes)
+		assert.Equal(t, []RowError{{Line: 14, Column: "wht", Cell: "Payroll!B14", Code: CodeInvalidType, Message: "wht must be a numeric value", Text: newText("%s must be a numeric value", "wht")}}, sink.errors)
+	})
+	t.Run("given sheet index should read that sheet", func(t *testing.T) {
+		p := New(&StubTax{})
+
+		sink := &collectSink{}
+		rowErrors, err := p.processTaxFile(context.Background(), bytesFile(payrollWorkbook(t)), fileOptions{mode: ModeStrict, sheet: "2"}, sink)
+
+		assert.Equal(t, Err{Message: "wht must be a numeric value", text: newText("%s must be a numeric value", "wht")}, err)
+		assert.Equal(t, []RowError{{Line: 14, Column: "wht", Cell: "Payroll!B14", Code: CodeInvalidType, Message: "wht must be a numeric value", Text: newText("%s must be a numeric value", "wht")}}, rowErrors)
+	})
+	t.Run("given default sheet should read the first sheet", func(t *testing.T) {
+		p := New(&StubTax{})
+
+		_, err := p.processTaxFile(context.Background(), bytesFile(payrollWorkbook(t)), fileOptions{mode: ModeStrict}, &collectSink{})
+
+		assert.Equal(t, errorf("unknown column %q", "สรุปเงินเดือน"), err)
+	})
+	t.Run("given unknown sheet should return error message", func(t *testing.T) {
+		p := New(&StubTax{})
+
+		_, err := p.processTaxFile(context.Background(), bytesFile(payrollWorkbook(t)), fileOptions{mode: ModeStrict, sheet: "Bonus"}, &collectSink{})
+
+		assert.Equal(t, errorf("sheet %q not found", "Bonus"), err)
+	})
+	t.Run("given workbook written by the xlsx output should read it back", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		table, err := newXLSXTable(buf)
+		assert.NoError(t, err)
+		table.writeRow([]tableCell{textCell("totalIncome"), textCell("wht")})
+		table.writeRow([]tableCell{numberCell(160000), numberCell(0)})
+		assert.NoError(t, table.close())
+
+		p := New(&StubTax{})
+		sink := &collectSink{}
+		_, errFile := p.processTaxFile(context.Background(), bytesFile(buf.Bytes()), fileOptions{mode: ModeStrict}, sink)
+
+		assert.Equal(t, "", errFile.Message)
+		assert.Equal(t, []TaxResponseCSV{{TotalIncome: 160000}}, sink.taxes)
+	})
+}
+
+func TestCalculateTaxXLSXUpload(t *testing.T) {
+	t.Run("given xlsx file in taxFile should return status 200 and tax result", func(t *testing.T) {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		c := e.NewContext(uploadRequest("/tax/calculations/upload-csv?sheet=Payroll&mode=lenient", string(payrollWorkbook(t))), rec)
+
+		p := New(&StubTax{})
+
+		err := serve(c, p.CalculateTaxCSVHandler)
+
+		assert.NoError(t, err, "expected no error but got %v", err)
+		assert.Equal(t, http.StatusOK, rec.Code, "expected status code %d but got %d", http.StatusOK, rec.Code)
+		assert.Equal(t, `{"taxes":[{"totalIncome":500000,"tax":0},{"totalIncome":700000,"tax":0}],"errors":[{"line":14,"column":"wht","cell":"Payroll!B14","code":"INVALID_TYPE","message":"wht must be a numeric value"}],"summary":{"rows":3,"succeeded":2,"failed":1,"totalIncome":1200000,"totalTax":0,"totalRefund":0,"brackets":[],"averageEffectiveRate":0,"tax":{"min":0,"max":0,"p50":0,"p90":0,"p99":0}}}`, strings.TrimSuffix(rec.Body.String(), "\n"))
+	})
+}
+
+func TestColumnIndex(t *testing.T) {
+	assert.Equal(t, 0, columnIndex("A1"))
+	assert.Equal(t, 1, columnIndex("B14"))
+	assert.Equal(t, 26, columnIndex("AA3"))
+	assert.Equal(t, 52, columnIndex("BA10"))
+}